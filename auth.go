@@ -0,0 +1,170 @@
+package solidnet
+
+import (
+	"runtime/debug"
+
+	logger "github.com/idakun/tinylog"
+)
+
+// 认证结果
+const (
+	AUTH_RESULT_SUCCESS  = 0 // 认证成功
+	AUTH_RESULT_CONTINUE = 1 // 还需要更多的包，例如挑战-应答式的认证
+	AUTH_RESULT_FAIL     = 2 // 认证失败，断开连接
+)
+
+const (
+	AUTH_MAX_PACKETS = 3 // 登录前最多交给IAuthenticator的包数量
+)
+
+// 认证后的身份
+type Identity struct {
+	UserId int64
+	Roles  []string
+}
+
+func (i *Identity) HasRole(role string) bool {
+	if nil == i {
+		return false
+	}
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// 登录认证，在客户端的投递协程中调用，可以执行阻塞的操作（如查询数据库）而不影响逻辑协程。
+// 认证成功时返回身份，可以通过client.Send回复认证结果
+type IAuthenticator interface {
+	Authenticate(packet IPacket, client IClient) (*Identity, int)
+}
+
+// 登录认证阶段的配置。登录前收到的包：白名单中的命令照常投递到逻辑层，
+// 登录命令交给Authenticator，不投递到逻辑层，其他命令直接丢弃；
+// 没有设置CmdExtractor时无法区分命令，登录前的包全部交给Authenticator。
+// 认证成功后投递STATE_AUTHENTICATED状态消息，认证失败或者交给Authenticator和丢弃的包
+// 超过MaxPackets时断开连接。认证超时时间见Options.LoginAuthTime
+type Auth struct {
+	Authenticator IAuthenticator
+	CmdExtractor  CmdExtractor // 取命令字
+	MaxPackets    int
+	loginCmds     map[int32]bool
+	whitelist     map[int32]bool
+}
+
+// 创建登录认证配置，loginCmds为交给Authenticator的登录命令，
+// whitelist为登录前允许投递到逻辑层的命令
+func NewAuth(a IAuthenticator, extractor CmdExtractor, loginCmds []int32, whitelist ...int32) *Auth {
+	auth := new(Auth)
+	auth.Authenticator = a
+	auth.CmdExtractor = extractor
+	auth.MaxPackets = AUTH_MAX_PACKETS
+	auth.loginCmds = make(map[int32]bool)
+	for _, cmd := range loginCmds {
+		auth.loginCmds[cmd] = true
+	}
+	auth.whitelist = make(map[int32]bool)
+	for _, cmd := range whitelist {
+		auth.whitelist[cmd] = true
+	}
+	return auth
+}
+
+// 设置登录认证，必须在Run之前调用
+func (c *TcpClient) SetAuth(a *Auth) {
+	c.auth = a
+}
+
+// 登录前的认证阶段，返回true表示该包继续投递到逻辑层
+func (c *TcpClient) authenticate(data []byte) (dispatch bool) {
+	a := c.auth
+	if nil == a || c.GetLoginFlag() {
+		return true
+	}
+
+	defer func() {
+		if err := recover(); nil != err {
+			logger.Error("authenticate panic[%v], client[%s]\n%s", err, c.remoteAddr, debug.Stack())
+			dispatch = false
+			c.stop()
+		}
+	}()
+
+	if nil != a.CmdExtractor {
+		p := c.factory.NewPacket()
+		p.Refer(data)
+		cmd := a.CmdExtractor(p)
+		if a.whitelist[cmd] {
+			return true
+		}
+		if !a.loginCmds[cmd] {
+			// 登录前不允许的命令，丢弃
+			c.authPackets++
+			logger.Error("client[%s] send cmd[%d] before login, drop it", c.remoteAddr, cmd)
+			if c.authPackets >= a.MaxPackets {
+				c.closeWithReason(CLOSE_REASON_AUTH_FAILED)
+			}
+			return false
+		}
+	}
+
+	c.authPackets++
+	p := c.factory.NewPacket()
+	p.Refer(data)
+	identity, result := a.Authenticator.Authenticate(p, c)
+	switch result {
+	case AUTH_RESULT_SUCCESS:
+		// 先绑定用户id，重复登录被拒绝时连接不带有身份
+		if nil != identity && !c.BindUserId(identity.UserId) {
+			// 重复登录被拒绝，连接已经在关闭
+			return false
+		}
+		c.SetIdentity(identity)
+		c.SetLoginFlag(true)
+		c.loginAuthTimer.Stop()
+		c.notifyAuthenticated()
+	case AUTH_RESULT_CONTINUE:
+		if c.authPackets >= a.MaxPackets {
+			logger.Error("client[%s] not authenticated after %d packets, close it", c.remoteAddr, c.authPackets)
+			c.closeWithReason(CLOSE_REASON_AUTH_FAILED)
+		}
+	default:
+		logger.Error("client[%s] authenticate failed, close it", c.remoteAddr)
+		c.closeWithReason(CLOSE_REASON_AUTH_FAILED)
+	}
+	return false
+}
+
+// 和其他状态一样由状态协程投递STATE_AUTHENTICATED，保证它在STATE_CONNECTED之后。
+// 等待投递完成后再返回，登录后的网络消息都在STATE_AUTHENTICATED之后投递
+func (c *TcpClient) notifyAuthenticated() {
+	c.mutex.Lock()
+	if !c.isRunning() {
+		// 连接已经断开，STATE_CLOSED已经通知
+		c.mutex.Unlock()
+		return
+	}
+	c.notifyState(STATE_AUTHENTICATED)
+	c.mutex.Unlock()
+
+	select {
+	case <-c.authNotified:
+	case <-c.closeFlag:
+	}
+}
+
+// 设置认证后的身份
+func (c *BaseClient) SetIdentity(identity *Identity) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.identity = identity
+}
+
+// 认证后的身份，未认证时为nil
+func (c *BaseClient) GetIdentity() *Identity {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.identity
+}
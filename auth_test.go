@@ -0,0 +1,323 @@
+package solidnet
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testCmdLogin = 1
+	testCmdPing  = 5
+	testCmdGame  = 9
+)
+
+// 记录收到的命令，登录命令认证成功
+type testAuthenticator struct {
+	mutex  sync.Mutex
+	cmds   []int32
+	userId int64
+}
+
+func (a *testAuthenticator) Authenticate(p IPacket, c IClient) (*Identity, int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.cmds = append(a.cmds, testCmd(p))
+	return &Identity{UserId: a.userId}, AUTH_RESULT_SUCCESS
+}
+
+func (a *testAuthenticator) received() []int32 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]int32(nil), a.cmds...)
+}
+
+func runAuthClient(t *testing.T, a *testAuthenticator) (*TcpClient, *ChannelProcessor, net.Conn) {
+	p := NewChannelProcessorWithLen(100)
+	local, remote := net.Pipe()
+	c := NewTcpClient(local, p, testFactory{})
+	c.SetAuth(NewAuth(a, testCmd, []int32{testCmdLogin}, testCmdPing))
+	go c.Run()
+	if STATE_CONNECTED != waitState(t, p) {
+		t.Fatal("client is not connected")
+	}
+	return c, p, remote
+}
+
+func TestAuthCommands(t *testing.T) {
+	a := &testAuthenticator{}
+	c, p, remote := runAuthClient(t, a)
+	defer remote.Close()
+	defer c.stop()
+
+	// 登录前白名单命令照常投递，其他命令丢弃，只有登录命令交给Authenticator
+	remote.Write(testPacket(testCmdGame, 0))
+	remote.Write(testPacket(testCmdPing, 0))
+	if m, ok := waitMessage(p, 5*time.Second).(*NetMessage); !ok || testCmdPing != testCmd(&BasePacket{Data: m.packet}) {
+		t.Fatal("whitelisted command is not dispatched")
+	}
+	remote.Write(testPacket(testCmdLogin, 0))
+	if STATE_AUTHENTICATED != waitState(t, p) {
+		t.Fatal("client is not authenticated")
+	}
+	if cmds := a.received(); 1 != len(cmds) || testCmdLogin != cmds[0] {
+		t.Fatalf("authenticator received %v", cmds)
+	}
+
+	// 登录后的包都投递到逻辑层
+	remote.Write(testPacket(testCmdGame, 0))
+	if m, ok := waitMessage(p, 5*time.Second).(*NetMessage); !ok || testCmdGame != testCmd(&BasePacket{Data: m.packet}) {
+		t.Fatal("command is not dispatched after login")
+	}
+}
+
+func TestAuthStateOrder(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		p := NewChannelProcessorWithLen(100)
+		local, remote := net.Pipe()
+		c := NewTcpClient(local, p, testFactory{})
+		c.SetAuth(NewAuth(&testAuthenticator{}, testCmd, []int32{testCmdLogin}))
+		// 连接后立即登录，STATE_AUTHENTICATED也不能早于STATE_CONNECTED，登录后的包在它之后
+		go func() {
+			remote.Write(testPacket(testCmdLogin, 0))
+			remote.Write(testPacket(testCmdGame, 0))
+		}()
+		go c.Run()
+
+		var got []interface{}
+		for len(got) < 3 {
+			switch m := waitMessage(p, 5*time.Second).(type) {
+			case *StateMessage:
+				got = append(got, m.state)
+			case *NetMessage:
+				got = append(got, testCmd(&BasePacket{Data: m.packet}))
+			case nil:
+				t.Fatalf("timeout, got %v", got)
+			}
+		}
+		if int32(STATE_CONNECTED) != got[0] || int32(STATE_AUTHENTICATED) != got[1] || int32(testCmdGame) != got[2] {
+			t.Fatalf("got %v, want connected, authenticated, cmd %d", got, testCmdGame)
+		}
+		c.stop()
+		remote.Close()
+	}
+}
+
+func TestAuthDropLimit(t *testing.T) {
+	c, p, remote := runAuthClient(t, &testAuthenticator{})
+	defer remote.Close()
+	defer c.stop()
+
+	for i := 0; i < AUTH_MAX_PACKETS; i++ {
+		remote.Write(testPacket(testCmdGame, 0))
+	}
+	m, ok := waitMessage(p, 5*time.Second).(*StateMessage)
+	if !ok || STATE_CLOSED != m.state || CLOSE_REASON_AUTH_FAILED != m.reason {
+		t.Fatalf("got %v, want closed by auth failure", m)
+	}
+}
+
+func TestAuthRejectNewClearsIdentity(t *testing.T) {
+	index := newClientIndex()
+	index.DuplicateLogin = &DuplicateLogin{Policy: DUPLICATE_LOGIN_REJECT_NEW}
+	oldConn, _ := net.Pipe()
+	defer oldConn.Close()
+	old := NewTcpClient(oldConn, NewChannelProcessorWithLen(10), testFactory{})
+	index.addIndex(old)
+	old.BindUserId(7)
+
+	p := NewChannelProcessorWithLen(100)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := NewTcpClient(local, p, testFactory{})
+	c.SetAuth(NewAuth(&testAuthenticator{userId: 7}, testCmd, []int32{testCmdLogin}))
+	index.addIndex(c)
+	go c.Run()
+	defer c.stop()
+	if STATE_CONNECTED != waitState(t, p) {
+		t.Fatal("client is not connected")
+	}
+
+	remote.Write(testPacket(testCmdLogin, 0))
+	m, ok := waitMessage(p, 5*time.Second).(*StateMessage)
+	if !ok || STATE_CLOSED != m.state || CLOSE_REASON_LOGIN_REJECTED != m.reason {
+		t.Fatalf("got %v, want closed by duplicate login", m)
+	}
+	if nil != c.GetIdentity() || c.GetLoginFlag() {
+		t.Fatal("rejected client keeps the identity")
+	}
+}
+
+// 只实现IClient的客户端
+type legacyClient struct {
+	IClient
+}
+
+func TestSessionClientOptional(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := NewTcpClient(local, NewChannelProcessorWithLen(10), testFactory{})
+	defer c.stop()
+
+	// 会话和踢下线的方法放在可选接口中，只实现IClient的客户端不受影响
+	for _, client := range []IClient{c, NewConnector("test", nil, testFactory{})} {
+		if _, ok := client.(ISessionClient); !ok {
+			t.Fatalf("%T does not implement ISessionClient", client)
+		}
+	}
+	if _, ok := IClient(legacyClient{c}).(ISessionClient); ok {
+		t.Fatal("legacy client implements ISessionClient")
+	}
+	if KickClient(legacyClient{c}, CLOSE_REASON_AUTH_FAILED, nil) {
+		t.Fatal("legacy client is kicked")
+	}
+	if !KickClient(c, CLOSE_REASON_AUTH_FAILED, nil) || CLOSE_REASON_AUTH_FAILED != c.getCloseReason() {
+		t.Fatal("client is not kicked")
+	}
+}
+
+func TestLoginAuthTimeout(t *testing.T) {
+	p := NewChannelProcessorWithLen(100)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := NewTcpClient(local, p, testFactory{})
+	go c.Run()
+	defer c.stop()
+	if STATE_CONNECTED != waitState(t, p) {
+		t.Fatal("client is not connected")
+	}
+
+	// 登录超时和认证失败一样带原因断开
+	c.DoTimerAction(EVENT_LOGIN_AUTH_TIMER)
+	m, ok := waitMessage(p, 5*time.Second).(*StateMessage)
+	if !ok || STATE_CLOSED != m.state || CLOSE_REASON_AUTH_TIMEOUT != m.reason {
+		t.Fatalf("got %v, want closed by login timeout", m)
+	}
+}
+
+// 和TcpServer.runClient一样把客户端加入服务端，客户端结束后关闭done
+func runServerClient(t *testing.T, s *TcpServer, p *ChannelProcessor) (*TcpClient, net.Conn, chan struct{}) {
+	local, remote := net.Pipe()
+	c := NewTcpClient(local, p, testFactory{})
+	s.AddClient(local, c)
+	done := make(chan struct{})
+	go func() {
+		c.Run()
+		s.DelClient(local)
+		close(done)
+	}()
+	if STATE_CONNECTED != waitState(t, p) {
+		t.Fatal("client is not connected")
+	}
+	return c, remote, done
+}
+
+func waitDone(t *testing.T, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client is not removed from the server")
+	}
+}
+
+func TestSessionKickOld(t *testing.T) {
+	var takeover []IClient
+	s := NewTcpServer("", nil, testFactory{})
+	s.DuplicateLogin = &DuplicateLogin{
+		Policy: DUPLICATE_LOGIN_KICK_OLD,
+		Builder: func(f IPacketFactory, reason int32) []byte {
+			return testPacket(int(reason), 0)
+		},
+		OnTakeover: func(old IClient, new IClient) {
+			takeover = append(takeover, old, new)
+		},
+	}
+	oldP := NewChannelProcessorWithLen(100)
+	old, oldRemote, oldDone := runServerClient(t, s, oldP)
+	defer oldRemote.Close()
+	old.Set("level", 10)
+	old.Set("room", 1)
+	if !old.BindUserId(7) || s.GetClientByUserId(7) != old {
+		t.Fatal("old client is not bound")
+	}
+
+	p := NewChannelProcessorWithLen(100)
+	c, remote, done := runServerClient(t, s, p)
+	defer remote.Close()
+	defer c.stop()
+	c.Set("room", 2)
+	received := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, 4)
+		oldRemote.SetReadDeadline(time.Now().Add(5 * time.Second))
+		io.ReadFull(oldRemote, b)
+		received <- b
+	}()
+	if !c.BindUserId(7) {
+		t.Fatal("new client is rejected")
+	}
+
+	// 新连接接管索引，复制旧连接的属性，已有的属性不覆盖
+	if s.GetClientByUserId(7) != c {
+		t.Fatal("user id is not bound to the new client")
+	}
+	if level, _ := c.Get("level"); 10 != level {
+		t.Fatalf("level is %v, want 10 taken from the old client", level)
+	}
+	if room, _ := c.Get("room"); 2 != room {
+		t.Fatalf("room is %v, want 2 kept by the new client", room)
+	}
+	if 2 != len(takeover) || takeover[0] != IClient(old) || takeover[1] != IClient(c) {
+		t.Fatalf("OnTakeover got %v", takeover)
+	}
+
+	// 旧连接收到通知后断开
+	if b := <-received; CLOSE_REASON_DUPLICATE_LOGIN != testCmd(&BasePacket{Data: b}) {
+		t.Fatalf("old client received %v", b)
+	}
+	m, ok := waitMessage(oldP, 5*time.Second).(*StateMessage)
+	if !ok || STATE_CLOSED != m.state || CLOSE_REASON_DUPLICATE_LOGIN != m.reason {
+		t.Fatalf("got %v, want old client closed by duplicate login", m)
+	}
+	// 旧连接从服务端删除后，不影响新连接的索引
+	waitDone(t, oldDone)
+	if nil != s.GetClient(old.GetId()) || s.GetClientByUserId(7) != c {
+		t.Fatal("index is broken after the old client disconnects")
+	}
+	select {
+	case <-done:
+		t.Fatal("new client is closed")
+	default:
+	}
+}
+
+func TestSessionIndexAfterDisconnect(t *testing.T) {
+	s := NewTcpServer("", nil, testFactory{})
+	p := NewChannelProcessorWithLen(100)
+	c, remote, done := runServerClient(t, s, p)
+	c.BindUserId(7)
+	if s.GetClient(c.GetId()) != c || s.GetClientByUserId(7) != c {
+		t.Fatal("client is not indexed")
+	}
+	// 换绑用户id后旧的用户id查不到
+	c.BindUserId(8)
+	if nil != s.GetClientByUserId(7) || s.GetClientByUserId(8) != c {
+		t.Fatal("index is not updated after rebinding")
+	}
+
+	remote.Close()
+	if STATE_CLOSED != waitState(t, p) {
+		t.Fatal("client is not closed")
+	}
+	waitDone(t, done)
+	if nil != s.GetClient(c.GetId()) || nil != s.GetClientByUserId(8) {
+		t.Fatal("disconnected client is still indexed")
+	}
+	// 断开之后再绑定不会加回索引
+	c.BindUserId(9)
+	if nil != s.GetClientByUserId(9) {
+		t.Fatal("disconnected client is indexed again")
+	}
+}
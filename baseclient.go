@@ -4,7 +4,9 @@ import (
 	"errors"
 	"io"
 	"net"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	logger "github.com/idakun/tinylog"
@@ -17,10 +19,23 @@ const (
 	MAX_RECV_TIMEOUT    = 1
 )
 
-// 状态通知
+// 状态通知。STATE_IDLE和STATE_AUTHENTICATED不是连接或者断线，
+// 业务层按状态分别处理，不能把STATE_CLOSED之外的状态都当作连接
 const (
-	STATE_CONNECTED = 0 // 连接状态
-	STATE_CLOSED    = 1 // 断线状态
+	STATE_CONNECTED     = 0 // 连接状态
+	STATE_CLOSED        = 1 // 断线状态
+	STATE_IDLE          = 2 // 读空闲超过ReadIdleTime，只通知一次，随后断开连接并通知STATE_CLOSED(CLOSE_REASON_IDLE_TIMEOUT)
+	STATE_AUTHENTICATED = 3 // 登录认证成功，见Auth
+)
+
+// 断线原因，随STATE_CLOSED状态消息投递，见StateMessage.Reason
+const (
+	CLOSE_REASON_NONE            = 0 // 对端关闭、出错或者业务层主动关闭
+	CLOSE_REASON_IDLE_TIMEOUT    = 1 // 读空闲超时
+	CLOSE_REASON_AUTH_FAILED     = 2 // 登录认证失败
+	CLOSE_REASON_DUPLICATE_LOGIN = 3 // 同一个用户在其他连接登录，被踢下线
+	CLOSE_REASON_LOGIN_REJECTED  = 4 // 用户已经在其他连接登录，拒绝本次登录
+	CLOSE_REASON_AUTH_TIMEOUT    = 5 // 连接后LoginAuthTime内没有登录
 )
 
 type IClient interface {
@@ -32,27 +47,126 @@ type IClient interface {
 	GetLoginFlag() bool
 }
 
+// 带有会话信息的客户端，BaseClient和Connector都实现了该接口。
+// 不放在IClient中，已有的IClient实现不受影响
+type ISessionClient interface {
+	GetId() uint64         //连接id，进程内唯一
+	BindUserId(int64) bool //绑定用户id，重复登录被拒绝时返回false
+	GetUserId() int64
+	SetIdentity(*Identity) //设置认证后的身份
+	GetIdentity() *Identity
+	Set(key string, value interface{}) //设置属性，并发安全
+	Get(key string) (interface{}, bool)
+	Delete(key string)
+}
+
+// 支持不可靠发送的客户端，BaseClient和Connector都实现了该接口。
+// 不放在IClient中，已有的IClient实现不受影响
+type IUnreliableClient interface {
+	SendUnreliable(data []byte) bool //不可靠发送，传输层不支持时等同于Send
+}
+
+// 可以主动断开的客户端
+type ICloseableClient interface {
+	Close() //断开连接
+}
+
+// 可以带原因踢下线的客户端，BaseClient实现了该接口
+type IKickableClient interface {
+	Kick(reason int32, data []byte) //发送data后断开连接，断线的状态消息带有reason
+}
+
+// 不可靠发送，客户端没有实现IUnreliableClient时等同于Send
+func SendUnreliable(c IClient, data []byte) bool {
+	if u, ok := c.(IUnreliableClient); ok {
+		return u.SendUnreliable(data)
+	}
+	return c.Send(data)
+}
+
+// 断开客户端，客户端没有实现ICloseableClient时返回false
+func CloseClient(c IClient) bool {
+	closer, ok := c.(ICloseableClient)
+	if ok {
+		closer.Close()
+	}
+	return ok
+}
+
+// 发送data后断开客户端，断线的状态消息带有reason，客户端没有实现IKickableClient时返回false
+func KickClient(c IClient, reason int32, data []byte) bool {
+	k, ok := c.(IKickableClient)
+	if ok {
+		k.Kick(reason, data)
+	}
+	return ok
+}
+
 type BaseClient struct {
-	conn       net.Conn
-	input      chan []byte // 接受数据
-	output     chan []byte // 发送数据
-	state      chan int32  // 状态通知
-	mutex      sync.Mutex
-	remoteAddr string
-	localAddr  string
+	lastRead    int64       // 最后一次收到数据的时间，放在开头保证原子操作的对齐
+	lastWrite   int64       // 最后一次发送数据的时间
+	closeReason int32       // 断线原因，只记录第一次设置的原因
+	stopped     int32       // 连接已经断开，原子操作
+	conn        net.Conn    // 创建后不再修改，断开后的读写返回错误
+	input       chan []byte // 接受数据
+	output      chan []byte // 发送数据
+	state       chan int32  // 状态通知
+	mutex       sync.Mutex
+	closing     chan struct{} // 优雅关闭通知
+	closeOnce   sync.Once
+	remoteAddr  string
+	localAddr   string
+
+	factory    IPacketFactory
+	unreliable unreliableConn // 传输层支持不可靠发送时非空
+
+	inputOverload *InputOverload // input队列的过载策略，为空时使用OVERLOAD_POLICY_TIMEOUT
+	owner         IClient
+	clock         IClock
+	options       *Options
+	heartbeat     *Heartbeat // 应用层心跳，为空时不发送ping
+	idleWheel     *timingWheel
+	idleEntry     *wheelEntry // 空闲检查的定时项，设置了空闲超时时非空
+	identity      *Identity   // 认证后的身份
 
-	factory IPacketFactory
+	id         uint64                  // 连接id
+	userId     int64                   // 绑定的用户id
+	attributes map[string]interface{}  // 属性
+	onBind     func(userId int64) bool // 绑定用户id时的回调
+}
+
+// 支持不可靠收发的连接（如可靠udp会话）需要实现的接口
+type unreliableConn interface {
+	WriteUnreliable(data []byte) error
+	SetUnreliableHandler(func([]byte))
 }
 
 func NewBaseClient(conn net.Conn, f IPacketFactory) *BaseClient {
+	return NewBaseClientWithClock(conn, f, RealClock)
+}
+
+// 创建使用指定时钟的客户端，测试时可以使用FakeClock
+func NewBaseClientWithClock(conn net.Conn, f IPacketFactory, clock IClock) *BaseClient {
+	return newBaseClient(conn, f, clock, defaultOptions)
+}
+
+func newBaseClient(conn net.Conn, f IPacketFactory, clock IClock, o *Options) *BaseClient {
 	c := new(BaseClient)
-	c.output = make(chan []byte, MAX_CHANNEL_LEN)
-	c.input = make(chan []byte, MAX_CHANNEL_LEN)
-	c.state = make(chan int32, MAX_CHANNEL_LEN)
+	c.id = nextClientId()
+	c.clock = clock
+	c.options = o
+	c.output = make(chan []byte, o.MaxChannelLen)
+	c.input = make(chan []byte, o.MaxChannelLen)
+	c.state = make(chan int32, o.MaxChannelLen)
+	c.closing = make(chan struct{})
 	c.factory = f
 	c.conn = conn
 	c.remoteAddr = conn.RemoteAddr().String()
 	c.localAddr = conn.LocalAddr().String()
+	if uc, ok := conn.(unreliableConn); ok {
+		c.unreliable = uc
+		uc.SetUnreliableHandler(c.recvUnreliable)
+	}
 
 	c.run()
 	return c
@@ -62,12 +176,31 @@ func (c *BaseClient) Send(data []byte) bool {
 	select {
 	case c.output <- data:
 		return true
-	case <-time.After(time.Second * MAX_SEND_TIMEOUT):
+	default:
+	}
+	timer := c.clock.NewTimer(time.Second * time.Duration(c.options.SendTimeout))
+	defer timer.Stop()
+	select {
+	case c.output <- data:
+		return true
+	case <-timer.C():
 		logger.Fatal("Send() timeout!!!")
 		return false
 	}
 }
 
+func (c *BaseClient) SendUnreliable(data []byte) bool {
+	if nil == c.unreliable {
+		return c.Send(data)
+	}
+	err := c.unreliable.WriteUnreliable(data)
+	if nil != err {
+		logger.Error("WriteUnreliable() failed, error[%s]", err.Error())
+		return false
+	}
+	return true
+}
+
 func (c *BaseClient) SendSync(data []byte) (int32, error) {
 	defer func() {
 		err := recover()
@@ -83,9 +216,37 @@ func (c *BaseClient) SendSync(data []byte) (int32, error) {
 		logger.Error("c.conn.Write() failed, error[%s]", err.Error())
 		c.stop()
 	}
+	c.touchWrite()
 	return int32(n), err
 }
 
+func (c *BaseClient) Close() {
+	c.stop()
+}
+
+// 发送data后断开连接，断线的状态消息带有reason，data为空时直接断开
+func (c *BaseClient) Kick(reason int32, data []byte) {
+	c.setCloseReason(reason)
+	if nil != data {
+		c.trySend(data)
+	}
+	c.Shutdown(c.clock.Now().Add(time.Second * time.Duration(c.options.SendTimeout)))
+}
+
+func (c *BaseClient) setCloseReason(reason int32) {
+	atomic.CompareAndSwapInt32(&c.closeReason, CLOSE_REASON_NONE, reason)
+}
+
+func (c *BaseClient) getCloseReason() int32 {
+	return atomic.LoadInt32(&c.closeReason)
+}
+
+// 带原因断开连接
+func (c *BaseClient) closeWithReason(reason int32) {
+	c.setCloseReason(reason)
+	c.stop()
+}
+
 func (c *BaseClient) LocalAddr() string {
 	return c.localAddr
 }
@@ -94,8 +255,13 @@ func (c *BaseClient) RemoteAddr() string {
 	return c.remoteAddr
 }
 
-// 启动2个协程，如果对端关闭或者出错，2个协程会先后退出
+// 启动2个协程，如果对端关闭或者出错，2个协程会先后退出。
+// 设置了空闲超时时在时钟对应的时间轮上定时检查空闲，不单独启动协程
 func (c *BaseClient) run() {
+	c.touchRead()
+	c.touchWrite()
+	c.startIdleCheck()
+
 	// 启动接受数据的协程
 	go c.recv()
 
@@ -106,24 +272,58 @@ func (c *BaseClient) run() {
 	c.notifyState(STATE_CONNECTED)
 }
 
+// 收发协程、优雅关闭和业务层都会检查，用原子操作读取
 func (c *BaseClient) isRunning() bool {
-	return nil != c.conn
+	return 0 == atomic.LoadInt32(&c.stopped)
 }
 
 func (c *BaseClient) stop() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if !c.isRunning() {
+	if !atomic.CompareAndSwapInt32(&c.stopped, 0, 1) {
 		return
 	}
 	c.conn.Close()
-	c.conn = nil
+	c.stopIdleCheck()
 
 	// 向应用层通知断线
 	c.notifyState(STATE_CLOSED)
 }
 
+// 优雅关闭：通知发送协程把output中剩余的数据发送完毕后再断开连接，
+// deadline为发送剩余数据的最后期限，零值表示不限制
+func (c *BaseClient) Shutdown(deadline time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.isRunning() {
+		return
+	}
+	if !deadline.IsZero() {
+		c.conn.SetWriteDeadline(deadline)
+	}
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+}
+
+// 把output中剩余的数据全部发送出去，不再等待新的数据
+func (c *BaseClient) flush() {
+	for {
+		select {
+		case data := <-c.output:
+			_, err := c.conn.Write(data)
+			if nil != err {
+				logger.Error("c.conn.Write() failed, error[%s]", err.Error())
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
 func (c *BaseClient) notifyState(state int32) {
 	select {
 	case c.state <- state:
@@ -136,7 +336,9 @@ func (c *BaseClient) send() {
 	defer func() {
 		err := recover()
 		if nil != err {
-			logger.Fatal("panic[%v]", err)
+			// 发送协程已经退出，断开连接，避免连接无法发送数据却一直存在
+			logger.Fatal("panic[%v], client[%s]\n%s", err, c.remoteAddr, debug.Stack())
+			c.stop()
 		}
 	}()
 
@@ -146,6 +348,7 @@ func (c *BaseClient) send() {
 			return
 		}
 
+		timer := c.clock.NewTimer(time.Second * MAX_RECV_TIMEOUT)
 		select {
 
 		case data := <-c.output:
@@ -154,9 +357,17 @@ func (c *BaseClient) send() {
 				logger.Error("c.conn.Write() failed, error[%s]", err.Error())
 				c.stop()
 			}
-		case <-time.After(time.Second * MAX_RECV_TIMEOUT):
+			c.touchWrite()
+		case <-c.closing:
+			// 优雅关闭，发送完剩余数据后断开连接并退出协程
+			timer.Stop()
+			c.flush()
+			c.stop()
+			return
+		case <-timer.C():
 			// Do nothing
 		}
+		timer.Stop()
 	}
 }
 
@@ -164,7 +375,9 @@ func (c *BaseClient) recv() {
 	defer func() {
 		err := recover()
 		if nil != err {
-			logger.Fatal("panic[%v]", err)
+			// 接收协程已经退出，断开连接，避免连接无法接收数据却一直存在
+			logger.Fatal("panic[%v], client[%s]\n%s", err, c.remoteAddr, debug.Stack())
+			c.stop()
 		}
 	}()
 
@@ -185,10 +398,20 @@ func (c *BaseClient) recv() {
 		}
 
 		p.WriteBytes(head)
+		if !c.recvMoreHead(p) {
+			c.stop()
+			continue
+		}
 		bodyLen := p.GetBodyLen()
-		if bodyLen >= MAX_USER_PACKET_LEN {
+		if bodyLen < 0 {
+			// 长度字段不合法，无法再找到下一个包的边界
+			logger.Error("invalid body length field, client[%s]", c.remoteAddr)
+			c.stop()
+			continue
+		}
+		if bodyLen >= c.options.MaxUserPacketLen {
 			// 包体太长，有可能是网络攻击包或者错误包，丢掉不处理
-			logger.Error("length of uesr packet more than MAX_USER_PACKET_LEN, bodyLen=%d", bodyLen)
+			logger.Error("length of uesr packet more than MaxUserPacketLen, bodyLen=%d", bodyLen)
 			continue
 		}
 
@@ -201,10 +424,132 @@ func (c *BaseClient) recv() {
 			continue
 		}
 		p.WriteBytes(bodyData)
+		c.touchRead()
+		if c.handleHeartbeat(p.GetData()) {
+			continue
+		}
+		c.pushInput(p.GetData())
+	}
+}
+
+// 包头长度可变时继续读取包头，直到包头完整
+func (c *BaseClient) recvMoreHead(p IPacket) bool {
+	v, ok := p.(IVarHeadPacket)
+	if !ok {
+		return true
+	}
+	for more := v.MoreHeadLen(); more > 0; more = v.MoreHeadLen() {
+		head := make([]byte, more)
+		_, err := io.ReadFull(c.conn, head)
+		if nil != err {
+			logger.Error("io.ReadFull() failed, error[%s]", err.Error())
+			return false
+		}
+		p.WriteBytes(head)
+	}
+	return true
+}
+
+// 设置input队列的过载策略，owner为回调时传给OnOverload的客户端
+func (c *BaseClient) setInputOverload(o *InputOverload, owner IClient) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.inputOverload = o
+	c.owner = owner
+}
+
+// 把收到的数据放入input队列，队列已满时按过载策略处理
+func (c *BaseClient) pushInput(data []byte) {
+	select {
+	case c.input <- data:
+		return
+	default:
+	}
+
+	c.mutex.Lock()
+	o := c.inputOverload
+	owner := c.owner
+	c.mutex.Unlock()
+	policy := OVERLOAD_POLICY_TIMEOUT
+	if nil != o {
+		policy = o.Policy
+		if nil != o.OnOverload {
+			policy = o.OnOverload(owner, data)
+		}
+		o.Stats.add(policy)
+	}
+
+	switch policy {
+	case OVERLOAD_POLICY_BLOCK:
+		for {
+			timer := c.clock.NewTimer(time.Second * MAX_RECV_TIMEOUT)
+			select {
+			case c.input <- data:
+				timer.Stop()
+				return
+			case <-timer.C():
+				// 连接已经关闭，不再等待
+				if !c.isRunning() {
+					return
+				}
+			}
+		}
+	case OVERLOAD_POLICY_DROP_NEWEST, OVERLOAD_POLICY_REJECT:
+		logger.Error("input channel is already full, drop newest packet!!!")
+	case OVERLOAD_POLICY_DROP_OLDEST:
+		for {
+			select {
+			case c.input <- data:
+				return
+			default:
+			}
+			select {
+			case <-c.input:
+				logger.Error("input channel is already full, drop oldest packet!!!")
+			default:
+			}
+		}
+	case OVERLOAD_POLICY_DISCONNECT:
+		logger.Error("input channel is already full, disconnect client[%s]!!!", c.remoteAddr)
+		c.stop()
+	default:
+		timer := c.clock.NewTimer(time.Second * MAX_RECV_TIMEOUT)
 		select {
-		case c.input <- p.GetData():
-		case <-time.After(time.Second * MAX_RECV_TIMEOUT):
+		case c.input <- data:
+		case <-timer.C():
 			logger.Fatal("input channel is already full!!!")
 		}
+		timer.Stop()
+	}
+}
+
+// 处理收到的不可靠数据，每次收到的数据必须是一个完整的业务包
+func (c *BaseClient) recvUnreliable(data []byte) {
+	p := c.factory.NewPacket()
+	headLen := p.GetHeadLen()
+	if int32(len(data)) < headLen {
+		logger.Error("length of unreliable packet less than head, len=%d", len(data))
+		return
+	}
+	p.WriteBytes(data)
+	if v, ok := p.(IVarHeadPacket); ok && v.MoreHeadLen() > 0 {
+		logger.Error("head of unreliable packet is incomplete, len=%d", len(data))
+		return
+	}
+	headLen = p.GetHeadLen()
+	bodyLen := p.GetBodyLen()
+	if bodyLen < 0 || bodyLen >= c.options.MaxUserPacketLen || headLen+bodyLen != int32(len(data)) {
+		logger.Error("length of unreliable packet is error, len=%d, bodyLen=%d", len(data), bodyLen)
+		return
+	}
+	c.touchRead()
+	if c.handleHeartbeat(p.GetData()) {
+		return
+	}
+	select {
+	case c.input <- p.GetData():
+	default:
+		// 不可靠数据允许丢弃，不阻塞接收协程
+		logger.Error("input channel is already full, drop unreliable packet!!!")
 	}
 }
@@ -0,0 +1,179 @@
+package solidnet
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 时钟，solidnet中的定时器和超时等待都通过时钟计时，测试时可以替换为FakeClock
+type IClock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time // 到期前不会释放，循环中的等待使用NewTimer并在结束后Stop
+	NewTimer(d time.Duration) IChanTimer
+	AfterFunc(d time.Duration, f func()) IClockTimer
+}
+
+type IClockTimer interface {
+	Stop() bool
+}
+
+// 到期时向C发送当前时间的定时器，不再等待时调用Stop释放
+type IChanTimer interface {
+	IClockTimer
+	C() <-chan time.Time
+}
+
+// 真实时钟
+var RealClock IClock = realClock{}
+
+type realClock struct {
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) IChanTimer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) IClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// 手动推进的时钟，用于测试。Advance在调用者的协程中按时间顺序同步执行所有到期的回调，
+// 处理器队列有空间时，定时器的超时消息在Advance返回前已经投递到处理器，测试结果是确定的
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	seq     uint64
+	waiters []*fakeWaiter
+	wheel   *timingWheel // 该时钟的定时器共用的时间轮
+}
+
+type fakeWaiter struct {
+	clock *FakeClock
+	at    time.Time
+	seq   uint64 // 到期时间相同时按添加的顺序执行
+	f     func()
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	c := new(FakeClock)
+	c.now = start
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) IChanTimer {
+	ch := make(chan time.Time, 1)
+	w := c.AfterFunc(d, func() {
+		ch <- c.Now()
+	})
+	return &fakeChanTimer{w.(*fakeWaiter), ch}
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) IClockTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seq++
+	w := &fakeWaiter{clock: c, at: c.now.Add(d), seq: c.seq, f: f}
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+// 推进时钟，执行到期的回调，回调中新增的到期回调也会在本次执行
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+	for {
+		sort.Slice(c.waiters, func(i, j int) bool {
+			if c.waiters[i].at.Equal(c.waiters[j].at) {
+				return c.waiters[i].seq < c.waiters[j].seq
+			}
+			return c.waiters[i].at.Before(c.waiters[j].at)
+		})
+		if 0 == len(c.waiters) || c.waiters[0].at.After(target) {
+			break
+		}
+		w := c.waiters[0]
+		c.waiters = c.waiters[1:]
+		if w.at.After(c.now) {
+			c.now = w.at
+		}
+		c.mutex.Unlock()
+		w.f()
+		c.mutex.Lock()
+	}
+	c.now = target
+	c.mutex.Unlock()
+}
+
+// 时钟对应的时间轮，不放入全局的时间轮表，时钟释放后时间轮随之释放
+func (c *FakeClock) timingWheel() *timingWheel {
+	c.mutex.Lock()
+	w := c.wheel
+	c.mutex.Unlock()
+	if nil != w {
+		return w
+	}
+	// newTimingWheel会读取当前时间，不能在加锁时创建
+	w = newTimingWheel(WHEEL_TICK, c)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nil == c.wheel {
+		c.wheel = w
+	}
+	return c.wheel
+}
+
+// 等待中的回调数量，包括时间轮的驱动回调和After产生的等待
+func (c *FakeClock) Waiters() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.waiters)
+}
+
+type fakeChanTimer struct {
+	*fakeWaiter
+	c chan time.Time
+}
+
+func (t *fakeChanTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (w *fakeWaiter) Stop() bool {
+	c := w.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package solidnet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFakeClockAdvanceOrder(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewFakeClock(start)
+	var fired []time.Duration
+	add := func(d time.Duration) {
+		clock.AfterFunc(d, func() {
+			fired = append(fired, clock.Now().Sub(start))
+		})
+	}
+	add(30 * time.Millisecond)
+	add(10 * time.Millisecond)
+	clock.AfterFunc(20*time.Millisecond, func() {
+		fired = append(fired, clock.Now().Sub(start))
+		// 回调中新增的到期回调在本次Advance中执行
+		add(5 * time.Millisecond)
+	})
+	add(40 * time.Millisecond)
+
+	clock.Advance(30 * time.Millisecond)
+	want := []time.Duration{10, 20, 25, 30}
+	if len(want) != len(fired) {
+		t.Fatalf("fired %v", fired)
+	}
+	for i := range want {
+		if want[i]*time.Millisecond != fired[i] {
+			t.Fatalf("fired %v", fired)
+		}
+	}
+	if 1 != clock.Waiters() {
+		t.Fatalf("%d waiters, want 1", clock.Waiters())
+	}
+}
+
+func TestFakeClockTimer(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewFakeClock(start)
+	timer := clock.NewTimer(time.Second)
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fires early")
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	select {
+	case now := <-timer.C():
+		if !now.Equal(start.Add(time.Second)) {
+			t.Fatalf("timer fires at %s", now)
+		}
+	default:
+		t.Fatal("timer does not fire")
+	}
+	if timer.Stop() {
+		t.Fatal("stop a fired timer")
+	}
+
+	// 停止的定时器不再占用时钟
+	for i := 0; i < 100; i++ {
+		timer := clock.NewTimer(time.Second)
+		if !timer.Stop() {
+			t.Fatal("stop a waiting timer failed")
+		}
+	}
+	if 0 != clock.Waiters() {
+		t.Fatalf("%d waiters after stop", clock.Waiters())
+	}
+}
+
+func TestFakeClockWheelIdle(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	p := NewChannelProcessorWithLen(10)
+	timer := NewTimerWithClock(1, &countTimerHandler{}, p, clock)
+	if 0 != clock.Waiters() {
+		t.Fatal("idle timing wheel is driven")
+	}
+	timer.Start(50*time.Millisecond, false)
+	clock.Advance(time.Second)
+	if 1 != len(p.messageChannel) {
+		t.Fatalf("fired %d times", len(p.messageChannel))
+	}
+	// 定时器都已经触发，时间轮停止驱动
+	if 0 != clock.Waiters() {
+		t.Fatalf("%d waiters after all timers fired", clock.Waiters())
+	}
+
+	// 空闲很久之后重新启动的定时器按当前时间计时
+	clock.Advance(time.Hour)
+	timer.Start(50*time.Millisecond, false)
+	clock.Advance(40 * time.Millisecond)
+	if 1 != len(p.messageChannel) {
+		t.Fatal("timer fires early after idle")
+	}
+	clock.Advance(10 * time.Millisecond)
+	if 2 != len(p.messageChannel) {
+		t.Fatal("timer does not fire after idle")
+	}
+}
+
+func TestFakeClockClientWaiters(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	p := NewChannelProcessorWithLen(2000)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := NewTcpClientWithClock(local, p, testFactory{}, clock)
+	go c.Run()
+	defer c.stop()
+
+	// 时钟不推进，收发协程的等待在select结束后都要释放
+	const N = 1000
+	go func() {
+		for i := 0; i < N; i++ {
+			remote.Write(testPacket(i, 8))
+		}
+	}()
+	for i := 0; i < N; {
+		m := waitMessage(p, 5*time.Second)
+		if nil == m {
+			t.Fatalf("timeout, received %d of %d", i, N)
+		}
+		if _, ok := m.(*NetMessage); ok {
+			i++
+		}
+	}
+	if n := clock.Waiters(); n > 10 {
+		t.Fatalf("%d waiters after %d packets", n, N)
+	}
+}
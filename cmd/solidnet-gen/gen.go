@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+const (
+	SOLIDNET_IMPORT = "github.com/idakun/solidnet"
+)
+
+type generator struct {
+	buf    bytes.Buffer
+	file   *File
+	source string
+}
+
+// 生成Go代码，source为协议描述文件的名字，写在文件头中
+func Generate(f *File, source string) ([]byte, error) {
+	g := &generator{file: f, source: source}
+	g.header()
+	g.commands()
+	for _, m := range f.Messages {
+		g.message(m)
+	}
+	g.handlers()
+
+	code, err := format.Source(g.buf.Bytes())
+	if nil != err {
+		return g.buf.Bytes(), fmt.Errorf("format generated code: %s", err.Error())
+	}
+	return code, nil
+}
+
+func (g *generator) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+func (g *generator) header() {
+	g.p("// Code generated by solidnet-gen. DO NOT EDIT.")
+	g.p("// source: %s", g.source)
+	g.p("")
+	g.p("package %s", g.file.Package)
+	g.p("")
+	g.p("import (")
+	g.p("\"fmt\"")
+	g.p("")
+	g.p("solidnet %q", SOLIDNET_IMPORT)
+	g.p(")")
+	g.p("")
+}
+
+func (g *generator) commands() {
+	g.p("// 命令字")
+	g.p("type Command int32")
+	g.p("")
+	if len(g.file.Commands) > 0 {
+		g.p("const (")
+		for _, c := range g.file.Commands {
+			g.p("%s Command = 0x%x", c.Name, c.Value)
+		}
+		g.p(")")
+		g.p("")
+	}
+	g.p("func (c Command) String() string {")
+	g.p("switch c {")
+	for _, c := range g.file.Commands {
+		g.p("case %s:", c.Name)
+		g.p("return %q", c.Name)
+	}
+	g.p("}")
+	g.p("return fmt.Sprintf(\"Command(0x%%x)\", int32(c))")
+	g.p("}")
+	g.p("")
+}
+
+func (g *generator) message(m *Message) {
+	if "" != m.Cmd {
+		g.p("// 命令字%s", m.Cmd)
+	}
+	g.p("type %s struct {", m.Name)
+	for _, field := range m.Fields {
+		if len(field.Options) > 0 {
+			g.p("%s %s `solidnet:\"%s\"`", field.Name, goType(field.Type), strings.Join(field.Options, ","))
+		} else {
+			g.p("%s %s", field.Name, goType(field.Type))
+		}
+	}
+	g.p("}")
+	g.p("")
+
+	if "" != m.Cmd {
+		g.p("func (m *%s) Cmd() Command {", m.Name)
+		g.p("return %s", m.Cmd)
+		g.p("}")
+		g.p("")
+	}
+
+	g.p("// 把消息追加到p中，包头需要调用者写入")
+	g.p("func (m *%s) Encode(p solidnet.IPacketEx) {", m.Name)
+	for _, field := range m.Fields {
+		g.encode(field.Type, "m."+field.Name, 0)
+	}
+	g.p("}")
+	g.p("")
+
+	g.p("// 从p的当前位置读取消息，数据不足时返回p.Err()")
+	g.p("func (m *%s) Decode(p solidnet.IPacketEx) error {", m.Name)
+	for _, field := range m.Fields {
+		g.decode(field.Type, "m."+field.Name, 0)
+	}
+	g.p("return p.Err()")
+	g.p("}")
+	g.p("")
+}
+
+func goType(t *Type) string {
+	switch {
+	case t.IsSlice():
+		return "[]" + goType(t.Elem)
+	case t.IsArray():
+		return fmt.Sprintf("[%d]%s", t.Len, goType(t.Elem))
+	case "bytes" == t.Name:
+		return "[]byte"
+	}
+	return t.Name
+}
+
+// 字节序对应的方法后缀
+func suffix(t *Type) string {
+	if t.BigEndian {
+		return "B"
+	}
+	return ""
+}
+
+func methodType(name string) string {
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func (g *generator) encode(t *Type, expr string, depth int) {
+	switch {
+	case t.IsSlice():
+		g.encodeLen(t, "len("+expr+")")
+		fallthrough
+	case t.IsArray():
+		i := fmt.Sprintf("i%d", depth)
+		g.p("for %s := range %s {", i, expr)
+		g.encode(t.Elem, expr+"["+i+"]", depth+1)
+		g.p("}")
+		return
+	}
+
+	switch t.Name {
+	case "bool":
+		g.p("p.WriteBool(%s)", expr)
+	case "int8":
+		g.p("p.WriteByte(byte(%s))", expr)
+	case "uint8":
+		g.p("p.WriteUint8(%s)", expr)
+	case "int16", "int32", "int64":
+		if t.Varint {
+			g.p("p.WriteVarint(int64(%s))", expr)
+		} else {
+			g.p("p.Write%s%s(%s)", methodType(t.Name), suffix(t), expr)
+		}
+	case "uint16", "uint32", "uint64":
+		if t.Varint {
+			g.p("p.WriteUvarint(uint64(%s))", expr)
+		} else {
+			g.p("p.Write%s%s(%s)", methodType(t.Name), suffix(t), expr)
+		}
+	case "float32", "float64":
+		g.p("p.Write%s%s(%s)", methodType(t.Name), suffix(t), expr)
+	case "string":
+		switch {
+		case t.Size >= 0:
+			g.p("p.WriteFixedString(%s, %d)", expr, t.Size)
+		case !t.BigEndian && ("" == t.LenWidth || "4" == t.LenWidth):
+			g.p("p.WriteString(%s)", expr)
+		default:
+			// 和WriteString一样以'\0'结尾，长度包含'\0'
+			g.encodeLen(t, "len("+expr+")+1")
+			g.p("p.WriteBytes([]byte(%s))", expr)
+			g.p("p.WriteByte(0)")
+		}
+	case "bytes":
+		switch {
+		case t.Size >= 0:
+			g.p("{")
+			g.p("buf := make([]byte, %d)", t.Size)
+			g.p("copy(buf, %s)", expr)
+			g.p("p.WriteBytes(buf)")
+			g.p("}")
+		case "" == t.LenWidth || "4" == t.LenWidth:
+			g.p("p.WriteLenBytes%s(%s)", suffix(t), expr)
+		default:
+			g.encodeLen(t, "len("+expr+")")
+			g.p("p.WriteBytes(%s)", expr)
+		}
+	default:
+		g.p("%s.Encode(p)", expr)
+	}
+}
+
+func (g *generator) encodeLen(t *Type, n string) {
+	switch t.LenWidth {
+	case "1":
+		g.p("p.WriteUint8(uint8(%s))", n)
+	case "2":
+		g.p("p.WriteUint16%s(uint16(%s))", suffix(t), n)
+	case "varint":
+		g.p("p.WriteUvarint(uint64(%s))", n)
+	default:
+		g.p("p.WriteUint32%s(uint32(%s))", suffix(t), n)
+	}
+}
+
+func (g *generator) decode(t *Type, expr string, depth int) {
+	switch {
+	case t.IsSlice():
+		n := fmt.Sprintf("n%d", depth)
+		g.p("{")
+		g.decodeLen(t, n, expr)
+		g.p("%s = make(%s, %s)", expr, goType(t), n)
+		g.p("}")
+		fallthrough
+	case t.IsArray():
+		i := fmt.Sprintf("i%d", depth)
+		g.p("for %s := range %s {", i, expr)
+		g.decode(t.Elem, expr+"["+i+"]", depth+1)
+		g.p("if nil != p.Err() {")
+		g.p("return p.Err()")
+		g.p("}")
+		g.p("}")
+		return
+	}
+
+	switch t.Name {
+	case "bool":
+		g.p("%s = p.ReadBool()", expr)
+	case "int8":
+		g.p("%s = int8(p.ReadByte())", expr)
+	case "uint8":
+		g.p("%s = p.ReadUint8()", expr)
+	case "int16", "int32", "int64":
+		if t.Varint {
+			g.p("%s = %s(p.ReadVarint())", expr, t.Name)
+		} else {
+			g.p("%s = p.Read%s%s()", expr, methodType(t.Name), suffix(t))
+		}
+	case "uint16", "uint32", "uint64":
+		if t.Varint {
+			g.p("%s = %s(p.ReadUvarint())", expr, t.Name)
+		} else {
+			g.p("%s = p.Read%s%s()", expr, methodType(t.Name), suffix(t))
+		}
+	case "float32", "float64":
+		g.p("%s = p.Read%s%s()", expr, methodType(t.Name), suffix(t))
+	case "string":
+		switch {
+		case t.Size >= 0:
+			g.p("%s = p.ReadFixedString(%d)", expr, t.Size)
+		case !t.BigEndian && ("" == t.LenWidth || "4" == t.LenWidth):
+			g.p("%s = p.ReadString()", expr)
+		default:
+			// 和ReadString一样剔除末尾的'\0'
+			n := fmt.Sprintf("n%d", depth)
+			g.p("{")
+			g.decodeLen(t, n, expr)
+			g.p("b := p.ReadBytes(%s)", n)
+			g.p("if len(b) > 0 && 0 == b[len(b)-1] {")
+			g.p("b = b[:len(b)-1]")
+			g.p("}")
+			g.p("%s = string(b)", expr)
+			g.p("}")
+		}
+	case "bytes":
+		// 复制一份，不引用包中的数据；空数据解码为空切片，和solidnet.Unmarshal一致
+		switch {
+		case t.Size >= 0:
+			g.p("%s = append([]byte{}, p.ReadBytes(%d)...)", expr, t.Size)
+		case "" == t.LenWidth || "4" == t.LenWidth:
+			g.p("%s = append([]byte{}, p.ReadLenBytes%s()...)", expr, suffix(t))
+		default:
+			n := fmt.Sprintf("n%d", depth)
+			g.p("{")
+			g.decodeLen(t, n, expr)
+			g.p("%s = append([]byte{}, p.ReadBytes(%s)...)", expr, n)
+			g.p("}")
+		}
+	default:
+		g.p("if err := %s.Decode(p); nil != err {", expr)
+		g.p("return err")
+		g.p("}")
+	}
+}
+
+// 读取长度前缀到变量n，长度超过包长时返回错误，避免错误包造成大量的内存分配
+func (g *generator) decodeLen(t *Type, n string, expr string) {
+	switch t.LenWidth {
+	case "1":
+		g.p("%s := int32(p.ReadUint8())", n)
+	case "2":
+		g.p("%s := int32(p.ReadUint16%s())", n, suffix(t))
+	case "varint":
+		g.p("%s := int32(-1)", n)
+		g.p("if v := p.ReadUvarint(); v <= uint64(p.GetTotalLen()) {")
+		g.p("%s = int32(v)", n)
+		g.p("}")
+	default:
+		g.p("%s := int32(p.ReadUint32%s())", n, suffix(t))
+	}
+	g.p("if nil != p.Err() {")
+	g.p("return p.Err()")
+	g.p("}")
+	g.p("if %s < 0 || %s > p.GetTotalLen() {", n, n)
+	g.p("return fmt.Errorf(\"decode %s: length %%d exceeds packet length %%d\", %s, p.GetTotalLen())", strings.TrimPrefix(expr, "m."), n)
+	g.p("}")
+}
+
+func (g *generator) handlers() {
+	var bound []*Message
+	for _, m := range g.file.Messages {
+		if "" != m.Cmd {
+			bound = append(bound, m)
+		}
+	}
+
+	g.p("// 消息处理接口，嵌入UnimplementedHandler后只需要实现关心的消息")
+	g.p("type Handler interface {")
+	for _, m := range bound {
+		g.p("Handle%s(m *%s, c solidnet.IClient)", m.Name, m.Name)
+	}
+	g.p("// 解码失败，默认丢弃该包")
+	g.p("OnDecodeError(cmd Command, err error, c solidnet.IClient)")
+	g.p("}")
+	g.p("")
+
+	g.p("// 未实现的消息处理，直接丢弃")
+	g.p("type UnimplementedHandler struct{}")
+	g.p("")
+	for _, m := range bound {
+		g.p("func (UnimplementedHandler) Handle%s(m *%s, c solidnet.IClient) {}", m.Name, m.Name)
+	}
+	g.p("func (UnimplementedHandler) OnDecodeError(cmd Command, err error, c solidnet.IClient) {}")
+	g.p("")
+
+	g.p("// 向路由注册所有绑定了命令字的消息，必须在Game.Run之前调用。")
+	g.p("// 路由的IPacketFactory创建的数据包必须实现solidnet.IPacketEx，否则返回错误")
+	g.p("func RegisterHandlers(r *solidnet.Router, h Handler) error {")
+	for _, m := range bound {
+		g.p("if err := solidnet.Handle(r, int32(%s), func(p solidnet.IPacketEx, c solidnet.IClient) {", m.Cmd)
+		g.p("m := new(%s)", m.Name)
+		g.p("if err := m.Decode(p); nil != err {")
+		g.p("h.OnDecodeError(%s, err, c)", m.Cmd)
+		g.p("return")
+		g.p("}")
+		g.p("h.Handle%s(m, c)", m.Name)
+		g.p("}); nil != err {")
+		g.p("return err")
+		g.p("}")
+	}
+	g.p("return nil")
+	g.p("}")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// 生成testdata/NAME.idl，和testdata/NAME.golden比较
+func TestGenerateGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.idl"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0 == len(inputs) {
+		t.Fatal("no idl in testdata")
+	}
+	for _, input := range inputs {
+		code := generateFile(t, input)
+		golden := strings.TrimSuffix(input, ".idl") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, code, 0644); nil != err {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, code) {
+			t.Errorf("%s: generated code differs from %s, run go test -update", input, golden)
+		}
+	}
+}
+
+// 提交的生成代码和生成器的输出一致
+func TestGeneratedUpToDate(t *testing.T) {
+	for _, c := range []struct {
+		input  string
+		output string
+	}{
+		{filepath.Join("testdata", "all.idl"), filepath.Join("internal", "gentest", "all.go")},
+		{filepath.Join("..", "..", "examples", "proto", "example.idl"), filepath.Join("..", "..", "examples", "proto", "example.go")},
+	} {
+		code := generateFile(t, c.input)
+		if *update {
+			if err := ioutil.WriteFile(c.output, code, 0644); nil != err {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(c.output)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, code) {
+			t.Errorf("%s is out of date, run go test -update", c.output)
+		}
+	}
+}
+
+func generateFile(t *testing.T, input string) []byte {
+	in, err := os.Open(input)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	f, err := Parse(in)
+	if nil != err {
+		t.Fatalf("%s: %s", input, err.Error())
+	}
+	code, err := Generate(f, filepath.Base(input))
+	if nil != err {
+		t.Fatalf("%s: %s", input, err.Error())
+	}
+	return code
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, c := range []struct {
+		idl  string
+		want string
+	}{
+		{"message A {\n}", "line 1: missing package"},
+		{"package p\nmessage A {\nint32 x", "line 2: message A is not closed"},
+		{"package p\nfoo", `line 2: unexpected "foo"`},
+		{"package p\ncommand A = x", `line 2: invalid command value "x"`},
+		{"package p\nmessage A {\nint32 x nope\n}", `line 3: invalid option "nope"`},
+		{"package p\nmessage A {\nstring x varint\n}", "line 3: varint needs integer type"},
+		{"package p\nmessage A {\n[]int32 x size=2\n}", "line 3: size needs string or bytes, use [N]T for arrays"},
+		{"package p\nmessage A {\nint32 x len=1\n}", "line 3: len needs string, bytes or slice"},
+		{"package p\nmessage A {\n[0]int32 x\n}", `line 3: invalid array length in "[0]int32"`},
+
+		// File.check
+		{"package p\ncommand A = 1\ncommand A = 2", "line 3: command A redefined"},
+		{"package p\ncommand A = 1\ncommand B = 1", "line 3: command B has the same value as A"},
+		{"package p\ncommand Handler = 1", "line 2: command name Handler is reserved"},
+		{"package p\nmessage A {\n}\nmessage A {\n}", "line 4: message A redefined"},
+		{"package p\nmessage string {\n}", "line 2: message string redefined"},
+		{"package p\nmessage a {\n}", "line 2: message name a must start with an upper case letter"},
+		{"package p\nmessage Command {\n}", "line 2: message name Command is reserved"},
+		{"package p\nmessage Handler {\n}", "line 2: message name Handler is reserved"},
+		{"package p\nmessage UnimplementedHandler {\n}", "line 2: message name UnimplementedHandler is reserved"},
+		{"package p\nmessage RegisterHandlers {\n}", "line 2: message name RegisterHandlers is reserved"},
+		{"package p\ncommand A = 1\nmessage A {\n}", "line 3: message A has the same name as a command"},
+		{"package p\nmessage A = B {\n}", "line 2: undefined command B"},
+		{"package p\ncommand C = 1\nmessage A = C {\n}\nmessage B = C {\n}", "line 5: command C is already bound to message A"},
+		{"package p\nmessage A {\nint32 x\nint32 x\n}", "line 4: field X redefined"},
+		{"package p\nmessage A {\nB x\n}", "line 3: undefined type B"},
+		{"package p\nmessage A {\nB b\n}\nmessage B {\n[2]A a\n}", "line 2: message A contains itself"},
+	} {
+		_, err := Parse(strings.NewReader(c.idl))
+		if nil == err {
+			t.Errorf("%q: no error, want %q", c.idl, c.want)
+			continue
+		}
+		if c.want != err.Error() {
+			t.Errorf("%q: got %q, want %q", c.idl, err.Error(), c.want)
+		}
+	}
+
+	// 切片中的消息可以引用自己
+	if _, err := Parse(strings.NewReader("package p\nmessage A {\n[]A children\n}")); nil != err {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 协议描述文件的内容，格式见main.go
+type File struct {
+	Package  string
+	Commands []*Command
+	Messages []*Message
+}
+
+// 命令字
+type Command struct {
+	Name  string
+	Value int32
+	Line  int
+}
+
+// 消息，Cmd为空时只能作为其他消息的字段
+type Message struct {
+	Name   string
+	Cmd    string
+	Fields []*Field
+	Line   int
+}
+
+type Field struct {
+	Name    string // 生成的Go字段名
+	Type    *Type
+	Options []string // 原样写入solidnet标签
+	Line    int
+}
+
+// 字段类型，Elem不为空时是切片（Len小于0）或者数组
+type Type struct {
+	Name string // 基本类型名或者消息名
+	Elem *Type
+	Len  int
+
+	// 编码方式，和solidnet标签的含义一致
+	BigEndian bool
+	Varint    bool
+	Size      int // 小于0表示不定长
+	LenWidth  string
+}
+
+var basicTypes = map[string]bool{
+	"bool": true, "int8": true, "uint8": true, "byte": true,
+	"int16": true, "uint16": true, "int32": true, "uint32": true, "int64": true, "uint64": true,
+	"float32": true, "float64": true, "string": true, "bytes": true,
+}
+
+// 生成代码中使用的名字，命令字和消息不能重名
+var reservedNames = map[string]bool{
+	"Command": true, "Handler": true, "UnimplementedHandler": true, "RegisterHandlers": true,
+}
+
+func isInteger(name string) bool {
+	switch name {
+	case "int16", "uint16", "int32", "uint32", "int64", "uint64":
+		return true
+	}
+	return false
+}
+
+type parseError struct {
+	line int
+	msg  string
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+func errorf(line int, format string, args ...interface{}) error {
+	return &parseError{line, fmt.Sprintf(format, args...)}
+}
+
+// 解析协议描述文件
+func Parse(r io.Reader) (*File, error) {
+	f := new(File)
+	var msg *Message
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = text[:i]
+		}
+		words := strings.Fields(text)
+		if 0 == len(words) {
+			continue
+		}
+
+		if nil != msg {
+			if "}" == words[0] && 1 == len(words) {
+				f.Messages = append(f.Messages, msg)
+				msg = nil
+				continue
+			}
+			field, err := parseField(words, line)
+			if nil != err {
+				return nil, err
+			}
+			msg.Fields = append(msg.Fields, field)
+			continue
+		}
+
+		switch words[0] {
+		case "package":
+			if 2 != len(words) {
+				return nil, errorf(line, "want: package NAME")
+			}
+			f.Package = words[1]
+		case "command":
+			// command NAME = VALUE
+			if 4 != len(words) || "=" != words[2] {
+				return nil, errorf(line, "want: command NAME = VALUE")
+			}
+			value, err := strconv.ParseInt(words[3], 0, 32)
+			if nil != err {
+				return nil, errorf(line, "invalid command value %q", words[3])
+			}
+			f.Commands = append(f.Commands, &Command{words[1], int32(value), line})
+		case "message":
+			// message NAME [= COMMAND] {
+			if len(words) < 3 || "{" != words[len(words)-1] {
+				return nil, errorf(line, "want: message NAME [= COMMAND] {")
+			}
+			msg = &Message{Name: words[1], Line: line}
+			switch len(words) {
+			case 3:
+			case 5:
+				if "=" != words[2] {
+					return nil, errorf(line, "want: message NAME = COMMAND {")
+				}
+				msg.Cmd = words[3]
+			default:
+				return nil, errorf(line, "want: message NAME [= COMMAND] {")
+			}
+		default:
+			return nil, errorf(line, "unexpected %q", words[0])
+		}
+	}
+	if err := scanner.Err(); nil != err {
+		return nil, err
+	}
+	if nil != msg {
+		return nil, errorf(msg.Line, "message %s is not closed", msg.Name)
+	}
+	if "" == f.Package {
+		return nil, errorf(1, "missing package")
+	}
+	if err := f.check(); nil != err {
+		return nil, err
+	}
+	return f, nil
+}
+
+// TYPE NAME [OPTION...]
+func parseField(words []string, line int) (*Field, error) {
+	if len(words) < 2 {
+		return nil, errorf(line, "want: TYPE NAME [OPTION...]")
+	}
+	t, err := parseType(words[0], line)
+	if nil != err {
+		return nil, err
+	}
+	field := &Field{Name: exportName(words[1]), Type: t, Line: line}
+	for _, word := range words[2:] {
+		for _, opt := range strings.Split(word, ",") {
+			if "" != opt {
+				field.Options = append(field.Options, opt)
+			}
+		}
+	}
+	if "" == field.Name {
+		return nil, errorf(line, "invalid field name %q", words[1])
+	}
+	if err := t.applyOptions(field.Options, line); nil != err {
+		return nil, err
+	}
+	return field, nil
+}
+
+func parseType(s string, line int) (*Type, error) {
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return nil, errorf(line, "invalid type %q", s)
+		}
+		elem, err := parseType(s[end+1:], line)
+		if nil != err {
+			return nil, err
+		}
+		t := &Type{Elem: elem, Len: -1, Size: -1}
+		if end > 1 {
+			n, err := strconv.Atoi(s[1:end])
+			if nil != err || n <= 0 {
+				return nil, errorf(line, "invalid array length in %q", s)
+			}
+			t.Len = n
+		}
+		return t, nil
+	}
+	if "" == s {
+		return nil, errorf(line, "missing element type")
+	}
+	if "byte" == s {
+		s = "uint8"
+	}
+	return &Type{Name: s, Len: -1, Size: -1}, nil
+}
+
+// 解析字段选项，字节序和变长编码同时作用于元素
+func (t *Type) applyOptions(options []string, line int) error {
+	for _, opt := range options {
+		switch {
+		case "le" == opt:
+			t.setOrder(false)
+		case "be" == opt:
+			t.setOrder(true)
+		case "varint" == opt:
+			if !isInteger(t.base().Name) {
+				return errorf(line, "varint needs integer type")
+			}
+			t.setVarint()
+		case strings.HasPrefix(opt, "size="):
+			n, err := strconv.Atoi(opt[len("size="):])
+			if nil != err || n < 0 {
+				return errorf(line, "invalid option %q", opt)
+			}
+			if "string" != t.Name && "bytes" != t.Name {
+				return errorf(line, "size needs string or bytes, use [N]T for arrays")
+			}
+			t.Size = n
+		case strings.HasPrefix(opt, "len="):
+			width := opt[len("len="):]
+			if "1" != width && "2" != width && "4" != width && "varint" != width {
+				return errorf(line, "invalid option %q", opt)
+			}
+			if "string" != t.Name && "bytes" != t.Name && !t.IsSlice() {
+				return errorf(line, "len needs string, bytes or slice")
+			}
+			t.LenWidth = width
+		default:
+			return errorf(line, "invalid option %q", opt)
+		}
+	}
+	return nil
+}
+
+func (t *Type) setOrder(bigEndian bool) {
+	for ; nil != t; t = t.Elem {
+		t.BigEndian = bigEndian
+	}
+}
+
+func (t *Type) setVarint() {
+	for ; nil != t; t = t.Elem {
+		t.Varint = true
+	}
+}
+
+func (t *Type) base() *Type {
+	for nil != t.Elem {
+		t = t.Elem
+	}
+	return t
+}
+
+func (t *Type) IsSlice() bool {
+	return nil != t.Elem && t.Len < 0
+}
+
+func (t *Type) IsArray() bool {
+	return nil != t.Elem && t.Len >= 0
+}
+
+// 检查名字冲突、未定义的类型和命令字、消息之间按值的循环引用
+func (f *File) check() error {
+	commands := make(map[string]bool)
+	values := make(map[int32]string)
+	for _, c := range f.Commands {
+		if commands[c.Name] {
+			return errorf(c.Line, "command %s redefined", c.Name)
+		}
+		if reservedNames[c.Name] {
+			return errorf(c.Line, "command name %s is reserved", c.Name)
+		}
+		if other, ok := values[c.Value]; ok {
+			return errorf(c.Line, "command %s has the same value as %s", c.Name, other)
+		}
+		commands[c.Name] = true
+		values[c.Value] = c.Name
+	}
+
+	messages := make(map[string]*Message)
+	bound := make(map[string]string)
+	for _, m := range f.Messages {
+		if _, ok := messages[m.Name]; ok || basicTypes[m.Name] {
+			return errorf(m.Line, "message %s redefined", m.Name)
+		}
+		if "" == exportName(m.Name) || exportName(m.Name) != m.Name {
+			return errorf(m.Line, "message name %s must start with an upper case letter", m.Name)
+		}
+		if reservedNames[m.Name] {
+			return errorf(m.Line, "message name %s is reserved", m.Name)
+		}
+		if commands[m.Name] {
+			return errorf(m.Line, "message %s has the same name as a command", m.Name)
+		}
+		messages[m.Name] = m
+		if "" != m.Cmd {
+			if !commands[m.Cmd] {
+				return errorf(m.Line, "undefined command %s", m.Cmd)
+			}
+			if other, ok := bound[m.Cmd]; ok {
+				return errorf(m.Line, "command %s is already bound to message %s", m.Cmd, other)
+			}
+			bound[m.Cmd] = m.Name
+		}
+	}
+
+	for _, m := range f.Messages {
+		names := make(map[string]bool)
+		for _, field := range m.Fields {
+			if names[field.Name] {
+				return errorf(field.Line, "field %s redefined", field.Name)
+			}
+			names[field.Name] = true
+			base := field.Type.base().Name
+			if !basicTypes[base] && nil == messages[base] {
+				return errorf(field.Line, "undefined type %s", base)
+			}
+		}
+	}
+
+	// 按值包含的消息不能循环，切片中的消息不受限制
+	state := make(map[string]int)
+	var visit func(m *Message) error
+	visit = func(m *Message) error {
+		switch state[m.Name] {
+		case 1:
+			return errorf(m.Line, "message %s contains itself", m.Name)
+		case 2:
+			return nil
+		}
+		state[m.Name] = 1
+		for _, field := range m.Fields {
+			t := field.Type
+			for t.IsArray() {
+				t = t.Elem
+			}
+			if child, ok := messages[t.Name]; ok {
+				if err := visit(child); nil != err {
+					return err
+				}
+			}
+		}
+		state[m.Name] = 2
+		return nil
+	}
+	for _, m := range f.Messages {
+		if err := visit(m); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// 把下划线分隔的名字转换为导出的驼峰名字
+func exportName(s string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(s, "_") {
+		if "" == part {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]))
+		b.WriteString(part[1:])
+	}
+	name := b.String()
+	for i, r := range name {
+		if !(r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || i > 0 && '0' <= r && r <= '9') {
+			return ""
+		}
+	}
+	return name
+}
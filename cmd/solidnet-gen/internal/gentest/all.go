@@ -0,0 +1,579 @@
+// Code generated by solidnet-gen. DO NOT EDIT.
+// source: all.idl
+
+package gentest
+
+import (
+	"fmt"
+
+	solidnet "github.com/idakun/solidnet"
+)
+
+// 命令字
+type Command int32
+
+const (
+	CMD_ALL   Command = 0x10
+	CMD_EMPTY Command = 0x11
+)
+
+func (c Command) String() string {
+	switch c {
+	case CMD_ALL:
+		return "CMD_ALL"
+	case CMD_EMPTY:
+		return "CMD_EMPTY"
+	}
+	return fmt.Sprintf("Command(0x%x)", int32(c))
+}
+
+// 命令字CMD_ALL
+type All struct {
+	B      bool
+	I8     int8
+	U8     uint8
+	By     uint8
+	I16    int16
+	I16B   int16 `solidnet:"be"`
+	I32    int32
+	I32B   int32 `solidnet:"be"`
+	I32V   int32 `solidnet:"varint"`
+	I64    int64 `solidnet:"le"`
+	I64B   int64 `solidnet:"be"`
+	I64V   int64 `solidnet:"varint"`
+	U16    uint16
+	U16B   uint16 `solidnet:"be"`
+	U32    uint32
+	U32B   uint32 `solidnet:"be"`
+	U32V   uint32 `solidnet:"varint"`
+	U64    uint64
+	U64B   uint64 `solidnet:"be"`
+	U64V   uint64 `solidnet:"varint"`
+	F32    float32
+	F32B   float32 `solidnet:"be"`
+	F64    float64
+	F64B   float64 `solidnet:"be"`
+	Str    string
+	Str1   string `solidnet:"len=1"`
+	Str2B  string `solidnet:"len=2,be"`
+	Str4B  string `solidnet:"len=4,be"`
+	StrV   string `solidnet:"len=varint"`
+	Fixed  string `solidnet:"size=8"`
+	Raw    []byte
+	Raw1   []byte   `solidnet:"len=1"`
+	Raw2B  []byte   `solidnet:"len=2,be"`
+	RawV   []byte   `solidnet:"len=varint"`
+	RawFix []byte   `solidnet:"size=4"`
+	Arr    [3]int32 `solidnet:"be"`
+	Matrix [2][2]uint8
+	Ids    []int64   `solidnet:"len=varint,varint"`
+	Shorts []uint16  `solidnet:"be"`
+	Names  []string  `solidnet:"len=1"`
+	Nested [][]int32 `solidnet:"len=2"`
+	Items  []Item    `solidnet:"len=2"`
+	Pair   [2]Item
+	Item   Item
+	Outer  Outer
+}
+
+func (m *All) Cmd() Command {
+	return CMD_ALL
+}
+
+// 把消息追加到p中，包头需要调用者写入
+func (m *All) Encode(p solidnet.IPacketEx) {
+	p.WriteBool(m.B)
+	p.WriteByte(byte(m.I8))
+	p.WriteUint8(m.U8)
+	p.WriteUint8(m.By)
+	p.WriteInt16(m.I16)
+	p.WriteInt16B(m.I16B)
+	p.WriteInt32(m.I32)
+	p.WriteInt32B(m.I32B)
+	p.WriteVarint(int64(m.I32V))
+	p.WriteInt64(m.I64)
+	p.WriteInt64B(m.I64B)
+	p.WriteVarint(int64(m.I64V))
+	p.WriteUint16(m.U16)
+	p.WriteUint16B(m.U16B)
+	p.WriteUint32(m.U32)
+	p.WriteUint32B(m.U32B)
+	p.WriteUvarint(uint64(m.U32V))
+	p.WriteUint64(m.U64)
+	p.WriteUint64B(m.U64B)
+	p.WriteUvarint(uint64(m.U64V))
+	p.WriteFloat32(m.F32)
+	p.WriteFloat32B(m.F32B)
+	p.WriteFloat64(m.F64)
+	p.WriteFloat64B(m.F64B)
+	p.WriteString(m.Str)
+	p.WriteUint8(uint8(len(m.Str1) + 1))
+	p.WriteBytes([]byte(m.Str1))
+	p.WriteByte(0)
+	p.WriteUint16B(uint16(len(m.Str2B) + 1))
+	p.WriteBytes([]byte(m.Str2B))
+	p.WriteByte(0)
+	p.WriteUint32B(uint32(len(m.Str4B) + 1))
+	p.WriteBytes([]byte(m.Str4B))
+	p.WriteByte(0)
+	p.WriteUvarint(uint64(len(m.StrV) + 1))
+	p.WriteBytes([]byte(m.StrV))
+	p.WriteByte(0)
+	p.WriteFixedString(m.Fixed, 8)
+	p.WriteLenBytes(m.Raw)
+	p.WriteUint8(uint8(len(m.Raw1)))
+	p.WriteBytes(m.Raw1)
+	p.WriteUint16B(uint16(len(m.Raw2B)))
+	p.WriteBytes(m.Raw2B)
+	p.WriteUvarint(uint64(len(m.RawV)))
+	p.WriteBytes(m.RawV)
+	{
+		buf := make([]byte, 4)
+		copy(buf, m.RawFix)
+		p.WriteBytes(buf)
+	}
+	for i0 := range m.Arr {
+		p.WriteInt32B(m.Arr[i0])
+	}
+	for i0 := range m.Matrix {
+		for i1 := range m.Matrix[i0] {
+			p.WriteUint8(m.Matrix[i0][i1])
+		}
+	}
+	p.WriteUvarint(uint64(len(m.Ids)))
+	for i0 := range m.Ids {
+		p.WriteVarint(int64(m.Ids[i0]))
+	}
+	p.WriteUint32B(uint32(len(m.Shorts)))
+	for i0 := range m.Shorts {
+		p.WriteUint16B(m.Shorts[i0])
+	}
+	p.WriteUint8(uint8(len(m.Names)))
+	for i0 := range m.Names {
+		p.WriteString(m.Names[i0])
+	}
+	p.WriteUint16(uint16(len(m.Nested)))
+	for i0 := range m.Nested {
+		p.WriteUint32(uint32(len(m.Nested[i0])))
+		for i1 := range m.Nested[i0] {
+			p.WriteInt32(m.Nested[i0][i1])
+		}
+	}
+	p.WriteUint16(uint16(len(m.Items)))
+	for i0 := range m.Items {
+		m.Items[i0].Encode(p)
+	}
+	for i0 := range m.Pair {
+		m.Pair[i0].Encode(p)
+	}
+	m.Item.Encode(p)
+	m.Outer.Encode(p)
+}
+
+// 从p的当前位置读取消息，数据不足时返回p.Err()
+func (m *All) Decode(p solidnet.IPacketEx) error {
+	m.B = p.ReadBool()
+	m.I8 = int8(p.ReadByte())
+	m.U8 = p.ReadUint8()
+	m.By = p.ReadUint8()
+	m.I16 = p.ReadInt16()
+	m.I16B = p.ReadInt16B()
+	m.I32 = p.ReadInt32()
+	m.I32B = p.ReadInt32B()
+	m.I32V = int32(p.ReadVarint())
+	m.I64 = p.ReadInt64()
+	m.I64B = p.ReadInt64B()
+	m.I64V = int64(p.ReadVarint())
+	m.U16 = p.ReadUint16()
+	m.U16B = p.ReadUint16B()
+	m.U32 = p.ReadUint32()
+	m.U32B = p.ReadUint32B()
+	m.U32V = uint32(p.ReadUvarint())
+	m.U64 = p.ReadUint64()
+	m.U64B = p.ReadUint64B()
+	m.U64V = uint64(p.ReadUvarint())
+	m.F32 = p.ReadFloat32()
+	m.F32B = p.ReadFloat32B()
+	m.F64 = p.ReadFloat64()
+	m.F64B = p.ReadFloat64B()
+	m.Str = p.ReadString()
+	{
+		n0 := int32(p.ReadUint8())
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Str1: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		b := p.ReadBytes(n0)
+		if len(b) > 0 && 0 == b[len(b)-1] {
+			b = b[:len(b)-1]
+		}
+		m.Str1 = string(b)
+	}
+	{
+		n0 := int32(p.ReadUint16B())
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Str2B: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		b := p.ReadBytes(n0)
+		if len(b) > 0 && 0 == b[len(b)-1] {
+			b = b[:len(b)-1]
+		}
+		m.Str2B = string(b)
+	}
+	{
+		n0 := int32(p.ReadUint32B())
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Str4B: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		b := p.ReadBytes(n0)
+		if len(b) > 0 && 0 == b[len(b)-1] {
+			b = b[:len(b)-1]
+		}
+		m.Str4B = string(b)
+	}
+	{
+		n0 := int32(-1)
+		if v := p.ReadUvarint(); v <= uint64(p.GetTotalLen()) {
+			n0 = int32(v)
+		}
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode StrV: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		b := p.ReadBytes(n0)
+		if len(b) > 0 && 0 == b[len(b)-1] {
+			b = b[:len(b)-1]
+		}
+		m.StrV = string(b)
+	}
+	m.Fixed = p.ReadFixedString(8)
+	m.Raw = append([]byte{}, p.ReadLenBytes()...)
+	{
+		n0 := int32(p.ReadUint8())
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Raw1: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		m.Raw1 = append([]byte{}, p.ReadBytes(n0)...)
+	}
+	{
+		n0 := int32(p.ReadUint16B())
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Raw2B: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		m.Raw2B = append([]byte{}, p.ReadBytes(n0)...)
+	}
+	{
+		n0 := int32(-1)
+		if v := p.ReadUvarint(); v <= uint64(p.GetTotalLen()) {
+			n0 = int32(v)
+		}
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode RawV: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		m.RawV = append([]byte{}, p.ReadBytes(n0)...)
+	}
+	m.RawFix = append([]byte{}, p.ReadBytes(4)...)
+	for i0 := range m.Arr {
+		m.Arr[i0] = p.ReadInt32B()
+		if nil != p.Err() {
+			return p.Err()
+		}
+	}
+	for i0 := range m.Matrix {
+		for i1 := range m.Matrix[i0] {
+			m.Matrix[i0][i1] = p.ReadUint8()
+			if nil != p.Err() {
+				return p.Err()
+			}
+		}
+		if nil != p.Err() {
+			return p.Err()
+		}
+	}
+	{
+		n0 := int32(-1)
+		if v := p.ReadUvarint(); v <= uint64(p.GetTotalLen()) {
+			n0 = int32(v)
+		}
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Ids: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		m.Ids = make([]int64, n0)
+	}
+	for i0 := range m.Ids {
+		m.Ids[i0] = int64(p.ReadVarint())
+		if nil != p.Err() {
+			return p.Err()
+		}
+	}
+	{
+		n0 := int32(p.ReadUint32B())
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Shorts: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		m.Shorts = make([]uint16, n0)
+	}
+	for i0 := range m.Shorts {
+		m.Shorts[i0] = p.ReadUint16B()
+		if nil != p.Err() {
+			return p.Err()
+		}
+	}
+	{
+		n0 := int32(p.ReadUint8())
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Names: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		m.Names = make([]string, n0)
+	}
+	for i0 := range m.Names {
+		m.Names[i0] = p.ReadString()
+		if nil != p.Err() {
+			return p.Err()
+		}
+	}
+	{
+		n0 := int32(p.ReadUint16())
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Nested: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		m.Nested = make([][]int32, n0)
+	}
+	for i0 := range m.Nested {
+		{
+			n1 := int32(p.ReadUint32())
+			if nil != p.Err() {
+				return p.Err()
+			}
+			if n1 < 0 || n1 > p.GetTotalLen() {
+				return fmt.Errorf("decode Nested[i0]: length %d exceeds packet length %d", n1, p.GetTotalLen())
+			}
+			m.Nested[i0] = make([]int32, n1)
+		}
+		for i1 := range m.Nested[i0] {
+			m.Nested[i0][i1] = p.ReadInt32()
+			if nil != p.Err() {
+				return p.Err()
+			}
+		}
+		if nil != p.Err() {
+			return p.Err()
+		}
+	}
+	{
+		n0 := int32(p.ReadUint16())
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Items: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		m.Items = make([]Item, n0)
+	}
+	for i0 := range m.Items {
+		if err := m.Items[i0].Decode(p); nil != err {
+			return err
+		}
+		if nil != p.Err() {
+			return p.Err()
+		}
+	}
+	for i0 := range m.Pair {
+		if err := m.Pair[i0].Decode(p); nil != err {
+			return err
+		}
+		if nil != p.Err() {
+			return p.Err()
+		}
+	}
+	if err := m.Item.Decode(p); nil != err {
+		return err
+	}
+	if err := m.Outer.Decode(p); nil != err {
+		return err
+	}
+	return p.Err()
+}
+
+// 命令字CMD_EMPTY
+type Empty struct {
+}
+
+func (m *Empty) Cmd() Command {
+	return CMD_EMPTY
+}
+
+// 把消息追加到p中，包头需要调用者写入
+func (m *Empty) Encode(p solidnet.IPacketEx) {
+}
+
+// 从p的当前位置读取消息，数据不足时返回p.Err()
+func (m *Empty) Decode(p solidnet.IPacketEx) error {
+	return p.Err()
+}
+
+type Item struct {
+	Id   uint32 `solidnet:"varint"`
+	Name string
+}
+
+// 把消息追加到p中，包头需要调用者写入
+func (m *Item) Encode(p solidnet.IPacketEx) {
+	p.WriteUvarint(uint64(m.Id))
+	p.WriteString(m.Name)
+}
+
+// 从p的当前位置读取消息，数据不足时返回p.Err()
+func (m *Item) Decode(p solidnet.IPacketEx) error {
+	m.Id = uint32(p.ReadUvarint())
+	m.Name = p.ReadString()
+	return p.Err()
+}
+
+type Outer struct {
+	First Item
+	Rest  []Item `solidnet:"len=1"`
+	Inner Inner
+}
+
+// 把消息追加到p中，包头需要调用者写入
+func (m *Outer) Encode(p solidnet.IPacketEx) {
+	m.First.Encode(p)
+	p.WriteUint8(uint8(len(m.Rest)))
+	for i0 := range m.Rest {
+		m.Rest[i0].Encode(p)
+	}
+	m.Inner.Encode(p)
+}
+
+// 从p的当前位置读取消息，数据不足时返回p.Err()
+func (m *Outer) Decode(p solidnet.IPacketEx) error {
+	if err := m.First.Decode(p); nil != err {
+		return err
+	}
+	{
+		n0 := int32(p.ReadUint8())
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Rest: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		m.Rest = make([]Item, n0)
+	}
+	for i0 := range m.Rest {
+		if err := m.Rest[i0].Decode(p); nil != err {
+			return err
+		}
+		if nil != p.Err() {
+			return p.Err()
+		}
+	}
+	if err := m.Inner.Decode(p); nil != err {
+		return err
+	}
+	return p.Err()
+}
+
+type Inner struct {
+	X    int16  `solidnet:"be"`
+	Data []byte `solidnet:"len=varint"`
+}
+
+// 把消息追加到p中，包头需要调用者写入
+func (m *Inner) Encode(p solidnet.IPacketEx) {
+	p.WriteInt16B(m.X)
+	p.WriteUvarint(uint64(len(m.Data)))
+	p.WriteBytes(m.Data)
+}
+
+// 从p的当前位置读取消息，数据不足时返回p.Err()
+func (m *Inner) Decode(p solidnet.IPacketEx) error {
+	m.X = p.ReadInt16B()
+	{
+		n0 := int32(-1)
+		if v := p.ReadUvarint(); v <= uint64(p.GetTotalLen()) {
+			n0 = int32(v)
+		}
+		if nil != p.Err() {
+			return p.Err()
+		}
+		if n0 < 0 || n0 > p.GetTotalLen() {
+			return fmt.Errorf("decode Data: length %d exceeds packet length %d", n0, p.GetTotalLen())
+		}
+		m.Data = append([]byte{}, p.ReadBytes(n0)...)
+	}
+	return p.Err()
+}
+
+// 消息处理接口，嵌入UnimplementedHandler后只需要实现关心的消息
+type Handler interface {
+	HandleAll(m *All, c solidnet.IClient)
+	HandleEmpty(m *Empty, c solidnet.IClient)
+	// 解码失败，默认丢弃该包
+	OnDecodeError(cmd Command, err error, c solidnet.IClient)
+}
+
+// 未实现的消息处理，直接丢弃
+type UnimplementedHandler struct{}
+
+func (UnimplementedHandler) HandleAll(m *All, c solidnet.IClient)                     {}
+func (UnimplementedHandler) HandleEmpty(m *Empty, c solidnet.IClient)                 {}
+func (UnimplementedHandler) OnDecodeError(cmd Command, err error, c solidnet.IClient) {}
+
+// 向路由注册所有绑定了命令字的消息，必须在Game.Run之前调用。
+// 路由的IPacketFactory创建的数据包必须实现solidnet.IPacketEx，否则返回错误
+func RegisterHandlers(r *solidnet.Router, h Handler) error {
+	if err := solidnet.Handle(r, int32(CMD_ALL), func(p solidnet.IPacketEx, c solidnet.IClient) {
+		m := new(All)
+		if err := m.Decode(p); nil != err {
+			h.OnDecodeError(CMD_ALL, err, c)
+			return
+		}
+		h.HandleAll(m, c)
+	}); nil != err {
+		return err
+	}
+	if err := solidnet.Handle(r, int32(CMD_EMPTY), func(p solidnet.IPacketEx, c solidnet.IClient) {
+		m := new(Empty)
+		if err := m.Decode(p); nil != err {
+			h.OnDecodeError(CMD_EMPTY, err, c)
+			return
+		}
+		h.HandleEmpty(m, c)
+	}); nil != err {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package gentest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	solidnet "github.com/idakun/solidnet"
+)
+
+func newPacket() *solidnet.BasePacket {
+	p := &solidnet.BasePacket{HeadLen: 4, BodyLenIndex: 0}
+	p.WriteBytes(make([]byte, p.GetHeadLen()))
+	return p
+}
+
+func referPacket(data []byte) *solidnet.BasePacket {
+	p := &solidnet.BasePacket{HeadLen: 4, BodyLenIndex: 0}
+	p.Refer(data)
+	return p
+}
+
+func allValue() All {
+	item := func(id uint32, name string) Item {
+		return Item{Id: id, Name: name}
+	}
+	return All{
+		B: true, I8: -3, U8: 200, By: 7,
+		I16: -1234, I16B: -4321, I32: 99999, I32B: -99999, I32V: -300,
+		I64: 1 << 40, I64B: -(1 << 50), I64V: -77777777,
+		U16: 0xfffe, U16B: 0x0102, U32: 0xfffffffe, U32B: 0x01020304, U32V: 300,
+		U64: 1 << 63, U64B: 0x0102030405060708, U64V: 1 << 60,
+		F32: 1.5, F32B: -2.25, F64: 3.125, F64B: -1e100,
+		Str: "hello", Str1: "a", Str2B: "bc", Str4B: "def", StrV: "varint", Fixed: "fixed",
+		Raw: []byte{1, 2, 3}, Raw1: []byte{4}, Raw2B: []byte{5, 6}, RawV: []byte{7, 8, 9}, RawFix: []byte{9, 0, 0, 0},
+		Arr:    [3]int32{1, -2, 3},
+		Matrix: [2][2]uint8{{1, 2}, {3, 4}},
+		Ids:    []int64{-1, 0, 1 << 40},
+		Shorts: []uint16{1, 0xff00},
+		Names:  []string{"x", "", "yz"},
+		Nested: [][]int32{{1}, {}, {2, 3}},
+		Items:  []Item{item(1, "one"), item(300, "three hundred")},
+		Pair:   [2]Item{item(2, "two"), item(3, "")},
+		Item:   item(4, "four"),
+		Outer: Outer{
+			First: item(5, "five"),
+			Rest:  []Item{item(6, "six")},
+			Inner: Inner{X: -7, Data: []byte("inner")},
+		},
+	}
+}
+
+// 生成的Encode和solidnet.Marshal的编码结果逐字节相同，Decode和Unmarshal可以互相解码
+func TestEncodeMatchesMarshal(t *testing.T) {
+	for name, in := range map[string]All{"zero": {}, "all": allValue()} {
+		p := newPacket()
+		in.Encode(p)
+		q := newPacket()
+		if err := solidnet.Marshal(q, &in); nil != err {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+		if !bytes.Equal(p.GetData(), q.GetData()) {
+			t.Fatalf("%s: Encode\n%x\nMarshal\n%x", name, p.GetData(), q.GetData())
+		}
+
+		var decoded, unmarshaled All
+		if err := decoded.Decode(referPacket(q.GetData())); nil != err {
+			t.Fatalf("%s: decode: %s", name, err.Error())
+		}
+		if err := solidnet.Unmarshal(referPacket(p.GetData()), &unmarshaled); nil != err {
+			t.Fatalf("%s: unmarshal: %s", name, err.Error())
+		}
+		if !reflect.DeepEqual(decoded, unmarshaled) {
+			t.Fatalf("%s: Decode\n%+v\nUnmarshal\n%+v", name, decoded, unmarshaled)
+		}
+		if "all" == name && !reflect.DeepEqual(in, decoded) {
+			t.Fatalf("%s: round trip\n got %+v\nwant %+v", name, decoded, in)
+		}
+	}
+
+	p := newPacket()
+	(&Empty{}).Encode(p)
+	if 4 != len(p.GetData()) {
+		t.Fatalf("empty message encodes %d bytes", len(p.GetData())-4)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	in := allValue()
+	p := newPacket()
+	in.Encode(p)
+	data := p.GetData()
+	for n := 4; n < len(data); n++ {
+		var out All
+		if nil == out.Decode(referPacket(data[:n])) {
+			t.Fatalf("decode %d of %d bytes without error", n, len(data))
+		}
+	}
+}
@@ -0,0 +1,5 @@
+// gentest是testdata/all.idl生成的代码，用来检查生成的Encode/Decode和solidnet.Marshal/Unmarshal编码一致。
+// 修改生成器之后在cmd/solidnet-gen中运行go test -update更新。
+package gentest
+
+//go:generate go run ../.. -o all.go ../../testdata/all.idl
@@ -0,0 +1,85 @@
+// solidnet-gen根据协议描述文件生成Go代码：命令字枚举、带Encode/Decode方法的消息结构体，
+// 以及向solidnet.Router注册消息处理函数的代码。
+//
+// 用法：
+//
+//	solidnet-gen [-o output.go] [-package name] input.idl
+//
+// 协议描述文件按行解析，"//"之后为注释：
+//
+//	package proto
+//
+//	command CLIENT_COMMAND_LOGIN_AUTH = 0x1000
+//
+//	message LoginAuth = CLIENT_COMMAND_LOGIN_AUTH {
+//	    int32     key
+//	    string    name
+//	    string    device   size=16
+//	    []int64   friends  len=2,varint
+//	    [4]uint16 slots    be
+//	    Item      item
+//	}
+//
+//	message Item {
+//	    uint32 id
+//	}
+//
+// 字段的格式为"类型 名字 [选项...]"，类型为bool、int8、uint8（byte）、int16、uint16、int32、uint32、
+// int64、uint64、float32、float64、string、bytes、其他消息，以及它们的切片[]T和数组[N]T。
+// 选项和solidnet.Marshal的标签一致（le、be、varint、size=N、len=W），多个选项用空格或逗号分隔，
+// 生成的结构体带有相同的solidnet标签，Encode/Decode和solidnet.Marshal/Unmarshal的编码结果相同。
+// 消息只编码包体，包头由业务层写入。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	output := flag.String("o", "", "output file, default is the input file with .go extension")
+	pkg := flag.String("package", "", "package name, override the package in the input file")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: solidnet-gen [-o output.go] [-package name] input.idl\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if 1 != flag.NArg() {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	input := flag.Arg(0)
+	if "" == *output {
+		*output = strings.TrimSuffix(input, filepath.Ext(input)) + ".go"
+	}
+	if err := run(input, *output, *pkg); nil != err {
+		fmt.Fprintf(os.Stderr, "solidnet-gen: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(input string, output string, pkg string) error {
+	in, err := os.Open(input)
+	if nil != err {
+		return err
+	}
+	defer in.Close()
+
+	f, err := Parse(in)
+	if nil != err {
+		return fmt.Errorf("%s: %s", input, err.Error())
+	}
+	if "" != pkg {
+		f.Package = pkg
+	}
+	code, err := Generate(f, filepath.Base(input))
+	if nil != err {
+		return err
+	}
+	return ioutil.WriteFile(output, code, 0644)
+}
@@ -0,0 +1,331 @@
+package solidnet
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	logger "github.com/idakun/tinylog"
+)
+
+const (
+	CONNECT_TIMEOUT       = 5  // 建立连接的超时时间，秒
+	RECONNECT_MIN_BACKOFF = 1  // 重连的最小等待时间，秒
+	RECONNECT_MAX_BACKOFF = 60 // 重连的最大等待时间，秒
+)
+
+// 连接器的状态
+const (
+	CONNECTOR_STATE_DISCONNECTED = 0 // 断线，等待重连
+	CONNECTOR_STATE_CONNECTING   = 1 // 正在连接
+	CONNECTOR_STATE_CONNECTED    = 2 // 已连接
+	CONNECTOR_STATE_CLOSED       = 3 // 已关闭，不再重连
+)
+
+// 断线期间Send的处理方式
+const (
+	SEND_POLICY_QUEUE  = 0 // 缓存数据，重连成功后按顺序发送
+	SEND_POLICY_REJECT = 1 // 直接返回失败
+)
+
+// 主动连接器，用于服务器之间的连接：连接断开后按指数退避（带随机抖动）自动重连，
+// 连接器本身实现IClient，重连前后业务层收到的NetMessage和StateMessage都使用同一个连接器
+type Connector struct {
+	Addr       string
+	SendPolicy int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Dial       func(addr string) (net.Conn, error) // 默认建立tcp连接，可以替换为DialTls、DialUdp等
+	Clock      IClock                              // 退避等待和连接使用的时钟
+	Heartbeat  *Heartbeat                          // 连接的应用层心跳，为空时不发送ping
+
+	Processor IProcessor
+	Factory   IPacketFactory
+
+	mutex      sync.Mutex
+	state      int32
+	client     *BaseClient
+	pending    [][]byte // 断线期间缓存的数据
+	loginFlag  bool
+	identity   *Identity
+	id         uint64
+	userId     int64
+	attributes map[string]interface{}
+	quit       chan struct{}
+	quitOnce   sync.Once
+	options    *Options
+}
+
+func NewConnector(addr string, processor IProcessor, f IPacketFactory, opts ...Option) *Connector {
+	c := new(Connector)
+	c.options = NewOptions(opts...)
+	c.id = nextClientId()
+	c.Addr = addr
+	c.SendPolicy = SEND_POLICY_QUEUE
+	c.MinBackoff = time.Second * RECONNECT_MIN_BACKOFF
+	c.MaxBackoff = time.Second * RECONNECT_MAX_BACKOFF
+	c.Dial = func(addr string) (net.Conn, error) {
+		return net.DialTimeout("tcp", addr, time.Second*CONNECT_TIMEOUT)
+	}
+	c.Processor = processor
+	c.Factory = f
+	c.Clock = RealClock
+	c.state = CONNECTOR_STATE_DISCONNECTED
+	c.quit = make(chan struct{})
+	return c
+}
+
+// 启动连接协程，连接和重连都在该协程中进行
+func (c *Connector) Start() {
+	go c.run()
+}
+
+// 关闭连接器，断开当前连接并停止重连
+func (c *Connector) Close() {
+	c.quitOnce.Do(func() {
+		close(c.quit)
+	})
+	c.mutex.Lock()
+	client := c.client
+	c.state = CONNECTOR_STATE_CLOSED
+	c.pending = nil
+	c.mutex.Unlock()
+	if nil != client {
+		client.stop()
+	}
+}
+
+func (c *Connector) State() int32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+func (c *Connector) isClosed() bool {
+	select {
+	case <-c.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Connector) setState(state int32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if CONNECTOR_STATE_CLOSED != c.state {
+		c.state = state
+	}
+}
+
+func (c *Connector) run() {
+	backoff := c.MinBackoff
+	for !c.isClosed() {
+		c.setState(CONNECTOR_STATE_CONNECTING)
+		conn, err := c.Dial(c.Addr)
+		if nil == err {
+			connectedAt := c.Clock.Now()
+			setKeepAlive(conn, c.options.KeepAlive)
+			client := newBaseClient(conn, c.Factory, c.Clock, c.options)
+			client.setHeartbeat(c.Heartbeat)
+			c.serve(client)
+			// 连接保持了足够长的时间，重新从最小等待时间开始退避
+			if c.Clock.Now().Sub(connectedAt) > c.MaxBackoff {
+				backoff = c.MinBackoff
+			}
+		} else {
+			logger.Error("connect to [%s] failed, error[%s]", c.Addr, err.Error())
+		}
+		c.setState(CONNECTOR_STATE_DISCONNECTED)
+
+		// 等待一段时间后重连，在[backoff/2, backoff)之间随机，避免多个连接同时重连
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := c.Clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-c.quit:
+		}
+		timer.Stop()
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+// 派发连接上的消息，直到连接断开。连接器已经关闭时不投递STATE_CONNECTED，
+// 对应的STATE_CLOSED也不投递，业务层收到的状态消息总是成对的
+func (c *Connector) serve(client *BaseClient) {
+	notified := false // 已经投递了STATE_CONNECTED
+	for {
+		select {
+		case data := <-client.input:
+			if notified {
+				tryDispatch(c.Processor, &NetMessage{data, c})
+			}
+		case state := <-client.state:
+			switch state {
+			case STATE_CONNECTED:
+				if !c.connected(client) {
+					// 连接器已经关闭
+					client.stop()
+					continue
+				}
+				notified = true
+				logger.Debug("connect to [%s] success", c.Addr)
+			case STATE_CLOSED:
+				c.mutex.Lock()
+				c.client = nil
+				c.mutex.Unlock()
+				logger.Debug("connection to [%s] closed", c.Addr)
+			}
+			reason := int32(CLOSE_REASON_NONE)
+			if STATE_CLOSED == state {
+				reason = client.getCloseReason()
+			}
+			if notified {
+				c.Processor.Dispatch(&StateMessage{state, c, reason})
+			}
+			if STATE_CLOSED == state {
+				return
+			}
+		}
+	}
+}
+
+// 连接建立，发送断线期间缓存的数据。缓存的数据在锁内发送，保证先于连接建立后Send的数据
+func (c *Connector) connected(client *BaseClient) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if CONNECTOR_STATE_CLOSED == c.state {
+		return false
+	}
+	for _, data := range c.pending {
+		client.Send(data)
+	}
+	c.pending = nil
+	c.client = client
+	c.loginFlag = false
+	c.identity = nil
+	c.state = CONNECTOR_STATE_CONNECTED
+	return true
+}
+
+// 实现 IClient，发送在锁外进行，发送队列已满时不会阻塞连接器的其他方法
+func (c *Connector) Send(data []byte) bool {
+	c.mutex.Lock()
+	client := c.client
+	if nil != client {
+		c.mutex.Unlock()
+		return client.Send(data)
+	}
+	defer c.mutex.Unlock()
+	if SEND_POLICY_QUEUE != c.SendPolicy || CONNECTOR_STATE_CLOSED == c.state {
+		return false
+	}
+	if len(c.pending) >= c.options.MaxChannelLen {
+		logger.Error("pending queue of connector[%s] is already full!!!", c.Addr)
+		return false
+	}
+	c.pending = append(c.pending, data)
+	return true
+}
+
+func (c *Connector) SendSync(data []byte) (int32, error) {
+	c.mutex.Lock()
+	client := c.client
+	c.mutex.Unlock()
+	if nil == client {
+		return 0, errors.New("connector is not connected")
+	}
+	return client.SendSync(data)
+}
+
+func (c *Connector) SendUnreliable(data []byte) bool {
+	c.mutex.Lock()
+	client := c.client
+	c.mutex.Unlock()
+	if nil == client {
+		return false
+	}
+	return client.SendUnreliable(data)
+}
+
+func (c *Connector) LocalAddr() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nil == c.client {
+		return ""
+	}
+	return c.client.LocalAddr()
+}
+
+func (c *Connector) RemoteAddr() string {
+	return c.Addr
+}
+
+func (c *Connector) SetLoginFlag(flag bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.loginFlag = flag
+}
+
+func (c *Connector) GetLoginFlag() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.loginFlag
+}
+
+func (c *Connector) SetIdentity(identity *Identity) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.identity = identity
+}
+
+func (c *Connector) GetIdentity() *Identity {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.identity
+}
+
+// 连接器的id在重连前后保持不变
+func (c *Connector) GetId() uint64 {
+	return c.id
+}
+
+func (c *Connector) BindUserId(userId int64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.userId = userId
+	return true
+}
+
+func (c *Connector) GetUserId() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.userId
+}
+
+func (c *Connector) Set(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nil == c.attributes {
+		c.attributes = make(map[string]interface{})
+	}
+	c.attributes[key] = value
+}
+
+func (c *Connector) Get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value, ok := c.attributes[key]
+	return value, ok
+}
+
+func (c *Connector) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.attributes, key)
+}
@@ -0,0 +1,143 @@
+package solidnet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 等待下一条状态消息
+func waitState(t *testing.T, p *ChannelProcessor) int32 {
+	for {
+		m := waitMessage(p, 5*time.Second)
+		if nil == m {
+			t.Fatal("timeout waiting for state message")
+		}
+		if sm, ok := m.(*StateMessage); ok {
+			return sm.state
+		}
+	}
+}
+
+// 连接协程开始退避等待后，返回等待的时长
+func waitBackoff(t *testing.T, clock *FakeClock) time.Duration {
+	deadline := time.Now().Add(5 * time.Second)
+	for 0 == clock.Waiters() {
+		if time.Now().After(deadline) {
+			t.Fatal("connector is not waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.waiters[0].at.Sub(clock.now)
+}
+
+func TestConnectorBackoff(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	dials := make(chan struct{}, 100)
+	c := NewConnector("test", NewChannelProcessorWithLen(10), testFactory{})
+	c.Clock = clock
+	c.MinBackoff = time.Second
+	c.MaxBackoff = 8 * time.Second
+	c.Dial = func(string) (net.Conn, error) {
+		dials <- struct{}{}
+		return nil, errors.New("connection refused")
+	}
+	c.Start()
+	defer c.Close()
+
+	backoff := c.MinBackoff
+	for i := 0; i < 8; i++ {
+		select {
+		case <-dials:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("dial %d does not happen", i)
+		}
+		wait := waitBackoff(t, clock)
+		if wait < backoff/2 || wait > backoff {
+			t.Fatalf("retry %d waits %s, want [%s, %s]", i, wait, backoff/2, backoff)
+		}
+		clock.Advance(wait)
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+func TestConnectorReconnect(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	p := NewChannelProcessorWithLen(100)
+	conns := make(chan net.Conn, 10)
+	c := NewConnector("test", p, testFactory{})
+	c.Clock = clock
+	c.MinBackoff = time.Second
+	c.MaxBackoff = time.Second
+	c.Dial = func(string) (net.Conn, error) {
+		local, remote := net.Pipe()
+		conns <- remote
+		return local, nil
+	}
+	c.Start()
+	defer c.Close()
+
+	remote := <-conns
+	if state := waitState(t, p); STATE_CONNECTED != state {
+		t.Fatalf("got state %d, want STATE_CONNECTED", state)
+	}
+	remote.Close()
+	if state := waitState(t, p); STATE_CLOSED != state {
+		t.Fatalf("got state %d, want STATE_CLOSED", state)
+	}
+
+	// 断线期间的数据先缓存，重连成功后发送
+	data := testPacket(1, 3)
+	if !c.Send(data) {
+		t.Fatal("send while disconnected failed")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for 0 == len(conns) {
+		if time.Now().After(deadline) {
+			t.Fatal("connector does not reconnect")
+		}
+		clock.Advance(time.Second)
+		time.Sleep(time.Millisecond)
+	}
+	remote = <-conns
+	defer remote.Close()
+	if state := waitState(t, p); STATE_CONNECTED != state {
+		t.Fatalf("got state %d, want STATE_CONNECTED", state)
+	}
+	buf := make([]byte, len(data))
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(remote, buf); nil != err {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, buf) {
+		t.Fatalf("got %v, want %v", buf, data)
+	}
+}
+
+func TestConnectorClosedWhileDialing(t *testing.T) {
+	p := NewChannelProcessorWithLen(100)
+	c := NewConnector("test", p, testFactory{})
+	done := make(chan struct{})
+	c.Dial = func(string) (net.Conn, error) {
+		// 连接建立之前连接器已经关闭
+		c.Close()
+		close(done)
+		local, _ := net.Pipe()
+		return local, nil
+	}
+	c.Start()
+	<-done
+
+	// 没有投递STATE_CONNECTED，也不能投递STATE_CLOSED
+	if m := waitMessage(p, 200*time.Millisecond); nil != m {
+		t.Fatalf("unexpected message %T", m)
+	}
+}
@@ -0,0 +1,241 @@
+package solidnet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 定时计划，返回after之后的下一次触发时间，零值表示不再触发
+type ISchedule interface {
+	Next(after time.Time) time.Time
+}
+
+const (
+	CRON_MAX_YEARS = 5 // 查找下一次触发时间的最大年数
+)
+
+// cron表达式的字段范围
+type cronField struct {
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	cronMinute = cronField{0, 59, nil}
+	cronHour   = cronField{0, 23, nil}
+	cronDom    = cronField{1, 31, nil}
+	cronMonth  = cronField{1, 12, map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	cronDow = cronField{0, 7, map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// cron计划，标准的5个字段：分 时 日 月 周，按指定时区的本地时间计算。
+// 夏令时：被跳过的本地时间在切换后触发一次，重复的本地时间只触发一次
+type CronSchedule struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+	loc     *time.Location
+}
+
+// 解析cron表达式，loc为空时使用time.Local。
+// 支持 * 、数字、a-b 、*/n 、a-b/n 、逗号分隔的列表，月和周支持英文缩写，周日可以写作0或7，
+// 以及@yearly、@monthly、@weekly、@daily、@hourly
+func ParseCron(expr string, loc *time.Location) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[expr]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if 5 != len(fields) {
+		return nil, fmt.Errorf("cron expression[%s] must have 5 fields", expr)
+	}
+	if nil == loc {
+		loc = time.Local
+	}
+
+	s := &CronSchedule{loc: loc}
+	var err error
+	if s.minute, err = cronMinute.parse(fields[0]); nil != err {
+		return nil, err
+	}
+	if s.hour, err = cronHour.parse(fields[1]); nil != err {
+		return nil, err
+	}
+	if s.dom, err = cronDom.parse(fields[2]); nil != err {
+		return nil, err
+	}
+	if s.month, err = cronMonth.parse(fields[3]); nil != err {
+		return nil, err
+	}
+	if s.dow, err = cronDow.parse(fields[4]); nil != err {
+		return nil, err
+	}
+	// 周日可以写作7
+	if 0 != s.dow&(1<<7) {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if nil != err {
+		return 0, fmt.Errorf("invalid cron value[%s]", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("cron value[%d] out of range[%d, %d]", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// 解析一个字段，返回位图
+func (f cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if nil != err || step <= 0 {
+				return 0, fmt.Errorf("invalid cron step[%s]", part)
+			}
+			part = part[:i]
+		}
+
+		begin, end := f.min, f.max
+		switch {
+		case "*" == part:
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if begin, err = f.value(part[:i]); nil != err {
+				return 0, err
+			}
+			if end, err = f.value(part[i+1:]); nil != err {
+				return 0, err
+			}
+			if begin > end {
+				return 0, fmt.Errorf("invalid cron range[%s]", part)
+			}
+		default:
+			v, err := f.value(part)
+			if nil != err {
+				return 0, err
+			}
+			begin = v
+			if 1 == step {
+				end = v
+			}
+		}
+		for v := begin; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	if 0 == bits {
+		return 0, errors.New("empty cron field")
+	}
+	return bits, nil
+}
+
+func (s *CronSchedule) dayMatches(year int, month time.Month, day int) bool {
+	if 0 == s.month&(1<<uint(month)) {
+		return false
+	}
+	// 按UTC计算日期，不受夏令时影响
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	domMatch := 0 != s.dom&(1<<uint(day))
+	dowMatch := 0 != s.dow&(1<<uint(date.Weekday()))
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	// 日和周都有限制时，满足其一即可
+	return domMatch || dowMatch
+}
+
+// 实现 ISchedule：按本地时间逐日查找，找到的本地时间换算为绝对时间后必须晚于after
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	local := after.In(s.loc)
+	// 从after的下一分钟开始
+	year, month, day := local.Date()
+	hour, minute := local.Hour(), local.Minute()+1
+
+	for i := 0; i < CRON_MAX_YEARS*366; i++ {
+		date := time.Date(year, month, day+i, 0, 0, 0, 0, time.UTC)
+		y, m, d := date.Date()
+		if s.dayMatches(y, m, d) {
+			for h := 0; h < 24; h++ {
+				if 0 == s.hour&(1<<uint(h)) || (0 == i && h < hour) {
+					continue
+				}
+				for min := 0; min < 60; min++ {
+					if 0 == s.minute&(1<<uint(min)) || (0 == i && h == hour && min < minute) {
+						continue
+					}
+					t := cronTime(y, m, d, h, min, s.loc)
+					if t.After(after) {
+						return t
+					}
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// 本地时间换算为绝对时间，夏令时切换跳过的本地时间换算为切换的时刻
+func cronTime(year int, month time.Month, day int, hour int, minute int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, minute, 0, 0, loc)
+	if t.Hour() == hour && t.Minute() == minute {
+		return t
+	}
+	// 该本地时间不存在，找到切换后的第一分钟
+	t = t.Truncate(time.Minute)
+	for i := 0; i < 24*60; i++ {
+		t = t.Add(time.Minute)
+		local := t.In(loc)
+		if local.Day() != day || local.Hour() > hour || (local.Hour() == hour && local.Minute() >= minute) {
+			return t
+		}
+	}
+	return t
+}
+
+// 在某个绝对时间触发一次
+type AtSchedule struct {
+	At time.Time
+}
+
+func (s *AtSchedule) Next(after time.Time) time.Time {
+	if s.At.After(after) {
+		return s.At
+	}
+	return time.Time{}
+}
@@ -0,0 +1,158 @@
+package solidnet
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseCronNext(t *testing.T) {
+	// 2024-09-01是周日
+	start := time.Date(2024, 9, 1, 10, 7, 30, 0, time.UTC)
+	for _, c := range []struct {
+		expr string
+		want []time.Time
+	}{
+		{"*/15 * * * *", []time.Time{
+			time.Date(2024, 9, 1, 10, 15, 0, 0, time.UTC),
+			time.Date(2024, 9, 1, 10, 30, 0, 0, time.UTC),
+		}},
+		{"0 9-17/4 * * MON-FRI", []time.Time{
+			time.Date(2024, 9, 2, 9, 0, 0, 0, time.UTC),
+			time.Date(2024, 9, 2, 13, 0, 0, 0, time.UTC),
+			time.Date(2024, 9, 2, 17, 0, 0, 0, time.UTC),
+			time.Date(2024, 9, 3, 9, 0, 0, 0, time.UTC),
+		}},
+		{"5,10 0 1 jan,Jul *", []time.Time{
+			time.Date(2025, 1, 1, 0, 5, 0, 0, time.UTC),
+			time.Date(2025, 1, 1, 0, 10, 0, 0, time.UTC),
+			time.Date(2025, 7, 1, 0, 5, 0, 0, time.UTC),
+		}},
+		// 日和周都有限制时满足其一即可
+		{"0 0 13 * FRI", []time.Time{
+			time.Date(2024, 9, 6, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 9, 13, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 9, 20, 0, 0, 0, 0, time.UTC),
+		}},
+		// 周日可以写作7
+		{"0 12 * * 7", []time.Time{
+			time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC),
+			time.Date(2024, 9, 8, 12, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 29 2 *", []time.Time{
+			time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		}},
+		{"@daily", []time.Time{
+			time.Date(2024, 9, 2, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 9, 3, 0, 0, 0, 0, time.UTC),
+		}},
+		{"@hourly", []time.Time{
+			time.Date(2024, 9, 1, 11, 0, 0, 0, time.UTC),
+		}},
+	} {
+		s, err := ParseCron(c.expr, time.UTC)
+		if nil != err {
+			t.Fatalf("%s: %s", c.expr, err.Error())
+		}
+		after := start
+		for i, want := range c.want {
+			after = s.Next(after)
+			if !after.Equal(want) {
+				t.Fatalf("%s: firing %d at %s, want %s", c.expr, i, after, want)
+			}
+		}
+	}
+
+	// 超过CRON_MAX_YEARS都不会触发
+	s, err := ParseCron("0 0 31 2 *", time.UTC)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if next := s.Next(start); !next.IsZero() {
+		t.Fatalf("Feb 31 fires at %s", next)
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"* * * FOO *",
+		"* * * * MON-",
+		"@weekly 1",
+		"@every 1h",
+	} {
+		if _, err := ParseCron(expr, time.UTC); nil == err {
+			t.Errorf("%q is accepted", expr)
+		}
+	}
+}
+
+func TestCronDaylightSaving(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+
+	// 2024-03-10 02:00跳到03:00，被跳过的02:30在03:00触发一次
+	s, err := ParseCron("30 2 * * *", ny)
+	if nil != err {
+		t.Fatal(err)
+	}
+	next := s.Next(time.Date(2024, 3, 9, 12, 0, 0, 0, ny))
+	if want := time.Date(2024, 3, 10, 3, 0, 0, 0, ny); !next.Equal(want) {
+		t.Fatalf("skipped 02:30 fires at %s, want %s", next, want)
+	}
+	next = s.Next(next)
+	if want := time.Date(2024, 3, 11, 2, 30, 0, 0, ny); !next.Equal(want) {
+		t.Fatalf("next firing at %s, want %s", next, want)
+	}
+
+	// 2024-11-03 02:00回到01:00，重复的01:30只触发一次
+	s, err = ParseCron("30 1 * * *", ny)
+	if nil != err {
+		t.Fatal(err)
+	}
+	first := s.Next(time.Date(2024, 11, 2, 12, 0, 0, 0, ny))
+	if 1 != first.In(ny).Hour() || 30 != first.In(ny).Minute() || 3 != first.In(ny).Day() {
+		t.Fatalf("repeated 01:30 fires at %s", first.In(ny))
+	}
+	next = s.Next(first)
+	if want := time.Date(2024, 11, 4, 1, 30, 0, 0, ny); !next.Equal(want) {
+		t.Fatalf("repeated 01:30 fires again at %s, want %s", next.In(ny), want)
+	}
+	// 第二个01:30之前开始查找，也不会再次触发
+	second := first.Add(time.Hour)
+	if next := s.Next(second.Add(-10 * time.Minute)); !next.Equal(time.Date(2024, 11, 4, 1, 30, 0, 0, ny)) {
+		t.Fatalf("repeated 01:30 fires at %s after the fallback", next.In(ny))
+	}
+}
+
+func TestAtSchedule(t *testing.T) {
+	at := time.Date(2024, 9, 1, 10, 0, 0, 0, time.UTC)
+	s := &AtSchedule{At: at}
+	if next := s.Next(at.Add(-time.Second)); !next.Equal(at) {
+		t.Fatalf("got %s, want %s", next, at)
+	}
+	if next := s.Next(at); !next.IsZero() {
+		t.Fatalf("fires again at %s", next)
+	}
+}
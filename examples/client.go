@@ -23,7 +23,10 @@ func Send(conn net.Conn, wg *sync.WaitGroup) {
 	for {
 		p := NewPacket()
 		p.WriteBegin(CLIENT_COMMAND_TIME_REQ, 0)
-		p.WriteEnd()
+		if err := p.WriteEnd(); nil != err {
+			fmt.Printf("p.WriteEnd() failed, err:%s", err.Error())
+			return
+		}
 		_, err := conn.Write(p.GetData())
 		if nil != err {
 			fmt.Printf("c.Write() failed, err:%s", err.Error())
@@ -59,7 +62,10 @@ func Recv(conn net.Conn, wg *sync.WaitGroup, authFlag chan int32) {
 			return
 		}
 		p.WriteBytes(data)
-		p.WriteEnd()
+		if err = p.WriteEnd(); nil != err {
+			fmt.Printf("p.WriteEnd() failed, error[%s]", err.Error())
+			return
+		}
 
 		// 解析包的内容
 		cmd := p.GetCmd()
@@ -102,7 +108,10 @@ func CreateConnect(num int, addr string, wgs *sync.WaitGroup, isAuth bool) {
 		// 如果不认证，这里写一个错误的key，服务端认证不成功，将关闭连接
 		p.WriteInt32(0)
 	}
-	p.WriteEnd()
+	if err = p.WriteEnd(); nil != err {
+		fmt.Printf("p.WriteEnd() failed, err:%s\n", err.Error())
+		return
+	}
 	_, err = c.Write(p.GetData())
 	if nil != err {
 		fmt.Printf("c.Write() failed, err:%s\n", err.Error())
@@ -4,79 +4,61 @@ import (
 	"time"
 
 	solidnet "github.com/idakun/solidnet"
-
-	logger "github.com/idakun/tinylog"
 )
 
-type Handler struct {
-	router map[uint16]func(*Packet, solidnet.IClient) int
+func GetCmd(p solidnet.IPacket) int32 {
+	return p.(*Packet).GetCmd()
 }
 
 func NewHandler() solidnet.IHandler {
-	h := &Handler{router: make(map[uint16]func(*Packet, solidnet.IClient) int)}
-	h.RegisterRouter(CLIENT_COMMAND_TIME_REQ, h.HandleTimeReq)
-	h.RegisterRouter(CLIENT_COMMAND_LOGIN_AUTH, h.HandleLoginAuth)
-	return h
-}
-
-// 派发定时器消息
-func (h *Handler) HandleTimer(message solidnet.IMessage) {
-	id := (message.Data()).(int32)
-	handler := (message.Args()).(solidnet.ITimerHandler)
-	handler.DoTimerAction(id)
-}
-
-// 派发网络消息
-func (h *Handler) HandleNet(message solidnet.IMessage) {
-	buf := (message.Data()).([]byte)
-	c := (message.Args()).(solidnet.IClient)
-
-	p := NewPacket()
-	p.Refer(buf)
-	cmd := uint16(p.GetCmd())
-
-	h.handle(cmd, p, c)
+	r := solidnet.NewRouter(NewPacketFactory(), GetCmd)
+	r.Register(CLIENT_COMMAND_TIME_REQ, HandleTimeReq)
+	solidnet.Handle(r, CLIENT_COMMAND_LOGIN_AUTH, HandleLoginAuth)
+	r.SetStateHandler(HandleState)
+
+	// 捕获panic，未登录的客户端只能发送登录请求
+	return solidnet.NewHandlerChain(r,
+		solidnet.RecoverMiddleware(),
+		solidnet.LoginCheckMiddleware(NewPacketFactory(), GetCmd, CLIENT_COMMAND_LOGIN_AUTH))
 }
 
-// 派发状态消息
-func (h *Handler) HandleState(message solidnet.IMessage) {
-	state := (message.Data()).(int32)
-	client := (message.Args()).(solidnet.IClient)
-	_ = client
-	if solidnet.STATE_CLOSED == state {
-		// 处理断线
-		//logger.Debug("client[%s] closed.", client.RemoteAddr())
-		// ......
-	} else if solidnet.STATE_CONNECTED == state {
+// 处理状态消息，除了连接和断线还有STATE_IDLE、STATE_AUTHENTICATED，
+// 不能把其他状态都当作连接
+func HandleState(state int32, reason int32, client solidnet.IClient) {
+	switch state {
+	case solidnet.STATE_CONNECTED:
 		// 这里可以限制时间，connect之后，不登陆则关闭连接
 		//logger.Debug("client[%s] connected.", client.RemoteAddr())
 		// ......
+	case solidnet.STATE_AUTHENTICATED:
+		// 登录认证成功
+		// ......
+	case solidnet.STATE_IDLE:
+		// 读空闲超时，连接随后断开，还会收到STATE_CLOSED，这里不需要清理
+		//logger.Debug("client[%s] idle.", client.RemoteAddr())
+	case solidnet.STATE_CLOSED:
+		// 处理断线，reason为断线原因，例如CLOSE_REASON_DUPLICATE_LOGIN
+		//logger.Debug("client[%s] closed, reason[%d].", client.RemoteAddr(), reason)
+		// ......
 	}
 }
 
-func (h *Handler) handle(cmd uint16, in *Packet, c solidnet.IClient) {
-	if handle, ok := h.router[cmd]; ok {
-		handle(in, c)
-	} else {
-		logger.Error("Not find handler of cmd:%x", cmd)
-	}
-}
-
-func (h *Handler) RegisterRouter(cmd uint16, handle func(*Packet, solidnet.IClient) int) {
-	h.router[cmd] = handle
-}
-
-func (h *Handler) HandleTimeReq(packet *Packet, c solidnet.IClient) int {
+func HandleTimeReq(packet solidnet.IPacket, c solidnet.IClient) {
 	p := NewPacket()
 	p.WriteBegin(SERVER_COMMAND_TIME_RESP, 0)
 	p.WriteString(time.Now().Format("2006-01-02 15:04:05"))
-	p.WriteEnd()
+	if nil != p.WriteEnd() {
+		return
+	}
 	c.Send(p.GetData())
-	return 0
 }
 
-func (h *Handler) HandleLoginAuth(packet *Packet, c solidnet.IClient) int {
+func HandleLoginAuth(packet *Packet, c solidnet.IClient) {
 	authKey := packet.ReadInt32()
+	if nil != packet.Err() {
+		// 数据不完整的包直接丢弃
+		return
+	}
 	var authSuccess int32
 	if AUTH_KEY == authKey {
 		// 设置客户端登录标记
@@ -88,8 +70,8 @@ func (h *Handler) HandleLoginAuth(packet *Packet, c solidnet.IClient) int {
 	p := NewPacket()
 	p.WriteBegin(SERVER_COMMAND_AUTH_SUCCESS, 0)
 	p.WriteInt32(authSuccess)
-	p.WriteEnd()
+	if nil != p.WriteEnd() {
+		return
+	}
 	c.Send(p.GetData())
-
-	return 0
 }
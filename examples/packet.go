@@ -65,10 +65,13 @@ func (p *Packet) WriteBegin(cmd int16, version byte) {
 	p.WriteInt16(0)
 }
 
-func (p *Packet) WriteEnd() {
-	bodyLen := int16(p.GetTotalLen() - p.GetHeadLen())
-	binary.LittleEndian.PutUint16(p.Data[p.BodyLenIndex:], uint16(bodyLen))
+// 写入包体长度，包体超过长度字段能表示的范围时返回错误
+func (p *Packet) WriteEnd() error {
+	if err := p.SetBodyLen(p.GetTotalLen() - p.GetHeadLen()); nil != err {
+		return err
+	}
 	p.Index = p.GetHeadLen()
+	return nil
 }
 
 func (p *Packet) GetCmd() int32 {
@@ -0,0 +1,176 @@
+// Code generated by solidnet-gen. DO NOT EDIT.
+// source: example.idl
+
+package proto
+
+import (
+	"fmt"
+
+	solidnet "github.com/idakun/solidnet"
+)
+
+// 命令字
+type Command int32
+
+const (
+	CLIENT_COMMAND_LOGIN_AUTH   Command = 0x1000
+	SERVER_COMMAND_AUTH_SUCCESS Command = 0x1001
+	CLIENT_COMMAND_TIME_REQ     Command = 0x1002
+	SERVER_COMMAND_TIME_RESP    Command = 0x1003
+)
+
+func (c Command) String() string {
+	switch c {
+	case CLIENT_COMMAND_LOGIN_AUTH:
+		return "CLIENT_COMMAND_LOGIN_AUTH"
+	case SERVER_COMMAND_AUTH_SUCCESS:
+		return "SERVER_COMMAND_AUTH_SUCCESS"
+	case CLIENT_COMMAND_TIME_REQ:
+		return "CLIENT_COMMAND_TIME_REQ"
+	case SERVER_COMMAND_TIME_RESP:
+		return "SERVER_COMMAND_TIME_RESP"
+	}
+	return fmt.Sprintf("Command(0x%x)", int32(c))
+}
+
+// 命令字CLIENT_COMMAND_LOGIN_AUTH
+type LoginAuth struct {
+	AuthKey int32
+}
+
+func (m *LoginAuth) Cmd() Command {
+	return CLIENT_COMMAND_LOGIN_AUTH
+}
+
+// 把消息追加到p中，包头需要调用者写入
+func (m *LoginAuth) Encode(p solidnet.IPacketEx) {
+	p.WriteInt32(m.AuthKey)
+}
+
+// 从p的当前位置读取消息，数据不足时返回p.Err()
+func (m *LoginAuth) Decode(p solidnet.IPacketEx) error {
+	m.AuthKey = p.ReadInt32()
+	return p.Err()
+}
+
+// 命令字SERVER_COMMAND_AUTH_SUCCESS
+type AuthSuccess struct {
+	Result int32
+}
+
+func (m *AuthSuccess) Cmd() Command {
+	return SERVER_COMMAND_AUTH_SUCCESS
+}
+
+// 把消息追加到p中，包头需要调用者写入
+func (m *AuthSuccess) Encode(p solidnet.IPacketEx) {
+	p.WriteInt32(m.Result)
+}
+
+// 从p的当前位置读取消息，数据不足时返回p.Err()
+func (m *AuthSuccess) Decode(p solidnet.IPacketEx) error {
+	m.Result = p.ReadInt32()
+	return p.Err()
+}
+
+// 命令字CLIENT_COMMAND_TIME_REQ
+type TimeReq struct {
+}
+
+func (m *TimeReq) Cmd() Command {
+	return CLIENT_COMMAND_TIME_REQ
+}
+
+// 把消息追加到p中，包头需要调用者写入
+func (m *TimeReq) Encode(p solidnet.IPacketEx) {
+}
+
+// 从p的当前位置读取消息，数据不足时返回p.Err()
+func (m *TimeReq) Decode(p solidnet.IPacketEx) error {
+	return p.Err()
+}
+
+// 命令字SERVER_COMMAND_TIME_RESP
+type TimeResp struct {
+	Time string
+}
+
+func (m *TimeResp) Cmd() Command {
+	return SERVER_COMMAND_TIME_RESP
+}
+
+// 把消息追加到p中，包头需要调用者写入
+func (m *TimeResp) Encode(p solidnet.IPacketEx) {
+	p.WriteString(m.Time)
+}
+
+// 从p的当前位置读取消息，数据不足时返回p.Err()
+func (m *TimeResp) Decode(p solidnet.IPacketEx) error {
+	m.Time = p.ReadString()
+	return p.Err()
+}
+
+// 消息处理接口，嵌入UnimplementedHandler后只需要实现关心的消息
+type Handler interface {
+	HandleLoginAuth(m *LoginAuth, c solidnet.IClient)
+	HandleAuthSuccess(m *AuthSuccess, c solidnet.IClient)
+	HandleTimeReq(m *TimeReq, c solidnet.IClient)
+	HandleTimeResp(m *TimeResp, c solidnet.IClient)
+	// 解码失败，默认丢弃该包
+	OnDecodeError(cmd Command, err error, c solidnet.IClient)
+}
+
+// 未实现的消息处理，直接丢弃
+type UnimplementedHandler struct{}
+
+func (UnimplementedHandler) HandleLoginAuth(m *LoginAuth, c solidnet.IClient)         {}
+func (UnimplementedHandler) HandleAuthSuccess(m *AuthSuccess, c solidnet.IClient)     {}
+func (UnimplementedHandler) HandleTimeReq(m *TimeReq, c solidnet.IClient)             {}
+func (UnimplementedHandler) HandleTimeResp(m *TimeResp, c solidnet.IClient)           {}
+func (UnimplementedHandler) OnDecodeError(cmd Command, err error, c solidnet.IClient) {}
+
+// 向路由注册所有绑定了命令字的消息，必须在Game.Run之前调用。
+// 路由的IPacketFactory创建的数据包必须实现solidnet.IPacketEx，否则返回错误
+func RegisterHandlers(r *solidnet.Router, h Handler) error {
+	if err := solidnet.Handle(r, int32(CLIENT_COMMAND_LOGIN_AUTH), func(p solidnet.IPacketEx, c solidnet.IClient) {
+		m := new(LoginAuth)
+		if err := m.Decode(p); nil != err {
+			h.OnDecodeError(CLIENT_COMMAND_LOGIN_AUTH, err, c)
+			return
+		}
+		h.HandleLoginAuth(m, c)
+	}); nil != err {
+		return err
+	}
+	if err := solidnet.Handle(r, int32(SERVER_COMMAND_AUTH_SUCCESS), func(p solidnet.IPacketEx, c solidnet.IClient) {
+		m := new(AuthSuccess)
+		if err := m.Decode(p); nil != err {
+			h.OnDecodeError(SERVER_COMMAND_AUTH_SUCCESS, err, c)
+			return
+		}
+		h.HandleAuthSuccess(m, c)
+	}); nil != err {
+		return err
+	}
+	if err := solidnet.Handle(r, int32(CLIENT_COMMAND_TIME_REQ), func(p solidnet.IPacketEx, c solidnet.IClient) {
+		m := new(TimeReq)
+		if err := m.Decode(p); nil != err {
+			h.OnDecodeError(CLIENT_COMMAND_TIME_REQ, err, c)
+			return
+		}
+		h.HandleTimeReq(m, c)
+	}); nil != err {
+		return err
+	}
+	if err := solidnet.Handle(r, int32(SERVER_COMMAND_TIME_RESP), func(p solidnet.IPacketEx, c solidnet.IClient) {
+		m := new(TimeResp)
+		if err := m.Decode(p); nil != err {
+			h.OnDecodeError(SERVER_COMMAND_TIME_RESP, err, c)
+			return
+		}
+		h.HandleTimeResp(m, c)
+	}); nil != err {
+		return err
+	}
+	return nil
+}
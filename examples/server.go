@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"time"
 
 	solidnet "github.com/idakun/solidnet"
 )
@@ -15,5 +16,5 @@ func main() {
 	}
 	addr := os.Args[1]
 	runtime.GOMAXPROCS(1)
-	solidnet.Run(solidnet.NewGame(addr, "testserver", ".", NewHandler(), NewPacketFactory()))
+	solidnet.RunWithSignal(solidnet.NewGame(addr, "testserver", ".", NewHandler(), NewPacketFactory()), 10*time.Second)
 }
@@ -0,0 +1,203 @@
+package solidnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// 长度字段的宽度
+const (
+	LENGTH_WIDTH_VARINT = -1 // uvarint变长编码，必须是包头的最后一个字段
+	LENGTH_WIDTH_1      = 1
+	LENGTH_WIDTH_2      = 2 // 默认
+	LENGTH_WIDTH_4      = 4
+)
+
+// 包头中长度字段的格式，BasePacket.LengthField为空时是BodyLenIndex处的2字节小端包体长度。
+// 使用变长编码时，HeadLen为长度字段只占1个字节时的包头长度，实际的包头长度见GetHeadLen
+type LengthField struct {
+	Width        int  // LENGTH_WIDTH_*，0等同于LENGTH_WIDTH_2
+	BigEndian    bool // 大端，变长编码时忽略
+	IncludesHead bool // 长度字段的值包含包头的长度
+}
+
+// 包头长度可变的数据包需要实现的接口，BaseClient.recv读完HeadLen字节的包头后，
+// 按MoreHeadLen继续读取，直到包头完整
+type IVarHeadPacket interface {
+	MoreHeadLen() int32 // 还需要读取的包头字节数，0表示包头已经完整
+}
+
+// 需要检查包头格式的数据包，服务启动时检查IPacketFactory创建的数据包
+type IValidPacket interface {
+	Validate() error
+}
+
+// 检查factory创建的数据包，没有实现IValidPacket时不检查
+func validateFactory(factory IPacketFactory) error {
+	if p, ok := factory.NewPacket().(IValidPacket); ok {
+		return p.Validate()
+	}
+	return nil
+}
+
+func (f *LengthField) width() int {
+	if nil == f || 0 == f.Width {
+		return LENGTH_WIDTH_2
+	}
+	return f.Width
+}
+
+// 定长字段能表示的最大值
+func (f *LengthField) maxValue() int64 {
+	switch f.width() {
+	case LENGTH_WIDTH_1:
+		return math.MaxUint8
+	case LENGTH_WIDTH_2:
+		return math.MaxUint16
+	case LENGTH_WIDTH_4:
+		return math.MaxUint32
+	}
+	return math.MaxInt32
+}
+
+func (f *LengthField) order() binary.ByteOrder {
+	if nil != f && f.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// 变长编码的长度字段已经读到的值和字节数，字段不完整时n为0，溢出时n小于0
+func (p *BasePacket) lengthUvarint() (uint64, int) {
+	if p.BodyLenIndex >= int32(len(p.Data)) {
+		return 0, 0
+	}
+	data := p.Data[p.BodyLenIndex:]
+	value, n := binary.Uvarint(data)
+	if n > binary.MaxVarintLen32 || (0 == n && len(data) >= binary.MaxVarintLen32) {
+		return 0, -1
+	}
+	return value, n
+}
+
+// 检查长度字段的格式和位置：宽度必须是LENGTH_WIDTH_*，定长字段必须完整地在包头中，
+// 变长字段必须是包头的最后一个字段
+func (p *BasePacket) Validate() error {
+	f := p.LengthField
+	if p.BodyLenIndex < 0 {
+		return fmt.Errorf("body length index[%d] is negative", p.BodyLenIndex)
+	}
+	switch width := f.width(); width {
+	case LENGTH_WIDTH_VARINT:
+		if p.HeadLen != p.BodyLenIndex+1 {
+			return fmt.Errorf("varint length field must end the head, head length[%d] body length index[%d]", p.HeadLen, p.BodyLenIndex)
+		}
+	case LENGTH_WIDTH_1, LENGTH_WIDTH_2, LENGTH_WIDTH_4:
+		if p.HeadLen < p.BodyLenIndex+int32(width) {
+			return fmt.Errorf("length field[%d, %d) is out of head length[%d]", p.BodyLenIndex, p.BodyLenIndex+int32(width), p.HeadLen)
+		}
+	default:
+		return fmt.Errorf("invalid length field width[%d]", width)
+	}
+	return nil
+}
+
+// 长度字段的值，数据中没有完整的长度字段时返回-1
+func (p *BasePacket) lengthValue() int64 {
+	f := p.LengthField
+	if width := f.width(); width > 0 && int64(p.BodyLenIndex)+int64(width) > int64(len(p.Data)) {
+		return -1
+	}
+	switch f.width() {
+	case LENGTH_WIDTH_VARINT:
+		value, n := p.lengthUvarint()
+		if n <= 0 {
+			return -1
+		}
+		return int64(value)
+	case LENGTH_WIDTH_1:
+		return int64(p.Data[p.BodyLenIndex])
+	case LENGTH_WIDTH_4:
+		return int64(f.order().Uint32(p.Data[p.BodyLenIndex:]))
+	default:
+		return int64(f.order().Uint16(p.Data[p.BodyLenIndex:]))
+	}
+}
+
+// 实现 IVarHeadPacket
+func (p *BasePacket) MoreHeadLen() int32 {
+	if LENGTH_WIDTH_VARINT != p.LengthField.width() {
+		return 0
+	}
+	if _, n := p.lengthUvarint(); 0 == n {
+		return 1
+	}
+	return 0
+}
+
+// 按长度字段的格式写入包体长度，包头必须已经写入。
+// 变长编码时替换已有的长度字段（没有写入时按1字节的占位计算），包体随之移动。
+// 长度超过字段能表示的范围时返回错误，不修改数据
+func (p *BasePacket) SetBodyLen(bodyLen int32) error {
+	f := p.LengthField
+	if bodyLen < 0 {
+		return fmt.Errorf("body length[%d] is negative", bodyLen)
+	}
+	length := int64(bodyLen)
+	if nil != f && f.IncludesHead {
+		length += int64(p.HeadLen)
+	}
+	if width := f.width(); width > 0 && int64(p.BodyLenIndex)+int64(width) > int64(len(p.Data)) {
+		return fmt.Errorf("head is not written, packet length[%d]", len(p.Data))
+	}
+	switch f.width() {
+	case LENGTH_WIDTH_VARINT:
+		old := 1
+		if _, n := p.lengthUvarint(); n > 0 {
+			old = n
+		}
+		if f.IncludesHead {
+			// 包头长度和长度字段本身的字节数有关
+			length = int64(bodyLen) + int64(p.BodyLenIndex)
+			for n := 1; ; n++ {
+				if uvarintLen(uint64(length)+uint64(n)) == n {
+					length += int64(n)
+					break
+				}
+			}
+		}
+		if length > f.maxValue() {
+			return fmt.Errorf("length[%d] overflows varint length field", length)
+		}
+		if int64(p.BodyLenIndex)+int64(old) > int64(len(p.Data)) {
+			return fmt.Errorf("head is not written, packet length[%d]", len(p.Data))
+		}
+		buf := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(buf, uint64(length))
+		body := append([]byte(nil), p.Data[p.BodyLenIndex+int32(old):]...)
+		p.Data = append(append(p.Data[:p.BodyLenIndex], buf[:n]...), body...)
+		return nil
+	}
+	if length > f.maxValue() {
+		return fmt.Errorf("length[%d] overflows %d-byte length field", length, f.width())
+	}
+	switch f.width() {
+	case LENGTH_WIDTH_1:
+		p.Data[p.BodyLenIndex] = byte(length)
+	case LENGTH_WIDTH_4:
+		f.order().PutUint32(p.Data[p.BodyLenIndex:], uint32(length))
+	default:
+		f.order().PutUint16(p.Data[p.BodyLenIndex:], uint16(length))
+	}
+	return nil
+}
+
+func uvarintLen(value uint64) int {
+	n := 1
+	for value >= 0x80 {
+		value >>= 7
+		n++
+	}
+	return n
+}
@@ -0,0 +1,148 @@
+package solidnet
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// 测试用的长度字段格式：第0字节是序号，长度字段从第1字节开始
+type framingFactory struct {
+	field   *LengthField
+	headLen int32
+}
+
+func (f framingFactory) NewPacket() IPacket {
+	return &BasePacket{HeadLen: f.headLen, BodyLenIndex: 1, LengthField: f.field}
+}
+
+// 序号为seq、包体长度为size的数据包
+func framingPacket(t *testing.T, f framingFactory, seq byte, size int) []byte {
+	p := f.NewPacket().(*BasePacket)
+	p.WriteByte(seq)
+	p.WriteBytes(make([]byte, f.headLen-1))
+	p.WriteBytes(make([]byte, size))
+	if err := p.SetBodyLen(int32(size)); nil != err {
+		t.Fatal(err)
+	}
+	return p.GetData()
+}
+
+var framingCases = []framingFactory{
+	{nil, 3},
+	{&LengthField{Width: LENGTH_WIDTH_1}, 2},
+	{&LengthField{Width: LENGTH_WIDTH_1, IncludesHead: true}, 2},
+	{&LengthField{Width: LENGTH_WIDTH_2, BigEndian: true}, 3},
+	{&LengthField{Width: LENGTH_WIDTH_2, IncludesHead: true}, 3},
+	{&LengthField{Width: LENGTH_WIDTH_4}, 5},
+	{&LengthField{Width: LENGTH_WIDTH_4, BigEndian: true, IncludesHead: true}, 5},
+	{&LengthField{Width: LENGTH_WIDTH_VARINT}, 2},
+	{&LengthField{Width: LENGTH_WIDTH_VARINT, IncludesHead: true}, 2},
+}
+
+func TestFramingRecv(t *testing.T) {
+	for i, f := range framingCases {
+		if err := f.NewPacket().(*BasePacket).Validate(); nil != err {
+			t.Fatalf("case %d: %s", i, err.Error())
+		}
+		// 覆盖变长编码1、2、3字节的边界
+		sizes := []int{0, 5, 100, 200}
+		if nil == f.field || LENGTH_WIDTH_1 != f.field.Width {
+			sizes = append(sizes, 126, 127, 128, 300, 16381, 16382, 16383, 16384, 20000)
+		}
+		local, remote := net.Pipe()
+		c := newBaseClient(local, f, RealClock, NewOptions(WithMaxUserPacketLen(100000)))
+		packets := make([][]byte, len(sizes))
+		for j, size := range sizes {
+			packets[j] = framingPacket(t, f, byte(j), size)
+		}
+		go func() {
+			for _, data := range packets {
+				remote.Write(data)
+			}
+		}()
+		for j, size := range sizes {
+			select {
+			case data := <-c.input:
+				p := f.NewPacket()
+				p.Refer(data)
+				if int32(size) != p.GetBodyLen() || int32(size) != p.GetTotalLen()-p.GetHeadLen() || byte(j) != data[0] {
+					t.Fatalf("case %d size %d: got body %d head %d total %d", i, size, p.GetBodyLen(), p.GetHeadLen(), p.GetTotalLen())
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("case %d size %d: timeout", i, size)
+			}
+		}
+		remote.Close()
+		c.stop()
+	}
+}
+
+func TestFramingVarintIncludesHead(t *testing.T) {
+	f := framingFactory{&LengthField{Width: LENGTH_WIDTH_VARINT, IncludesHead: true}, 2}
+	// 包头1字节加上长度字段本身，总长度跨过变长编码字节数的边界
+	for _, size := range []int{0, 125, 126, 127, 16380, 16381, 16382} {
+		data := framingPacket(t, f, 0, size)
+		p := f.NewPacket()
+		p.Refer(data)
+		if int32(len(data)) != p.GetHeadLen()+p.GetBodyLen() || int32(size) != p.GetBodyLen() {
+			t.Fatalf("size %d: head %d body %d total %d", size, p.GetHeadLen(), p.GetBodyLen(), len(data))
+		}
+		// 长度字段的值是整个包的长度
+		value, _ := p.(*BasePacket).lengthUvarint()
+		if uint64(len(data)) != value {
+			t.Fatalf("size %d: length field %d, packet length %d", size, value, len(data))
+		}
+	}
+}
+
+func TestSetBodyLenOverflow(t *testing.T) {
+	cases := []struct {
+		f       framingFactory
+		maxBody int32
+	}{
+		{framingFactory{&LengthField{Width: LENGTH_WIDTH_1}, 2}, math.MaxUint8},
+		{framingFactory{&LengthField{Width: LENGTH_WIDTH_1, IncludesHead: true}, 2}, math.MaxUint8 - 2},
+		{framingFactory{nil, 3}, math.MaxUint16},
+		{framingFactory{&LengthField{Width: LENGTH_WIDTH_2, IncludesHead: true}, 3}, math.MaxUint16 - 3},
+		{framingFactory{&LengthField{Width: LENGTH_WIDTH_VARINT}, 2}, math.MaxInt32},
+	}
+	for i, c := range cases {
+		p := c.f.NewPacket().(*BasePacket)
+		p.WriteBytes(make([]byte, c.f.headLen))
+		if err := p.SetBodyLen(c.maxBody); nil != err {
+			t.Fatalf("case %d: %s", i, err.Error())
+		}
+		head := append([]byte(nil), p.Data...)
+		if nil == p.SetBodyLen(c.maxBody+1) && c.maxBody < math.MaxInt32 {
+			t.Fatalf("case %d: body length %d overflows silently", i, c.maxBody+1)
+		}
+		if string(head) != string(p.Data) {
+			t.Fatalf("case %d: head is modified on overflow", i)
+		}
+		if nil == p.SetBodyLen(-1) {
+			t.Fatalf("case %d: negative body length is accepted", i)
+		}
+	}
+}
+
+func TestLengthFieldValidate(t *testing.T) {
+	invalid := []*BasePacket{
+		{HeadLen: 2, BodyLenIndex: 1},
+		{HeadLen: 4, BodyLenIndex: 1, LengthField: &LengthField{Width: LENGTH_WIDTH_4}},
+		{HeadLen: 3, BodyLenIndex: 1, LengthField: &LengthField{Width: LENGTH_WIDTH_VARINT}},
+		{HeadLen: 4, BodyLenIndex: 1, LengthField: &LengthField{Width: 3}},
+		{HeadLen: 4, BodyLenIndex: -1},
+	}
+	for i, p := range invalid {
+		if nil == p.Validate() {
+			t.Fatalf("case %d: invalid length field is accepted", i)
+		}
+	}
+	s := NewTcpServer("127.0.0.1:0", NewChannelProcessorWithLen(1), framingFactory{&LengthField{Width: LENGTH_WIDTH_4}, 4})
+	if s.Start() {
+		s.stop()
+		t.Fatal("server starts with an invalid length field")
+	}
+}
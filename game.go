@@ -1,7 +1,14 @@
 package solidnet
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
 
 	logger "github.com/idakun/tinylog"
 )
@@ -13,11 +20,60 @@ func Run(game IGame) {
 	}
 }
 
+// 程序入口，收到SIGTERM/SIGINT信号后优雅关闭，timeout为关闭的最长等待时间
+func RunWithSignal(game IGracefulGame, timeout time.Duration) {
+	if !game.Init() {
+		return
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+
+	done := make(chan struct{})
+	go func() {
+		game.Run()
+		close(done)
+	}()
+
+	select {
+	case sig := <-sigs:
+		logger.Debug("receive signal[%v], shutdown...", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := game.Shutdown(ctx); nil != err {
+			logger.Error("game.Shutdown() failed, error[%s]", err.Error())
+		}
+	case <-done:
+	}
+}
+
 type IGame interface {
 	Init() bool
 	Run()
 }
 
+// 支持优雅关闭的游戏
+type IGracefulGame interface {
+	IGame
+	Shutdown(context.Context) error
+}
+
+// 网络服务，TcpServer和WsServer都实现了该接口
+type IServer interface {
+	Start() bool
+	Shutdown(context.Context) error
+}
+
+// 处理消息时发生panic的信息
+type PanicInfo struct {
+	Error  interface{}
+	Stack  []byte
+	Type   int32   // 消息类型，MESSAGE_TYPE_*
+	Client IClient // 定时器消息为nil
+	Cmd    int32   // 网络消息的命令字，未设置CmdExtractor时为-1
+}
+
 type Game struct {
 	name   string
 	logDir string
@@ -26,49 +82,314 @@ type Game struct {
 	processor IProcessor
 	handler   IHandler
 	factory   IPacketFactory
+	servers   []IServer
+	tlsConfig *TlsConfig
+	auth      *Auth
+	index     *clientIndex // 所有服务共用的客户端索引
+	wsAddr    string
+	wsPath    string
+	udpAddr   string
+
+	options      *Options
+	clock        IClock
+	panicHook    func(*PanicInfo)
+	kickOnPanic  bool
+	cmdExtractor CmdExtractor
+	done         chan struct{} // 逻辑协程已经退出
 }
 
-func NewGame(addr string, name string, logDir string, h IHandler, f IPacketFactory) *Game {
+// 创建Game，opts用于调整客户端数量、队列长度、超时和日志等配置，
+// 可以用WithOptions使用LoadOptions从配置文件加载的配置。
+// 队列长度和等待时间与默认配置相同时使用全局处理器，否则按配置创建处理器，
+// 并把TimerMsgprocessor指向该处理器，NewGame之后用NewTimer创建的定时器也投递到这里
+func NewGame(addr string, name string, logDir string, h IHandler, f IPacketFactory, opts ...Option) *Game {
 	game := new(Game)
+	game.options = NewOptions(opts...)
 	game.addr = addr
 	game.name = name
 	game.logDir = logDir
-	game.processor = GetProcessor()
+	if game.options.channelLen() == defaultOptions.channelLen() && game.options.sendTimeout() == defaultOptions.sendTimeout() {
+		game.processor = GetProcessor()
+	} else {
+		game.processor = NewChannelProcessorWithOptions(game.options)
+		TimerMsgprocessor = game.processor
+	}
 	game.handler = h
 	game.factory = f
+	game.clock = RealClock
+	game.index = newClientIndex()
+	game.done = make(chan struct{})
 	return game
 }
 
+// 使用指定的处理器代替默认的全局处理器，一个进程中运行多个Game时，
+// 每个Game使用各自的处理器，必须在Init之前调用。
+// TimerMsgprocessor指向原来的处理器时改为指向p，NewTimer创建的定时器不会投递到无人处理的队列
+func (g *Game) SetProcessor(p IProcessor) {
+	if TimerMsgprocessor == g.processor {
+		TimerMsgprocessor = p
+	}
+	g.processor = p
+}
+
+func (g *Game) Processor() IProcessor {
+	return g.processor
+}
+
+func (g *Game) Options() *Options {
+	return g.options
+}
+
+// 使用指定的时钟，测试时可以使用FakeClock，必须在Init之前调用
+func (g *Game) SetClock(clock IClock) {
+	g.clock = clock
+}
+
+// 创建定时器，超时消息投递到该Game的处理器
+func (g *Game) NewTimer(id int32, h ITimerHandler) *Timer {
+	return NewTimerWithClock(id, h, g.processor, g.clock)
+}
+
+// 创建按墙上时间触发的调度器，超时消息投递到该Game的处理器
+func (g *Game) NewScheduler(loc *time.Location) *Scheduler {
+	return NewSchedulerWithClock(g.processor, loc, g.clock)
+}
+
+// 启用tls，必须在Init之前调用
+func (g *Game) EnableTls(config *TlsConfig) {
+	g.tlsConfig = config
+}
+
+// 在tcp服务之外同时开启websocket服务，启用tls时为wss，必须在Init之前调用
+func (g *Game) EnableWebSocket(addr string, path string) {
+	g.wsAddr = addr
+	g.wsPath = path
+}
+
+// 在tcp服务之外同时开启可靠udp服务，必须在Init之前调用
+func (g *Game) EnableUdp(addr string) {
+	g.udpAddr = addr
+}
+
+// 启用登录认证阶段，所有服务的客户端都使用该配置，必须在Init之前调用
+func (g *Game) SetAuth(a *Auth) {
+	g.auth = a
+}
+
+// 设置重复登录的处理，所有服务的客户端共用一个用户id索引，必须在Init之前调用
+func (g *Game) SetDuplicateLogin(d *DuplicateLogin) {
+	g.index.DuplicateLogin = d
+}
+
+// 按连接id查找客户端，包括所有服务的客户端，不存在时返回nil
+func (g *Game) GetClient(id uint64) *TcpClient {
+	return g.index.GetClient(id)
+}
+
+// 按用户id查找客户端，包括所有服务的客户端，不存在时返回nil
+func (g *Game) GetClientByUserId(userId int64) *TcpClient {
+	return g.index.GetClientByUserId(userId)
+}
+
+// 设置处理消息发生panic时的回调，可以把崩溃信息上报到自己的系统
+func (g *Game) SetPanicHook(h func(*PanicInfo)) {
+	g.panicHook = h
+}
+
+// 设置处理网络消息发生panic时，是否断开该客户端的连接
+func (g *Game) SetKickOnPanic(kick bool) {
+	g.kickOnPanic = kick
+}
+
+// 设置从数据包中取出命令字的方法，用于panic时记录命令字
+func (g *Game) SetCmdExtractor(e CmdExtractor) {
+	g.cmdExtractor = e
+}
+
 func (g *Game) Run() {
+	defer close(g.done)
+
+	mp, ok := g.processor.(IMultiProcessor)
+	if !ok {
+		g.loop(g.processor.Epoll)
+		return
+	}
+
+	// 多事件循环，每个事件循环一个逻辑协程
+	var wg sync.WaitGroup
+	for i := 0; i < mp.Loops(); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g.loop(func() IMessage {
+				return mp.EpollLoop(i)
+			})
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (g *Game) loop(epoll func() IMessage) {
 	// 处理消息
 	for {
-		message := g.processor.Epoll()
-		switch message.(type) {
-		case *TimerMessage:
+		message := epoll()
+		if _, ok := message.(*quitMessage); ok {
+			return
+		}
+		g.handle(message)
+	}
+}
+
+// 处理单条消息，业务层的panic只影响当前消息
+func (g *Game) handle(message IMessage) {
+	defer func() {
+		if err := recover(); nil != err {
+			g.recoverPanic(err, message)
+		}
+	}()
+
+	switch m := message.(type) {
+	case *TimerMessage:
+		// 定时器已经停止或者重新启动，丢弃之前的超时消息
+		if m.IsValid() {
 			g.handler.HandleTimer(message)
-		case *NetMessage:
-			g.handler.HandleNet(message)
-		case *StateMessage:
-			g.handler.HandleState(message)
-		default:
-			logger.Error("type of message is error!")
 		}
+	case *NetMessage:
+		g.handler.HandleNet(message)
+	case *StateMessage:
+		g.handler.HandleState(message)
+	case *TaskMessage:
+		m.fn()
+	default:
+		logger.Error("type of message is error!")
 	}
 }
 
+func (g *Game) recoverPanic(err interface{}, message IMessage) {
+	info := &PanicInfo{Error: err, Stack: debug.Stack(), Cmd: -1}
+	switch m := message.(type) {
+	case *TimerMessage:
+		info.Type = MESSAGE_TYPE_TIMER
+	case *NetMessage:
+		info.Type = MESSAGE_TYPE_NET
+		info.Client = m.client
+		if nil != g.cmdExtractor {
+			info.Cmd = g.extractCmd(m.packet)
+		}
+	case *StateMessage:
+		info.Type = MESSAGE_TYPE_STATE
+		info.Client = m.client
+	case *TaskMessage:
+		info.Type = MESSAGE_TYPE_TASK
+	}
+	logger.Error("panic[%v], type[%d], client[%s], cmd[%x]\n%s", err, info.Type, clientAddr(info.Client), info.Cmd, info.Stack)
+
+	if g.kickOnPanic && MESSAGE_TYPE_NET == info.Type && nil != info.Client {
+		CloseClient(info.Client)
+	}
+	if nil != g.panicHook {
+		g.panicHook(info)
+	}
+}
+
+// 取出命令字，数据包本身有问题时也不能再次panic
+func (g *Game) extractCmd(data []byte) (cmd int32) {
+	defer func() {
+		if nil != recover() {
+			cmd = -1
+		}
+	}()
+	return packetCmd(g.factory, g.cmdExtractor, data)
+}
+
 func (g *Game) Init() bool {
+	if err := g.options.Validate(); nil != err {
+		fmt.Printf("invalid options: %s", err.Error())
+		return false
+	}
+	if p, ok := g.processor.(IValidProcessor); ok {
+		if err := p.Validate(); nil != err {
+			fmt.Printf("invalid processor: %s", err.Error())
+			return false
+		}
+	}
+
 	// 初始化日志
-	result := logger.Init(g.name, g.logDir, 30*1024*1024, 10, logger.DEBUG_LEVEL, false, logger.PUT_CONSOLE|logger.WRITE_FILE)
+	o := g.options
+	logDir := g.logDir
+	if "" != o.Log.Dir {
+		logDir = o.Log.Dir
+	}
+	result := logger.Init(g.name, logDir, o.Log.FileSize, o.Log.FileNum, o.logLevel(), o.Log.Async, o.logFlags())
 	if !result {
 		fmt.Print("logger.Init() failed!!!")
 		return false
 	}
 
 	// 开启tcp服务
-	s := NewTcpServer(g.addr, g.processor, g.factory)
+	s := NewTcpServer(g.addr, g.processor, g.factory, WithOptions(o))
+	if nil != g.tlsConfig {
+		s = NewTlsServer(g.addr, g.tlsConfig, g.processor, g.factory, WithOptions(o))
+	}
+	s.Clock = g.clock
+	s.Auth = g.auth
+	s.clientIndex = g.index
 	if !s.Start() {
 		logger.Error("TcpServer start failed.")
 		return false
 	}
+	g.servers = append(g.servers, s)
+
+	// 开启websocket服务
+	if "" != g.wsAddr {
+		ws := NewWsServer(g.wsAddr, g.wsPath, g.processor, g.factory, WithOptions(o))
+		if nil != g.tlsConfig {
+			ws = NewWssServer(g.wsAddr, g.wsPath, g.tlsConfig, g.processor, g.factory, WithOptions(o))
+		}
+		ws.Clock = g.clock
+		ws.Auth = g.auth
+		ws.clientIndex = g.index
+		if !ws.Start() {
+			logger.Error("WsServer start failed.")
+			return false
+		}
+		g.servers = append(g.servers, ws)
+	}
+
+	// 开启可靠udp服务
+	if "" != g.udpAddr {
+		us := NewUdpServer(g.udpAddr, g.processor, g.factory, WithOptions(o))
+		us.Clock = g.clock
+		us.Auth = g.auth
+		us.clientIndex = g.index
+		if !us.Start() {
+			logger.Error("UdpServer start failed.")
+			return false
+		}
+		g.servers = append(g.servers, us)
+	}
 	return true
 }
+
+// 优雅关闭：先关闭所有网络服务，所有客户端的断线通知投递完毕后，
+// 逻辑协程处理完队列中剩余的消息（包括已经触发的定时器消息）再退出。
+// 某个服务关闭失败时仍然关闭其他服务并通知逻辑协程退出，返回第一个错误
+func (g *Game) Shutdown(ctx context.Context) error {
+	var first error
+	for _, s := range g.servers {
+		if err := s.Shutdown(ctx); nil != err && nil == first {
+			first = err
+		}
+	}
+
+	// 队列已满时投递退出消息会一直等待，由ctx控制等待时间
+	go g.processor.Dispatch(&quitMessage{})
+	select {
+	case <-g.done:
+	case <-ctx.Done():
+		if nil == first {
+			first = ctx.Err()
+		}
+	}
+	return first
+}
@@ -0,0 +1,104 @@
+package solidnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	err      error
+	shutdown bool
+}
+
+func (s *testServer) Start() bool {
+	return true
+}
+
+func (s *testServer) Shutdown(ctx context.Context) error {
+	s.shutdown = true
+	return s.err
+}
+
+// 使用独立处理器的Game，测试结束后恢复全局的TimerMsgprocessor
+func newTestGame(t *testing.T, h IHandler) *Game {
+	restoreTimerProcessor(t)
+	g := NewGame(":0", "test", "", h, testFactory{})
+	g.SetProcessor(NewChannelProcessorWithLen(100))
+	return g
+}
+
+func TestGameShutdownServerError(t *testing.T) {
+	g := newTestGame(t, nil)
+	errDrain := errors.New("drain timeout")
+	first := &testServer{err: errDrain}
+	second := &testServer{}
+	g.servers = []IServer{first, second}
+	go g.Run()
+
+	// 第一个服务关闭失败，其他服务仍然关闭，逻辑协程仍然退出
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := g.Shutdown(ctx); errDrain != err {
+		t.Fatalf("got error %v, want %v", err, errDrain)
+	}
+	if !second.shutdown {
+		t.Fatal("server after the failed one is not shut down")
+	}
+	select {
+	case <-g.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logic loop does not exit")
+	}
+}
+
+func TestGamePanicRecovered(t *testing.T) {
+	r := NewRouter(testFactory{}, testCmd)
+	r.Register(7, func(p IPacket, c IClient) {
+		panic("boom")
+	})
+	handled := make(chan int32, 1)
+	r.Register(8, func(p IPacket, c IClient) {
+		handled <- testCmd(p)
+	})
+	g := newTestGame(t, r)
+	panics := make(chan *PanicInfo, 1)
+	g.SetPanicHook(func(info *PanicInfo) {
+		panics <- info
+	})
+	g.SetCmdExtractor(testCmd)
+	g.SetKickOnPanic(true)
+	go g.Run()
+	defer g.Shutdown(context.Background())
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := NewTcpClient(local, NewChannelProcessorWithLen(10), testFactory{})
+	defer c.stop()
+	g.processor.Dispatch(&NetMessage{testPacket(7, 0), c})
+	g.processor.Dispatch(&NetMessage{testPacket(8, 0), c})
+
+	// 报告panic的消息类型、客户端和命令字，并断开该客户端
+	select {
+	case info := <-panics:
+		if "boom" != info.Error || MESSAGE_TYPE_NET != info.Type || info.Client != IClient(c) || 7 != info.Cmd || 0 == len(info.Stack) {
+			t.Fatalf("got panic info %+v", info)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic is not reported")
+	}
+	if c.isRunning() {
+		t.Fatal("client is not closed after panic")
+	}
+	// 逻辑协程继续处理后面的消息
+	select {
+	case cmd := <-handled:
+		if 8 != cmd {
+			t.Fatalf("handled cmd %d, want 8", cmd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message after panic is not handled")
+	}
+}
@@ -0,0 +1,133 @@
+package solidnet
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/idakun/tinylog"
+)
+
+const (
+	IDLE_CHECK_INTERVAL = 1 // 检查空闲的间隔，秒
+)
+
+// 应用层心跳，ping和pong包由业务层使用自己的IPacketFactory构造。
+// 写空闲时发送Ping，收到对端的ping时回复Pong，ping和pong包都不投递到逻辑层，
+// 只用来刷新读空闲时间
+type Heartbeat struct {
+	Ping   func(f IPacketFactory) []byte // 构造ping包
+	Pong   func(f IPacketFactory) []byte // 构造pong包，为空时不回复
+	IsPing func(p IPacket) bool
+	IsPong func(p IPacket) bool
+}
+
+// 设置应用层心跳
+func (c *BaseClient) setHeartbeat(h *Heartbeat) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.heartbeat = h
+}
+
+func (c *BaseClient) getHeartbeat() *Heartbeat {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.heartbeat
+}
+
+func (c *BaseClient) touchRead() {
+	atomic.StoreInt64(&c.lastRead, c.clock.Now().UnixNano())
+}
+
+func (c *BaseClient) touchWrite() {
+	atomic.StoreInt64(&c.lastWrite, c.clock.Now().UnixNano())
+}
+
+// 处理心跳包，返回true表示是心跳包，不再投递到逻辑层
+func (c *BaseClient) handleHeartbeat(data []byte) bool {
+	h := c.getHeartbeat()
+	if nil == h {
+		return false
+	}
+	p := c.factory.NewPacket()
+	p.Refer(data)
+	if nil != h.IsPing && h.IsPing(p) {
+		if nil != h.Pong {
+			c.trySend(h.Pong(c.factory))
+		}
+		return true
+	}
+	if nil != h.IsPong && h.IsPong(p) {
+		return true
+	}
+	return false
+}
+
+// 不阻塞地放入output队列，队列已满时丢弃
+func (c *BaseClient) trySend(data []byte) {
+	select {
+	case c.output <- data:
+	default:
+	}
+}
+
+// 设置了空闲超时时启动空闲检查，每IDLE_CHECK_INTERVAL秒检查一次
+func (c *BaseClient) startIdleCheck() {
+	if c.options.ReadIdleTime <= 0 && c.options.WriteIdleTime <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.idleWheel = getTimingWheel(c.clock)
+	c.idleEntry = c.idleWheel.addFunc(c.checkIdle, time.Second*IDLE_CHECK_INTERVAL, true)
+}
+
+// 停止空闲检查，调用前必须加锁
+func (c *BaseClient) stopIdleCheck() {
+	if nil != c.idleEntry {
+		c.idleWheel.remove(c.idleEntry)
+		c.idleEntry = nil
+	}
+}
+
+// 空闲检查，在时间轮协程中调用，不能阻塞：读空闲超过ReadIdleTime时先通知STATE_IDLE再断开连接，
+// 写空闲超过WriteIdleTime时发送ping
+func (c *BaseClient) checkIdle() {
+	readIdle := time.Duration(c.options.ReadIdleTime) * time.Second
+	writeIdle := time.Duration(c.options.WriteIdleTime) * time.Second
+	now := c.clock.Now()
+	if readIdle > 0 && now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastRead))) >= readIdle {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if !c.isRunning() {
+			return
+		}
+		logger.Error("client[%s] read idle timeout, close it", c.remoteAddr)
+		c.stopIdleCheck()
+		c.notifyState(STATE_IDLE)
+		// 关闭连接可能阻塞，不在时间轮协程中进行
+		go c.closeWithReason(CLOSE_REASON_IDLE_TIMEOUT)
+		return
+	}
+	if writeIdle > 0 && now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastWrite))) >= writeIdle {
+		if h := c.getHeartbeat(); nil != h && nil != h.Ping {
+			c.trySend(h.Ping(c.factory))
+			// 从发送ping开始重新计算写空闲，避免每次检查都发送
+			c.touchWrite()
+		}
+	}
+}
+
+// 设置tcp keepalive，seconds大于0时设置探测间隔，小于0时关闭，等于0时使用系统默认值
+func setKeepAlive(conn net.Conn, seconds int) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok || 0 == seconds {
+		return
+	}
+	if seconds < 0 {
+		tcpConn.SetKeepAlive(false)
+		return
+	}
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(time.Duration(seconds) * time.Second)
+}
@@ -0,0 +1,77 @@
+package solidnet
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadIdleTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	p := NewChannelProcessorWithLen(100)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := newTcpClient(local, p, testFactory{}, clock, NewOptions(WithReadIdleTime(3)))
+	go c.Run()
+	defer c.stop()
+	if STATE_CONNECTED != waitState(t, p) {
+		t.Fatal("client is not connected")
+	}
+
+	clock.Advance(2 * time.Second)
+	select {
+	case m := <-p.messageChannel:
+		t.Fatalf("got %v before read idle timeout", m)
+	default:
+	}
+
+	// 空闲检查由时间轮驱动，超时后先通知STATE_IDLE，再带原因断开
+	clock.Advance(2 * time.Second)
+	if STATE_IDLE != waitState(t, p) {
+		t.Fatal("STATE_IDLE is not notified")
+	}
+	for {
+		m := waitMessage(p, 5*time.Second)
+		if nil == m {
+			t.Fatal("client is not closed")
+		}
+		if sm, ok := m.(*StateMessage); ok {
+			if STATE_CLOSED != sm.state || CLOSE_REASON_IDLE_TIMEOUT != sm.reason {
+				t.Fatalf("state[%d] reason[%d]", sm.state, sm.reason)
+			}
+			break
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nil != c.idleEntry {
+		t.Fatal("idle check is not stopped")
+	}
+}
+
+func TestWriteIdlePing(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	p := NewChannelProcessorWithLen(100)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := newTcpClient(local, p, testFactory{}, clock, NewOptions(WithWriteIdleTime(2)))
+	ping := testPacket(1, 0)
+	c.SetHeartbeat(&Heartbeat{Ping: func(IPacketFactory) []byte { return ping }})
+	go c.Run()
+	defer c.stop()
+	if STATE_CONNECTED != waitState(t, p) {
+		t.Fatal("client is not connected")
+	}
+
+	clock.Advance(2 * time.Second)
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, len(ping))
+	if _, err := io.ReadFull(remote, buf); nil != err {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ping, buf) {
+		t.Fatalf("got %v, want ping", buf)
+	}
+}
@@ -0,0 +1,34 @@
+package solidnet
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// 测试用的数据包：4字节包头，前2字节是小端的包体长度，后2字节是序号
+type testFactory struct{}
+
+func (testFactory) NewPacket() IPacket {
+	return &BasePacket{HeadLen: 4, BodyLenIndex: 0}
+}
+
+// 序号为i、包体长度为size的完整数据包，包体内容由序号决定
+func testPacket(i int, size int) []byte {
+	b := make([]byte, 4+size)
+	binary.LittleEndian.PutUint16(b, uint16(size))
+	binary.LittleEndian.PutUint16(b[2:], uint16(i))
+	for j := 0; j < size; j++ {
+		b[4+j] = byte(i + j)
+	}
+	return b
+}
+
+// 等待处理器中的下一条消息
+func waitMessage(p *ChannelProcessor, timeout time.Duration) IMessage {
+	select {
+	case m := <-p.messageChannel:
+		return m
+	case <-time.After(timeout):
+		return nil
+	}
+}
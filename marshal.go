@@ -0,0 +1,534 @@
+package solidnet
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// solidnet标签解析后的编码方式
+type marshalTag struct {
+	bigEndian bool
+	varint    bool
+	size      int32 // 小于0表示不定长
+	lenWidth  int   // LENGTH_WIDTH_*
+}
+
+func parseMarshalTag(tag string) (t marshalTag, skip bool, err error) {
+	t.size = -1
+	t.lenWidth = LENGTH_WIDTH_4
+	if "-" == tag {
+		return t, true, nil
+	}
+	for _, opt := range strings.Split(tag, ",") {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case "" == opt, "le" == opt:
+			t.bigEndian = false
+		case "be" == opt:
+			t.bigEndian = true
+		case "varint" == opt:
+			t.varint = true
+		case strings.HasPrefix(opt, "size="):
+			size, e := strconv.ParseInt(opt[len("size="):], 10, 32)
+			if nil != e || size < 0 {
+				return t, false, fmt.Errorf("invalid tag option %q", opt)
+			}
+			t.size = int32(size)
+		case strings.HasPrefix(opt, "len="):
+			switch opt[len("len="):] {
+			case "1":
+				t.lenWidth = LENGTH_WIDTH_1
+			case "2":
+				t.lenWidth = LENGTH_WIDTH_2
+			case "4":
+				t.lenWidth = LENGTH_WIDTH_4
+			case "varint":
+				t.lenWidth = LENGTH_WIDTH_VARINT
+			default:
+				return t, false, fmt.Errorf("invalid tag option %q", opt)
+			}
+		default:
+			return t, false, fmt.Errorf("invalid tag option %q", opt)
+		}
+	}
+	return t, false, nil
+}
+
+// 元素的编码方式：字节序和变长编码继承，定长和长度前缀只作用于切片本身
+func (t marshalTag) elem() marshalTag {
+	return marshalTag{bigEndian: t.bigEndian, varint: t.varint, size: -1, lenWidth: LENGTH_WIDTH_4}
+}
+
+// 剩余可读的字节数，用来在分配切片之前检查长度前缀
+type remainer interface {
+	remain() int32
+}
+
+func (p *BasePacket) remain() int32 {
+	return int32(len(p.Data)) - p.Index
+}
+
+/**********************结构体写入**********************/
+// 把结构体v按字段顺序追加到p中，v为结构体或者结构体指针，包头需要调用者写入。
+// 未导出的字段忽略，通过solidnet标签控制编码方式，多个选项用逗号分隔：
+//
+//	-          忽略该字段
+//	le, be     整数、浮点数和长度前缀的字节序，默认小端，作用于切片和数组的元素
+//	varint     整数使用变长编码（有符号数为zigzag编码）
+//	size=N     定长：字符串为N字节，不足时用'\0'填充；切片为N个元素，没有长度前缀
+//	len=W      长度前缀的宽度，W为1、2、4或varint，默认4
+//
+// 没有指定size的字符串和ReadString/WriteString的约定一致：长度前缀包含末尾的'\0'；
+// []byte和其他切片的长度前缀为字节数或元素个数，数组没有长度前缀，长度超过前缀能表示的范围时返回错误。
+// 指针前有1字节的标记，0表示空指针，1表示后面是指向的值，所以可以编码链表等递归的类型，
+// 但是不支持循环引用。
+// 支持bool、定长的整数和浮点数、字符串、切片、数组、结构体和指针，
+// int和uint的长度和平台有关，不支持。p需要实现IPacketEx，BasePacket已经实现
+func Marshal(packet IPacket, v interface{}) error {
+	p, ok := packet.(IPacketEx)
+	if !ok {
+		return fmt.Errorf("marshal: %T does not implement IPacketEx", packet)
+	}
+	rv := reflect.ValueOf(v)
+	for reflect.Ptr == rv.Kind() && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if reflect.Struct != rv.Kind() {
+		return fmt.Errorf("marshal: need struct, got %T", v)
+	}
+	return marshalStruct(p, rv, rv.Type().Name())
+}
+
+func marshalStruct(p IPacketEx, rv reflect.Value, path string) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if "" != field.PkgPath {
+			continue
+		}
+		t, skip, err := parseMarshalTag(field.Tag.Get("solidnet"))
+		if nil != err {
+			return fmt.Errorf("marshal %s.%s: %s", path, field.Name, err.Error())
+		}
+		if skip {
+			continue
+		}
+		if err := marshalValue(p, rv.Field(i), t, path+"."+field.Name); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func marshalValue(p IPacketEx, rv reflect.Value, t marshalTag, path string) error {
+	switch rv.Kind() {
+	case reflect.Bool:
+		p.WriteBool(rv.Bool())
+	case reflect.Int8:
+		p.WriteByte(byte(rv.Int()))
+	case reflect.Uint8:
+		p.WriteUint8(uint8(rv.Uint()))
+	case reflect.Int16, reflect.Int32, reflect.Int64:
+		marshalInt(p, rv, t)
+	case reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		marshalUint(p, rv, t)
+	case reflect.Float32:
+		if t.bigEndian {
+			p.WriteFloat32B(float32(rv.Float()))
+		} else {
+			p.WriteFloat32(float32(rv.Float()))
+		}
+	case reflect.Float64:
+		if t.bigEndian {
+			p.WriteFloat64B(rv.Float())
+		} else {
+			p.WriteFloat64(rv.Float())
+		}
+	case reflect.String:
+		if t.size >= 0 {
+			p.WriteFixedString(rv.String(), t.size)
+			return nil
+		}
+		// 和WriteString一样以'\0'结尾，长度包含'\0'
+		if err := writeMarshalLen(p, t, len(rv.String())+1, path); nil != err {
+			return err
+		}
+		p.WriteBytes([]byte(rv.String()))
+		p.WriteByte(0)
+	case reflect.Slice:
+		n := rv.Len()
+		if t.size >= 0 {
+			if reflect.Uint8 == rv.Type().Elem().Kind() {
+				// 定长的字节数组，不足时用0填充，超过时截断
+				buf := make([]byte, t.size)
+				copy(buf, rv.Bytes())
+				p.WriteBytes(buf)
+				return nil
+			}
+			if int32(n) != t.size {
+				return fmt.Errorf("marshal %s: slice length %d, want %d", path, n, t.size)
+			}
+		} else if err := writeMarshalLen(p, t, n, path); nil != err {
+			return err
+		}
+		if reflect.Uint8 == rv.Type().Elem().Kind() {
+			p.WriteBytes(rv.Bytes())
+			return nil
+		}
+		return marshalElems(p, rv, t, path)
+	case reflect.Array:
+		return marshalElems(p, rv, t, path)
+	case reflect.Struct:
+		return marshalStruct(p, rv, path)
+	case reflect.Ptr:
+		if rv.IsNil() {
+			p.WriteByte(0)
+			return nil
+		}
+		p.WriteByte(1)
+		return marshalValue(p, rv.Elem(), t, path)
+	default:
+		return fmt.Errorf("marshal %s: unsupported type %s", path, rv.Type())
+	}
+	return nil
+}
+
+func marshalElems(p IPacketEx, rv reflect.Value, t marshalTag, path string) error {
+	for i := 0; i < rv.Len(); i++ {
+		if err := marshalValue(p, rv.Index(i), t.elem(), path+"["+strconv.Itoa(i)+"]"); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func marshalInt(p IPacketEx, rv reflect.Value, t marshalTag) {
+	if t.varint {
+		p.WriteVarint(rv.Int())
+		return
+	}
+	switch rv.Kind() {
+	case reflect.Int16:
+		if t.bigEndian {
+			p.WriteInt16B(int16(rv.Int()))
+		} else {
+			p.WriteInt16(int16(rv.Int()))
+		}
+	case reflect.Int32:
+		if t.bigEndian {
+			p.WriteInt32B(int32(rv.Int()))
+		} else {
+			p.WriteInt32(int32(rv.Int()))
+		}
+	default:
+		if t.bigEndian {
+			p.WriteInt64B(rv.Int())
+		} else {
+			p.WriteInt64(rv.Int())
+		}
+	}
+}
+
+func marshalUint(p IPacketEx, rv reflect.Value, t marshalTag) {
+	if t.varint {
+		p.WriteUvarint(rv.Uint())
+		return
+	}
+	switch rv.Kind() {
+	case reflect.Uint16:
+		if t.bigEndian {
+			p.WriteUint16B(uint16(rv.Uint()))
+		} else {
+			p.WriteUint16(uint16(rv.Uint()))
+		}
+	case reflect.Uint32:
+		if t.bigEndian {
+			p.WriteUint32B(uint32(rv.Uint()))
+		} else {
+			p.WriteUint32(uint32(rv.Uint()))
+		}
+	default:
+		if t.bigEndian {
+			p.WriteUint64B(rv.Uint())
+		} else {
+			p.WriteUint64(rv.Uint())
+		}
+	}
+}
+
+// 写入长度前缀，长度超过前缀能表示的范围时返回错误，不写入
+func writeMarshalLen(p IPacketEx, t marshalTag, n int, path string) error {
+	max := int64(math.MaxUint32)
+	switch t.lenWidth {
+	case LENGTH_WIDTH_1:
+		max = math.MaxUint8
+	case LENGTH_WIDTH_2:
+		max = math.MaxUint16
+	case LENGTH_WIDTH_VARINT:
+		max = math.MaxInt32
+	}
+	if int64(n) > max {
+		return fmt.Errorf("marshal %s: length %d overflows the length prefix, max %d", path, n, max)
+	}
+
+	switch t.lenWidth {
+	case LENGTH_WIDTH_1:
+		p.WriteUint8(uint8(n))
+	case LENGTH_WIDTH_2:
+		if t.bigEndian {
+			p.WriteUint16B(uint16(n))
+		} else {
+			p.WriteUint16(uint16(n))
+		}
+	case LENGTH_WIDTH_VARINT:
+		p.WriteUvarint(uint64(n))
+	default:
+		if t.bigEndian {
+			p.WriteUint32B(uint32(n))
+		} else {
+			p.WriteUint32(uint32(n))
+		}
+	}
+	return nil
+}
+
+/**********************结构体读取**********************/
+// 从p的当前位置按字段顺序读取到结构体指针v中，数据不足时返回p.Err()，p需要实现IPacketEx
+func Unmarshal(packet IPacket, v interface{}) error {
+	p, ok := packet.(IPacketEx)
+	if !ok {
+		return fmt.Errorf("unmarshal: %T does not implement IPacketEx", packet)
+	}
+	rv := reflect.ValueOf(v)
+	if reflect.Ptr != rv.Kind() || rv.IsNil() {
+		return fmt.Errorf("unmarshal: need non-nil struct pointer, got %T", v)
+	}
+	rv = rv.Elem()
+	if reflect.Struct != rv.Kind() {
+		return fmt.Errorf("unmarshal: need struct pointer, got %T", v)
+	}
+	if err := unmarshalStruct(p, rv, rv.Type().Name()); nil != err {
+		return err
+	}
+	return p.Err()
+}
+
+func unmarshalStruct(p IPacketEx, rv reflect.Value, path string) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if "" != field.PkgPath {
+			continue
+		}
+		t, skip, err := parseMarshalTag(field.Tag.Get("solidnet"))
+		if nil != err {
+			return fmt.Errorf("unmarshal %s.%s: %s", path, field.Name, err.Error())
+		}
+		if skip {
+			continue
+		}
+		if err := unmarshalValue(p, rv.Field(i), t, path+"."+field.Name); nil != err {
+			return err
+		}
+		if nil != p.Err() {
+			// 数据不足，后面的字段不再读取
+			return p.Err()
+		}
+	}
+	return nil
+}
+
+func unmarshalValue(p IPacketEx, rv reflect.Value, t marshalTag, path string) error {
+	switch rv.Kind() {
+	case reflect.Bool:
+		rv.SetBool(p.ReadBool())
+	case reflect.Int8:
+		rv.SetInt(int64(int8(p.ReadByte())))
+	case reflect.Uint8:
+		rv.SetUint(uint64(p.ReadUint8()))
+	case reflect.Int16, reflect.Int32, reflect.Int64:
+		unmarshalInt(p, rv, t)
+	case reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		unmarshalUint(p, rv, t)
+	case reflect.Float32:
+		if t.bigEndian {
+			rv.SetFloat(float64(p.ReadFloat32B()))
+		} else {
+			rv.SetFloat(float64(p.ReadFloat32()))
+		}
+	case reflect.Float64:
+		if t.bigEndian {
+			rv.SetFloat(p.ReadFloat64B())
+		} else {
+			rv.SetFloat(p.ReadFloat64())
+		}
+	case reflect.String:
+		if t.size >= 0 {
+			rv.SetString(p.ReadFixedString(t.size))
+			return nil
+		}
+		n := readMarshalLen(p, t)
+		if n <= 0 {
+			return nil
+		}
+		// 和ReadString一样剔除末尾的'\0'
+		b := p.ReadBytes(n)
+		if len(b) > 0 && 0 == b[len(b)-1] {
+			b = b[:len(b)-1]
+		}
+		rv.SetString(string(b))
+	case reflect.Slice:
+		n := t.size
+		if n < 0 {
+			n = readMarshalLen(p, t)
+		}
+		if reflect.Uint8 == rv.Type().Elem().Kind() {
+			// 复制一份，不引用包中的数据
+			b := p.ReadBytes(n)
+			rv.SetBytes(append(make([]byte, 0, len(b)), b...))
+			return nil
+		}
+		if !checkMarshalLen(p, n) {
+			return nil
+		}
+		rv.Set(reflect.MakeSlice(rv.Type(), int(n), int(n)))
+		return unmarshalElems(p, rv, t, path)
+	case reflect.Array:
+		return unmarshalElems(p, rv, t, path)
+	case reflect.Struct:
+		return unmarshalStruct(p, rv, path)
+	case reflect.Ptr:
+		switch flag := p.ReadByte(); {
+		case nil != p.Err():
+		case 0 == flag:
+			rv.Set(reflect.Zero(rv.Type()))
+		case 1 == flag:
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			return unmarshalValue(p, rv.Elem(), t, path)
+		default:
+			return fmt.Errorf("unmarshal %s: invalid pointer flag %d", path, flag)
+		}
+	default:
+		return fmt.Errorf("unmarshal %s: unsupported type %s", path, rv.Type())
+	}
+	return nil
+}
+
+func unmarshalElems(p IPacketEx, rv reflect.Value, t marshalTag, path string) error {
+	for i := 0; i < rv.Len(); i++ {
+		if err := unmarshalValue(p, rv.Index(i), t.elem(), path+"["+strconv.Itoa(i)+"]"); nil != err {
+			return err
+		}
+		if nil != p.Err() {
+			return nil
+		}
+	}
+	return nil
+}
+
+func unmarshalInt(p IPacketEx, rv reflect.Value, t marshalTag) {
+	if t.varint {
+		rv.SetInt(p.ReadVarint())
+		return
+	}
+	switch rv.Kind() {
+	case reflect.Int16:
+		if t.bigEndian {
+			rv.SetInt(int64(p.ReadInt16B()))
+		} else {
+			rv.SetInt(int64(p.ReadInt16()))
+		}
+	case reflect.Int32:
+		if t.bigEndian {
+			rv.SetInt(int64(p.ReadInt32B()))
+		} else {
+			rv.SetInt(int64(p.ReadInt32()))
+		}
+	default:
+		if t.bigEndian {
+			rv.SetInt(p.ReadInt64B())
+		} else {
+			rv.SetInt(p.ReadInt64())
+		}
+	}
+}
+
+func unmarshalUint(p IPacketEx, rv reflect.Value, t marshalTag) {
+	if t.varint {
+		rv.SetUint(p.ReadUvarint())
+		return
+	}
+	switch rv.Kind() {
+	case reflect.Uint16:
+		if t.bigEndian {
+			rv.SetUint(uint64(p.ReadUint16B()))
+		} else {
+			rv.SetUint(uint64(p.ReadUint16()))
+		}
+	case reflect.Uint32:
+		if t.bigEndian {
+			rv.SetUint(uint64(p.ReadUint32B()))
+		} else {
+			rv.SetUint(uint64(p.ReadUint32()))
+		}
+	default:
+		if t.bigEndian {
+			rv.SetUint(p.ReadUint64B())
+		} else {
+			rv.SetUint(p.ReadUint64())
+		}
+	}
+}
+
+// 读取长度前缀，数据不足或者长度超过剩余的数据时返回0，错误记录在p.Err()
+func readMarshalLen(p IPacketEx, t marshalTag) int32 {
+	var n uint64
+	switch t.lenWidth {
+	case LENGTH_WIDTH_1:
+		n = uint64(p.ReadUint8())
+	case LENGTH_WIDTH_2:
+		if t.bigEndian {
+			n = uint64(p.ReadUint16B())
+		} else {
+			n = uint64(p.ReadUint16())
+		}
+	case LENGTH_WIDTH_VARINT:
+		n = p.ReadUvarint()
+	default:
+		if t.bigEndian {
+			n = uint64(p.ReadUint32B())
+		} else {
+			n = uint64(p.ReadUint32())
+		}
+	}
+	if nil != p.Err() {
+		return 0
+	}
+	if n > math.MaxInt32 {
+		n = math.MaxInt32
+	}
+	if !checkMarshalLen(p, int32(n)) {
+		return 0
+	}
+	return int32(n)
+}
+
+// 长度前缀超过剩余的数据时不再分配内存，避免错误包或者攻击包造成大量的内存分配。
+// 按数据不足处理，跳过剩余的数据并记录到p.Err()，返回false
+func checkMarshalLen(p IPacketEx, length int32) bool {
+	remain := p.GetTotalLen()
+	if r, ok := p.(remainer); ok {
+		remain = r.remain()
+	}
+	if length > remain {
+		// 读取失败，不会分配内存
+		p.ReadBytes(length)
+		return false
+	}
+	return true
+}
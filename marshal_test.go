@@ -0,0 +1,273 @@
+package solidnet
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type marshalInner struct {
+	X int16 `solidnet:"be"`
+	S string
+}
+
+// 覆盖所有支持的类型和标签选项
+type marshalAll struct {
+	B       bool
+	I8      int8
+	U8      uint8
+	I16     int16
+	I16B    int16 `solidnet:"be"`
+	I32     int32
+	I32B    int32 `solidnet:"be"`
+	I32V    int32 `solidnet:"varint"`
+	I64     int64 `solidnet:"le"`
+	I64B    int64 `solidnet:"be"`
+	I64V    int64 `solidnet:"varint"`
+	U16     uint16
+	U16B    uint16 `solidnet:"be"`
+	U32     uint32
+	U32B    uint32 `solidnet:"be"`
+	U32V    uint32 `solidnet:"varint"`
+	U64     uint64
+	U64B    uint64 `solidnet:"be"`
+	U64V    uint64 `solidnet:"varint"`
+	F32     float32
+	F32B    float32 `solidnet:"be"`
+	F64     float64
+	F64B    float64 `solidnet:"be"`
+	Str     string
+	Str1    string `solidnet:"len=1"`
+	Str2B   string `solidnet:"len=2,be"`
+	StrV    string `solidnet:"len=varint"`
+	Fixed   string `solidnet:"size=8"`
+	Raw     []byte
+	Raw1    []byte         `solidnet:"len=1"`
+	RawFix  []byte         `solidnet:"size=4"`
+	Arr     [3]int32       `solidnet:"be"`
+	List    []marshalInner `solidnet:"len=2"`
+	Ids     []int64        `solidnet:"len=varint,varint"`
+	Shorts  []uint16       `solidnet:"size=2,be"`
+	Names   []string       `solidnet:"len=1"`
+	Matrix  [2][2]uint8
+	Ptr     *marshalInner
+	NilPtr  *marshalInner
+	Nest    marshalInner
+	Skip    int32 `solidnet:"-"`
+	private int
+}
+
+func newMarshalPacket() IPacketEx {
+	p := testFactory{}.NewPacket().(IPacketEx)
+	p.WriteBytes(make([]byte, p.GetHeadLen()))
+	return p
+}
+
+func referMarshalPacket(data []byte) IPacketEx {
+	p := testFactory{}.NewPacket().(IPacketEx)
+	p.Refer(data)
+	return p
+}
+
+func marshalAllValue() marshalAll {
+	return marshalAll{
+		B: true, I8: -3, U8: 200,
+		I16: -1234, I16B: -4321, I32: 99999, I32B: -99999, I32V: -300,
+		I64: 1 << 40, I64B: -(1 << 50), I64V: -77777777,
+		U16: 0xfffe, U16B: 0x0102, U32: 0xfffffffe, U32B: 0x01020304, U32V: 300,
+		U64: 1 << 63, U64B: 0x0102030405060708, U64V: 1 << 60,
+		F32: 1.5, F32B: -2.5, F64: -2.25, F64B: 1e100,
+		Str: "hello", Str1: "a", Str2B: "bc", StrV: strings.Repeat("v", 200), Fixed: "abc",
+		Raw: []byte{1, 2, 3}, Raw1: []byte{}, RawFix: []byte{9},
+		Arr:    [3]int32{1, -2, 3},
+		List:   []marshalInner{{1, "a"}, {2, ""}},
+		Ids:    []int64{-1, 1000000},
+		Shorts: []uint16{7, 8},
+		Names:  []string{"x", "yz"},
+		Matrix: [2][2]uint8{{1, 2}, {3, 4}},
+		Ptr:    &marshalInner{5, "p"},
+		Nest:   marshalInner{-7, "nest"},
+		Skip:   42,
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	in := marshalAllValue()
+	p := newMarshalPacket()
+	if err := Marshal(p, &in); nil != err {
+		t.Fatal(err)
+	}
+	var out marshalAll
+	if err := Unmarshal(referMarshalPacket(p.GetData()), &out); nil != err {
+		t.Fatal(err)
+	}
+
+	// 定长的字节数组用0填充，忽略的字段不编码
+	in.RawFix = []byte{9, 0, 0, 0}
+	in.Skip = 0
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("\n got %+v\nwant %+v", out, in)
+	}
+}
+
+func TestMarshalStringCompat(t *testing.T) {
+	// 默认的字符串编码和WriteString/ReadString一致
+	p := newMarshalPacket()
+	if err := Marshal(p, struct{ S string }{"xyz"}); nil != err {
+		t.Fatal(err)
+	}
+	if s := referMarshalPacket(p.GetData()).ReadString(); "xyz" != s {
+		t.Fatalf("ReadString got %q", s)
+	}
+}
+
+type marshalNode struct {
+	Value int32
+	Next  *marshalNode
+}
+
+func TestMarshalRecursivePointer(t *testing.T) {
+	// 空指针只写入标记，递归的类型不会无限展开
+	in := &marshalNode{1, &marshalNode{2, &marshalNode{3, nil}}}
+	p := newMarshalPacket()
+	if err := Marshal(p, in); nil != err {
+		t.Fatal(err)
+	}
+	if want := 4 + 3*(4+1); want != len(p.GetData()) {
+		t.Fatalf("encoded %d bytes, want %d", len(p.GetData()), want)
+	}
+	var out marshalNode
+	if err := Unmarshal(referMarshalPacket(p.GetData()), &out); nil != err {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, &out) {
+		t.Fatalf("got %+v", out)
+	}
+
+	// 标记只能是0或1
+	data := append([]byte(nil), p.GetData()...)
+	data[4+4] = 2
+	if nil == Unmarshal(referMarshalPacket(data), &out) {
+		t.Fatal("invalid pointer flag is accepted")
+	}
+}
+
+func TestMarshalLenOverflow(t *testing.T) {
+	cases := []interface{}{
+		struct {
+			S string `solidnet:"len=1"`
+		}{strings.Repeat("s", 255)},
+		struct {
+			B []byte `solidnet:"len=1"`
+		}{make([]byte, 256)},
+		struct {
+			L []int32 `solidnet:"len=1"`
+		}{make([]int32, 256)},
+		struct {
+			S string `solidnet:"len=2,be"`
+		}{strings.Repeat("s", 65535)},
+	}
+	for i, v := range cases {
+		if nil == Marshal(newMarshalPacket(), v) {
+			t.Fatalf("case %d: length overflow is accepted", i)
+		}
+	}
+
+	// 刚好能表示的长度
+	ok := struct {
+		S string `solidnet:"len=1"`
+		B []byte `solidnet:"len=2"`
+	}{strings.Repeat("s", 254), make([]byte, 65535)}
+	if err := Marshal(newMarshalPacket(), ok); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	in := marshalAllValue()
+	p := newMarshalPacket()
+	if err := Marshal(p, &in); nil != err {
+		t.Fatal(err)
+	}
+	data := p.GetData()
+	for n := 4; n < len(data); n++ {
+		q := referMarshalPacket(data[:n])
+		var out marshalAll
+		err := Unmarshal(q, &out)
+		if nil == err || err != q.Err() {
+			t.Fatalf("truncated at %d: got error[%v], packet error[%v]", n, err, q.Err())
+		}
+	}
+}
+
+func TestUnmarshalHugeLength(t *testing.T) {
+	type huge struct {
+		L []int64
+		B []byte  `solidnet:"len=varint"`
+		S string  `solidnet:"len=4,be"`
+		M []int64 `solidnet:"size=100000000"`
+	}
+	prefixes := [][]byte{
+		{0, 0, 0, 0x40},                         // L的元素个数为1<<30
+		{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 7}, // B的长度超过int32
+		{0, 0, 0, 0, 0, 0x7f, 0xff, 0xff, 0xff}, // S的长度为1<<31-1
+		{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, // M为定长1e8个元素
+	}
+	for i, prefix := range prefixes {
+		data := append(make([]byte, 4), prefix...)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		q := referMarshalPacket(data)
+		var out huge
+		err := Unmarshal(q, &out)
+		runtime.ReadMemStats(&after)
+		if nil == err || err != q.Err() {
+			t.Fatalf("case %d: got error[%v], packet error[%v]", i, err, q.Err())
+		}
+		// 不按声明的长度分配内存
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Fatalf("case %d: allocated %d bytes", i, allocated)
+		}
+	}
+}
+
+func TestMarshalUnsupported(t *testing.T) {
+	if nil == Marshal(newMarshalPacket(), struct{ N int }{1}) {
+		t.Fatal("int is accepted")
+	}
+	if nil == Marshal(newMarshalPacket(), struct {
+		N int32 `solidnet:"len=3"`
+	}{1}) {
+		t.Fatal("invalid tag is accepted")
+	}
+	if nil == Unmarshal(newMarshalPacket(), marshalNode{}) {
+		t.Fatal("unmarshal into a non-pointer is accepted")
+	}
+
+	// 只实现IPacket的数据包返回错误，不会panic
+	legacy := legacyPacket{newMarshalPacket()}
+	if nil == Marshal(legacy, &marshalNode{}) {
+		t.Fatal("marshal into a packet without IPacketEx is accepted")
+	}
+	if nil == Unmarshal(legacy, &marshalNode{}) {
+		t.Fatal("unmarshal from a packet without IPacketEx is accepted")
+	}
+}
+
+func TestMarshalIPacket(t *testing.T) {
+	// NetMessage和Router中拿到的IPacket可以直接使用
+	var p IPacket = newMarshalPacket()
+	in := marshalInner{X: -2, S: "ipacket"}
+	if err := Marshal(p, in); nil != err {
+		t.Fatal(err)
+	}
+	var q IPacket = referMarshalPacket(p.GetData())
+	var out marshalInner
+	if err := Unmarshal(q, &out); nil != err {
+		t.Fatal(err)
+	}
+	if in != out {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+}
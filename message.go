@@ -23,6 +23,13 @@ func (m *NetMessage) Args() interface{} {
 type TimerMessage struct {
 	id      int32
 	handler ITimerHandler
+	timer   *Timer
+	seq     uint64 // 触发时定时器的序号
+}
+
+// 定时器在触发之后没有被停止或者重新启动
+func (m *TimerMessage) IsValid() bool {
+	return nil == m.timer || m.timer.isValid(m.seq)
 }
 
 func (m *TimerMessage) Data() interface{} {
@@ -37,6 +44,7 @@ func (m *TimerMessage) Args() interface{} {
 type StateMessage struct {
 	state  int32
 	client IClient
+	reason int32 // 断线原因，只有STATE_CLOSED时有意义
 }
 
 func (m *StateMessage) Data() interface{} {
@@ -46,3 +54,50 @@ func (m *StateMessage) Data() interface{} {
 func (m *StateMessage) Args() interface{} {
 	return m.client
 }
+
+// 断线原因，CLOSE_REASON_*
+func (m *StateMessage) Reason() int32 {
+	return m.reason
+}
+
+// 状态消息的断线原因，其他消息返回CLOSE_REASON_NONE
+func stateReason(message IMessage) int32 {
+	if m, ok := message.(*StateMessage); ok {
+		return m.reason
+	}
+	return CLOSE_REASON_NONE
+}
+
+// 任务消息，在逻辑协程中执行fn，使用ShardedProcessor时按key投递到固定的事件循环
+type TaskMessage struct {
+	key uint64
+	fn  func()
+}
+
+func NewTaskMessage(key uint64, fn func()) *TaskMessage {
+	return &TaskMessage{key, fn}
+}
+
+func (m *TaskMessage) Data() interface{} {
+	return m.fn
+}
+
+func (m *TaskMessage) Args() interface{} {
+	return m.key
+}
+
+func (m *TaskMessage) ShardKey() uint64 {
+	return m.key
+}
+
+// 退出消息，Game优雅关闭时投递，逻辑协程处理到该消息时退出
+type quitMessage struct {
+}
+
+func (m *quitMessage) Data() interface{} {
+	return nil
+}
+
+func (m *quitMessage) Args() interface{} {
+	return nil
+}
@@ -0,0 +1,213 @@
+package solidnet
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+
+	logger "github.com/idakun/tinylog"
+)
+
+// 消息类型
+const (
+	MESSAGE_TYPE_TIMER = 0 // 定时器消息
+	MESSAGE_TYPE_NET   = 1 // 网络消息
+	MESSAGE_TYPE_STATE = 2 // 状态消息
+	MESSAGE_TYPE_TASK  = 3 // 任务消息，不经过IHandler
+)
+
+// 中间件的上下文
+type MessageContext struct {
+	Message IMessage
+	Type    int32
+	Client  IClient // 定时器消息为nil
+	Data    []byte  // 网络消息的原始数据
+	State   int32   // 状态消息的状态
+	Reason  int32   // 状态消息的断线原因，只有STATE_CLOSED时有意义
+}
+
+// 中间件，调用next继续后续的中间件和处理函数，不调用next则中断处理
+type Middleware func(ctx *MessageContext, next func())
+
+// 中间件链，包装一个IHandler，中间件按注册的顺序执行，本身也实现 IHandler
+type HandlerChain struct {
+	handler     IHandler
+	middlewares []Middleware
+}
+
+func NewHandlerChain(h IHandler, m ...Middleware) *HandlerChain {
+	c := new(HandlerChain)
+	c.handler = h
+	c.middlewares = m
+	return c
+}
+
+// 追加中间件，必须在Game.Run之前调用
+func (c *HandlerChain) Use(m ...Middleware) {
+	c.middlewares = append(c.middlewares, m...)
+}
+
+func (c *HandlerChain) handle(ctx *MessageContext, final func(IMessage)) {
+	var call func(int)
+	call = func(i int) {
+		if i == len(c.middlewares) {
+			final(ctx.Message)
+			return
+		}
+		c.middlewares[i](ctx, func() {
+			call(i + 1)
+		})
+	}
+	call(0)
+}
+
+// 实现 IHandler
+func (c *HandlerChain) HandleTimer(message IMessage) {
+	ctx := &MessageContext{Message: message, Type: MESSAGE_TYPE_TIMER}
+	c.handle(ctx, c.handler.HandleTimer)
+}
+
+func (c *HandlerChain) HandleNet(message IMessage) {
+	ctx := &MessageContext{Message: message, Type: MESSAGE_TYPE_NET}
+	ctx.Data, _ = (message.Data()).([]byte)
+	ctx.Client, _ = (message.Args()).(IClient)
+	c.handle(ctx, c.handler.HandleNet)
+}
+
+func (c *HandlerChain) HandleState(message IMessage) {
+	ctx := &MessageContext{Message: message, Type: MESSAGE_TYPE_STATE}
+	ctx.Client, _ = (message.Args()).(IClient)
+	ctx.State, _ = (message.Data()).(int32)
+	ctx.Reason = stateReason(message)
+	c.handle(ctx, c.handler.HandleState)
+}
+
+func clientAddr(c IClient) string {
+	if nil == c {
+		return ""
+	}
+	return c.RemoteAddr()
+}
+
+func packetCmd(f IPacketFactory, extractor CmdExtractor, data []byte) int32 {
+	p := f.NewPacket()
+	p.Refer(data)
+	return extractor(p)
+}
+
+/**********************常用中间件**********************/
+
+// 捕获处理函数中的panic，记录堆栈后继续处理后续消息
+func RecoverMiddleware() Middleware {
+	return func(ctx *MessageContext, next func()) {
+		defer func() {
+			if err := recover(); nil != err {
+				logger.Error("panic[%v], type[%d], client[%s]\n%s", err, ctx.Type, clientAddr(ctx.Client), debug.Stack())
+			}
+		}()
+		next()
+	}
+}
+
+// 记录每条消息
+func LogMiddleware() Middleware {
+	return func(ctx *MessageContext, next func()) {
+		logger.Debug("handle message, type[%d], client[%s], len[%d]", ctx.Type, clientAddr(ctx.Client), len(ctx.Data))
+		next()
+	}
+}
+
+// 处理时间超过slow时记录日志
+func TimingMiddleware(slow time.Duration) Middleware {
+	return TimingMiddlewareWithClock(slow, RealClock)
+}
+
+// 使用指定时钟计时的TimingMiddleware，测试时可以使用FakeClock
+func TimingMiddlewareWithClock(slow time.Duration, clock IClock) Middleware {
+	return func(ctx *MessageContext, next func()) {
+		begin := clock.Now()
+		next()
+		cost := clock.Now().Sub(begin)
+		if cost >= slow {
+			logger.Error("handle message too slow, type[%d], client[%s], cost[%v]", ctx.Type, clientAddr(ctx.Client), cost)
+		}
+	}
+}
+
+// 未登录的客户端只能发送allowed中的命令，其他命令直接丢弃
+func LoginCheckMiddleware(f IPacketFactory, extractor CmdExtractor, allowed ...int32) Middleware {
+	whitelist := make(map[int32]bool)
+	for _, cmd := range allowed {
+		whitelist[cmd] = true
+	}
+	return func(ctx *MessageContext, next func()) {
+		if MESSAGE_TYPE_NET == ctx.Type && !ctx.Client.GetLoginFlag() {
+			cmd := packetCmd(f, extractor, ctx.Data)
+			if !whitelist[cmd] {
+				logger.Error("client[%s] not login, drop cmd:%x", ctx.Client.RemoteAddr(), cmd)
+				return
+			}
+		}
+		next()
+	}
+}
+
+// 令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 按客户端和命令字限流，每秒rate个，最多突发burst个，超出的消息直接丢弃
+func RateLimitMiddleware(f IPacketFactory, extractor CmdExtractor, rate float64, burst int) Middleware {
+	return RateLimitMiddlewareWithClock(f, extractor, rate, burst, RealClock)
+}
+
+// 使用指定时钟补充令牌的RateLimitMiddleware，测试时可以使用FakeClock
+func RateLimitMiddlewareWithClock(f IPacketFactory, extractor CmdExtractor, rate float64, burst int, clock IClock) Middleware {
+	var mutex sync.Mutex
+	buckets := make(map[IClient]map[int32]*tokenBucket)
+
+	return func(ctx *MessageContext, next func()) {
+		switch ctx.Type {
+		case MESSAGE_TYPE_STATE:
+			// 断线后清理该客户端的令牌桶
+			if STATE_CLOSED == ctx.State {
+				mutex.Lock()
+				delete(buckets, ctx.Client)
+				mutex.Unlock()
+			}
+		case MESSAGE_TYPE_NET:
+			cmd := packetCmd(f, extractor, ctx.Data)
+			now := clock.Now()
+
+			mutex.Lock()
+			cmds, ok := buckets[ctx.Client]
+			if !ok {
+				cmds = make(map[int32]*tokenBucket)
+				buckets[ctx.Client] = cmds
+			}
+			b, ok := cmds[cmd]
+			if !ok {
+				b = &tokenBucket{tokens: float64(burst), last: now}
+				cmds[cmd] = b
+			}
+			b.tokens += now.Sub(b.last).Seconds() * rate
+			if b.tokens > float64(burst) {
+				b.tokens = float64(burst)
+			}
+			b.last = now
+			allow := b.tokens >= 1
+			if allow {
+				b.tokens--
+			}
+			mutex.Unlock()
+
+			if !allow {
+				logger.Error("client[%s] cmd:%x exceeds rate limit, drop it", ctx.Client.RemoteAddr(), cmd)
+				return
+			}
+		}
+		next()
+	}
+}
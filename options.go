@@ -0,0 +1,319 @@
+package solidnet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	logger "github.com/idakun/tinylog"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	OPTIONS_ENV_PREFIX = "SOLIDNET_" // 环境变量前缀，例如SOLIDNET_MAX_CLIENT_NUM、SOLIDNET_LOG_LEVEL
+)
+
+// 服务器配置，默认值和原来的常量一致。
+// 可以通过函数选项、配置文件(yaml/json/toml)和环境变量设置，环境变量优先于配置文件和函数选项
+type Options struct {
+	MaxClientNum     int        `json:"max_client_num" yaml:"max_client_num" toml:"max_client_num"`                // 最多客户端数量
+	MaxChannelLen    int        `json:"max_channel_len" yaml:"max_channel_len" toml:"max_channel_len"`             // 客户端收发队列和处理器队列的长度
+	MaxUserPacketLen int32      `json:"max_user_packet_len" yaml:"max_user_packet_len" toml:"max_user_packet_len"` // 业务层包体最大长度
+	SendTimeout      int        `json:"send_timeout" yaml:"send_timeout" toml:"send_timeout"`                      // 发送队列满时的等待时间，秒
+	LoginAuthTime    int        `json:"login_auth_time" yaml:"login_auth_time" toml:"login_auth_time"`             // 连接后必须在该时间内完成登录认证，秒
+	DispatchWaitTime int        `json:"dispatch_wait_time" yaml:"dispatch_wait_time" toml:"dispatch_wait_time"`    // 投递协程检查退出的间隔，秒
+	ReadIdleTime     int        `json:"read_idle_time" yaml:"read_idle_time" toml:"read_idle_time"`                // 超过该时间没有收到数据时断开连接，秒，0表示不检查
+	WriteIdleTime    int        `json:"write_idle_time" yaml:"write_idle_time" toml:"write_idle_time"`             // 超过该时间没有发送数据时发送心跳，秒，0表示不检查
+	KeepAlive        int        `json:"keep_alive" yaml:"keep_alive" toml:"keep_alive"`                            // tcp keepalive探测间隔，秒，0使用系统默认值，负数关闭
+	Log              LogOptions `json:"log" yaml:"log" toml:"log"`
+
+	envErr error // 环境变量格式错误，Validate时返回
+}
+
+// 日志配置
+type LogOptions struct {
+	Dir      string `json:"dir" yaml:"dir" toml:"dir"`                   // 日志目录，为空时使用NewGame的logDir
+	FileSize int    `json:"file_size" yaml:"file_size" toml:"file_size"` // 单个日志文件的最大字节数
+	FileNum  int    `json:"file_num" yaml:"file_num" toml:"file_num"`    // 最多保留的日志文件数量
+	Level    string `json:"level" yaml:"level" toml:"level"`             // debug、info、warn、error、fatal
+	Async    bool   `json:"async" yaml:"async" toml:"async"`             // 异步写日志
+	Console  bool   `json:"console" yaml:"console" toml:"console"`       // 输出到控制台
+	File     bool   `json:"file" yaml:"file" toml:"file"`                // 写入文件
+}
+
+// 函数选项
+type Option func(*Options)
+
+var logLevels = map[string]int{
+	"debug": logger.DEBUG_LEVEL,
+	"info":  logger.INFO_LEVEL,
+	"warn":  logger.WARN_LEVEL,
+	"error": logger.ERROR_LEVEL,
+	"fatal": logger.FATAL_LEVEL,
+}
+
+// 默认配置，只读
+var defaultOptions = NewOptions()
+
+// 创建配置，先填充默认值再依次应用选项，最后应用OPTIONS_ENV_PREFIX开头的环境变量。
+// 所有创建配置的地方都经过这里，环境变量格式错误时在Validate中返回
+func NewOptions(opts ...Option) *Options {
+	o := &Options{
+		MaxClientNum:     MAX_CLIENT_NUM,
+		MaxChannelLen:    MAX_CHANNEL_LEN,
+		MaxUserPacketLen: MAX_USER_PACKET_LEN,
+		SendTimeout:      MAX_SEND_TIMEOUT,
+		LoginAuthTime:    MAX_LOGIN_AUTH_TIME,
+		DispatchWaitTime: DISPATCH_WAIT_TIME,
+		Log: LogOptions{
+			FileSize: 30 * 1024 * 1024,
+			FileNum:  10,
+			Level:    "debug",
+			Console:  true,
+			File:     true,
+		},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.envErr = o.ApplyEnv(OPTIONS_ENV_PREFIX)
+	return o
+}
+
+func WithMaxClientNum(n int) Option {
+	return func(o *Options) {
+		o.MaxClientNum = n
+	}
+}
+
+func WithMaxChannelLen(n int) Option {
+	return func(o *Options) {
+		o.MaxChannelLen = n
+	}
+}
+
+func WithMaxUserPacketLen(n int32) Option {
+	return func(o *Options) {
+		o.MaxUserPacketLen = n
+	}
+}
+
+func WithSendTimeout(seconds int) Option {
+	return func(o *Options) {
+		o.SendTimeout = seconds
+	}
+}
+
+func WithLoginAuthTime(seconds int) Option {
+	return func(o *Options) {
+		o.LoginAuthTime = seconds
+	}
+}
+
+func WithDispatchWaitTime(seconds int) Option {
+	return func(o *Options) {
+		o.DispatchWaitTime = seconds
+	}
+}
+
+func WithReadIdleTime(seconds int) Option {
+	return func(o *Options) {
+		o.ReadIdleTime = seconds
+	}
+}
+
+func WithWriteIdleTime(seconds int) Option {
+	return func(o *Options) {
+		o.WriteIdleTime = seconds
+	}
+}
+
+func WithKeepAlive(seconds int) Option {
+	return func(o *Options) {
+		o.KeepAlive = seconds
+	}
+}
+
+func WithLog(log LogOptions) Option {
+	return func(o *Options) {
+		o.Log = log
+	}
+}
+
+// 使用已有的配置，例如LoadOptions加载的配置，之后的选项可以继续修改
+func WithOptions(src *Options) Option {
+	return func(o *Options) {
+		if nil != src {
+			*o = *src
+		}
+	}
+}
+
+// 校验配置
+func (o *Options) Validate() error {
+	if nil != o.envErr {
+		return o.envErr
+	}
+	if o.MaxClientNum <= 0 {
+		return fmt.Errorf("max_client_num[%d] must be positive", o.MaxClientNum)
+	}
+	if o.MaxChannelLen <= 0 {
+		return fmt.Errorf("max_channel_len[%d] must be positive", o.MaxChannelLen)
+	}
+	if o.MaxUserPacketLen <= 0 {
+		return fmt.Errorf("max_user_packet_len[%d] must be positive", o.MaxUserPacketLen)
+	}
+	if o.SendTimeout <= 0 {
+		return fmt.Errorf("send_timeout[%d] must be positive", o.SendTimeout)
+	}
+	if o.LoginAuthTime <= 0 {
+		return fmt.Errorf("login_auth_time[%d] must be positive", o.LoginAuthTime)
+	}
+	if o.DispatchWaitTime <= 0 {
+		return fmt.Errorf("dispatch_wait_time[%d] must be positive", o.DispatchWaitTime)
+	}
+	if o.ReadIdleTime < 0 {
+		return fmt.Errorf("read_idle_time[%d] must not be negative", o.ReadIdleTime)
+	}
+	if o.WriteIdleTime < 0 {
+		return fmt.Errorf("write_idle_time[%d] must not be negative", o.WriteIdleTime)
+	}
+	if o.Log.FileSize <= 0 {
+		return fmt.Errorf("log.file_size[%d] must be positive", o.Log.FileSize)
+	}
+	if o.Log.FileNum <= 0 {
+		return fmt.Errorf("log.file_num[%d] must be positive", o.Log.FileNum)
+	}
+	if _, ok := logLevels[strings.ToLower(o.Log.Level)]; !ok {
+		return fmt.Errorf("invalid log.level[%s]", o.Log.Level)
+	}
+	if !o.Log.Console && !o.Log.File {
+		return errors.New("log must be written to console or file")
+	}
+	return nil
+}
+
+// 处理器的队列长度，配置错误时使用默认值，错误在Validate中返回
+func (o *Options) channelLen() int {
+	if o.MaxChannelLen <= 0 {
+		return MAX_CHANNEL_LEN
+	}
+	return o.MaxChannelLen
+}
+
+// 处理器队列已满时的等待时间，配置错误时使用默认值
+func (o *Options) sendTimeout() time.Duration {
+	if o.SendTimeout <= 0 {
+		return time.Second * MAX_SEND_TIMEOUT
+	}
+	return time.Second * time.Duration(o.SendTimeout)
+}
+
+// 日志级别对应的logger常量，调用前必须先Validate
+func (o *Options) logLevel() int {
+	return logLevels[strings.ToLower(o.Log.Level)]
+}
+
+// 日志输出方式对应的logger标记
+func (o *Options) logFlags() int {
+	flags := 0
+	if o.Log.Console {
+		flags |= logger.PUT_CONSOLE
+	}
+	if o.Log.File {
+		flags |= logger.WRITE_FILE
+	}
+	return flags
+}
+
+// 从配置文件加载，按扩展名识别格式(.yaml/.yml/.json/.toml)，文件中没有的字段使用默认值，
+// 然后重新应用OPTIONS_ENV_PREFIX开头的环境变量，最后校验
+func LoadOptions(path string) (*Options, error) {
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+
+	o := NewOptions()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, o)
+	case ".json":
+		err = json.Unmarshal(data, o)
+	case ".toml":
+		_, err = toml.Decode(string(data), o)
+	default:
+		return nil, fmt.Errorf("unknown config format[%s]", path)
+	}
+	if nil != err {
+		return nil, fmt.Errorf("parse config[%s] error: %s", path, err.Error())
+	}
+
+	if err = o.ApplyEnv(OPTIONS_ENV_PREFIX); nil != err {
+		return nil, err
+	}
+	if err = o.Validate(); nil != err {
+		return nil, err
+	}
+	return o, nil
+}
+
+// 使用环境变量覆盖配置，变量名为前缀加上字段名的大写，例如SOLIDNET_MAX_CLIENT_NUM、SOLIDNET_LOG_DIR
+func (o *Options) ApplyEnv(prefix string) error {
+	fields := map[string]interface{}{
+		"MAX_CLIENT_NUM":      &o.MaxClientNum,
+		"MAX_CHANNEL_LEN":     &o.MaxChannelLen,
+		"MAX_USER_PACKET_LEN": &o.MaxUserPacketLen,
+		"SEND_TIMEOUT":        &o.SendTimeout,
+		"LOGIN_AUTH_TIME":     &o.LoginAuthTime,
+		"DISPATCH_WAIT_TIME":  &o.DispatchWaitTime,
+		"READ_IDLE_TIME":      &o.ReadIdleTime,
+		"WRITE_IDLE_TIME":     &o.WriteIdleTime,
+		"KEEP_ALIVE":          &o.KeepAlive,
+		"LOG_DIR":             &o.Log.Dir,
+		"LOG_FILE_SIZE":       &o.Log.FileSize,
+		"LOG_FILE_NUM":        &o.Log.FileNum,
+		"LOG_LEVEL":           &o.Log.Level,
+		"LOG_ASYNC":           &o.Log.Async,
+		"LOG_CONSOLE":         &o.Log.Console,
+		"LOG_FILE":            &o.Log.File,
+	}
+	for name, field := range fields {
+		value, ok := os.LookupEnv(prefix + name)
+		if !ok {
+			continue
+		}
+		// 格式错误时保留原来的值
+		var err error
+		switch v := field.(type) {
+		case *int:
+			var n int
+			if n, err = strconv.Atoi(value); nil == err {
+				*v = n
+			}
+		case *int32:
+			var n int64
+			if n, err = strconv.ParseInt(value, 10, 32); nil == err {
+				*v = int32(n)
+			}
+		case *string:
+			*v = value
+		case *bool:
+			var b bool
+			if b, err = strconv.ParseBool(value); nil == err {
+				*v = b
+			}
+		}
+		if nil != err {
+			return fmt.Errorf("invalid env %s%s[%s]", prefix, name, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package solidnet
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// NewGame可能修改全局的TimerMsgprocessor，测试结束后恢复
+func restoreTimerProcessor(t *testing.T) {
+	p := TimerMsgprocessor
+	t.Cleanup(func() {
+		TimerMsgprocessor = p
+	})
+}
+
+func TestOptionsEnv(t *testing.T) {
+	restoreTimerProcessor(t)
+	t.Setenv("SOLIDNET_MAX_CHANNEL_LEN", "7")
+	t.Setenv("SOLIDNET_SEND_TIMEOUT", "3")
+
+	// 环境变量优先于函数选项
+	g := NewGame(":0", "test", "", nil, testFactory{}, WithMaxChannelLen(100), WithMaxClientNum(5))
+	o := g.Options()
+	if 7 != o.MaxChannelLen || 3 != o.SendTimeout || 5 != o.MaxClientNum {
+		t.Fatalf("env is not applied: %+v", o)
+	}
+	p, ok := g.Processor().(*ChannelProcessor)
+	if !ok || p == GetProcessor() {
+		t.Fatal("game does not create its own processor")
+	}
+	if 7 != cap(p.messageChannel) || 3*time.Second != p.Timeout {
+		t.Fatalf("processor len[%d] timeout[%s]", cap(p.messageChannel), p.Timeout)
+	}
+}
+
+func TestOptionsInvalidEnv(t *testing.T) {
+	t.Setenv("SOLIDNET_MAX_CLIENT_NUM", "many")
+	o := NewOptions(WithMaxClientNum(5))
+	if nil == o.Validate() {
+		t.Fatal("invalid env is accepted")
+	}
+	// 格式错误的环境变量不修改配置
+	if 5 != o.MaxClientNum {
+		t.Fatalf("max client num[%d]", o.MaxClientNum)
+	}
+}
+
+func TestLoadOptionsEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "solidnet.json")
+	if err := os.WriteFile(path, []byte(`{"max_client_num": 5, "max_channel_len": 9}`), 0644); nil != err {
+		t.Fatal(err)
+	}
+	t.Setenv("SOLIDNET_MAX_CHANNEL_LEN", "7")
+	o, err := LoadOptions(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	// 环境变量优先于配置文件
+	if 5 != o.MaxClientNum || 7 != o.MaxChannelLen {
+		t.Fatalf("%+v", o)
+	}
+}
+
+func TestOptionsLegacyTimer(t *testing.T) {
+	restoreTimerProcessor(t)
+	g := NewGame(":0", "test", "", nil, testFactory{}, WithMaxChannelLen(16), WithSendTimeout(2))
+	p, ok := g.Processor().(*ChannelProcessor)
+	if !ok || p == GetProcessor() {
+		t.Fatal("game does not create its own processor")
+	}
+
+	// 修改配置后，NewTimer创建的定时器仍然投递到Game的处理器
+	timer := NewTimer(1, &countTimerHandler{})
+	timer.Start(10*time.Millisecond, false)
+	defer timer.Stop()
+	m, ok := waitMessage(p, 5*time.Second).(*TimerMessage)
+	if !ok || 1 != m.id {
+		t.Fatal("timer created by NewTimer does not fire on the game processor")
+	}
+
+	// 替换处理器后，NewTimer跟随新的处理器
+	q := NewChannelProcessorWithLen(16)
+	g.SetProcessor(q)
+	timer = NewTimer(2, &countTimerHandler{})
+	timer.Start(10*time.Millisecond, false)
+	defer timer.Stop()
+	if m, ok := waitMessage(q, 5*time.Second).(*TimerMessage); !ok || 2 != m.id {
+		t.Fatal("timer created by NewTimer does not follow SetProcessor")
+	}
+}
@@ -0,0 +1,47 @@
+package solidnet
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// 队列已满时的处理策略
+const (
+	OVERLOAD_POLICY_TIMEOUT     = 0 // 等待处理器的Timeout，超时后丢弃新消息（默认）
+	OVERLOAD_POLICY_BLOCK       = 1 // 一直等待，直到队列有空间
+	OVERLOAD_POLICY_DROP_NEWEST = 2 // 丢弃新消息
+	OVERLOAD_POLICY_DROP_OLDEST = 3 // 丢弃队列中最旧的网络消息，放入新消息
+	OVERLOAD_POLICY_DISCONNECT  = 4 // 丢弃新消息，并断开产生该消息的客户端
+	OVERLOAD_POLICY_REJECT      = 5 // 不等待，直接返回错误给调用者
+	OVERLOAD_POLICY_NUM         = 6
+)
+
+var (
+	ErrOverload = errors.New("queue is overload")
+)
+
+// 过载统计，按策略记录发生过载的次数，可以在任意协程中读取
+type OverloadStats struct {
+	counters [OVERLOAD_POLICY_NUM]uint64
+}
+
+func (s *OverloadStats) add(policy int) {
+	if policy >= 0 && policy < OVERLOAD_POLICY_NUM {
+		atomic.AddUint64(&s.counters[policy], 1)
+	}
+}
+
+// 使用该策略处理过载的次数
+func (s *OverloadStats) Get(policy int) uint64 {
+	if policy < 0 || policy >= OVERLOAD_POLICY_NUM {
+		return 0
+	}
+	return atomic.LoadUint64(&s.counters[policy])
+}
+
+// 客户端input队列的过载配置，同一个服务端的客户端共用
+type InputOverload struct {
+	Policy     int
+	OnOverload func(IClient, []byte) int // 队列已满时决定该数据使用的策略，为空时使用Policy
+	Stats      OverloadStats
+}
@@ -2,6 +2,8 @@ package solidnet
 
 import (
 	"encoding/binary"
+	"fmt"
+	"math"
 )
 
 /**********************数据包interface**********************/
@@ -35,16 +37,86 @@ type IPacket interface {
 	WriteInt64B(value int64)
 }
 
+// 扩展的数据包接口，BasePacket实现了该接口，见packettypes.go。
+// 只实现IPacket的数据包不受影响，Marshal和solidnet-gen生成的代码需要该接口
+type IPacketEx interface {
+	IPacket
+
+	// 读取时数据不足不会panic，返回0、-1或者""，并记录第一次出错，
+	// 之后的读取都失败，读取完所有字段后检查一次Err即可
+	Err() error
+
+	ReadUint8() uint8
+	ReadBool() bool
+	ReadBytes(n int32) []byte
+	ReadUint16() uint16
+	ReadUint32() uint32
+	ReadUint64() uint64
+	ReadFloat32() float32
+	ReadFloat64() float64
+	ReadLenBytes() []byte
+	ReadUint16B() uint16
+	ReadUint32B() uint32
+	ReadUint64B() uint64
+	ReadFloat32B() float32
+	ReadFloat64B() float64
+	ReadLenBytesB() []byte
+	ReadUvarint() uint64
+	ReadVarint() int64
+	ReadFixedString(n int32) string
+
+	WriteUint8(value uint8)
+	WriteBool(value bool)
+	WriteUint16(value uint16)
+	WriteUint32(value uint32)
+	WriteUint64(value uint64)
+	WriteFloat32(value float32)
+	WriteFloat64(value float64)
+	WriteLenBytes(value []byte)
+	WriteUint16B(value uint16)
+	WriteUint32B(value uint32)
+	WriteUint64B(value uint64)
+	WriteFloat32B(value float32)
+	WriteFloat64B(value float64)
+	WriteLenBytesB(value []byte)
+	WriteUvarint(value uint64)
+	WriteVarint(value int64)
+	WriteFixedString(value string, n int32)
+}
+
+// 数据包第一次读取出错的原因，处理函数拿到IPacket时不需要类型断言。
+// Err不放在IPacket中，否则已有的IPacket实现都无法编译；
+// 没有实现IPacketEx的数据包不记录读取错误，总是返回nil
+func PacketErr(p IPacket) error {
+	if ex, ok := p.(IPacketEx); ok {
+		return ex.Err()
+	}
+	return nil
+}
+
 type IPacketFactory interface {
 	NewPacket() IPacket
 }
 
 /**********************基本数据包的属性和方法实现**********************/
 type BasePacket struct {
-	Data         []byte //报数据缓冲区
-	Index        int32  //写数据索引游标
-	HeadLen      int32  //包头长度
-	BodyLenIndex int32  //包体长度字段起始位置，默认2字节
+	Data         []byte       //报数据缓冲区
+	Index        int32        //写数据索引游标
+	HeadLen      int32        //包头长度
+	BodyLenIndex int32        //包体长度字段起始位置，默认2字节
+	LengthField  *LengthField //长度字段的格式，为空时是2字节小端的包体长度，见framing.go
+	err          error        //第一次读取出错
+}
+
+// 读取时数据不足
+type UnderflowError struct {
+	Index int32 // 出错时的读取位置
+	Need  int32 // 需要的字节数
+	Len   int32 // 包的总长度
+}
+
+func (e *UnderflowError) Error() string {
+	return fmt.Sprintf("packet underflow: need %d bytes at index %d, packet length %d", e.Need, e.Index, e.Len)
 }
 
 func (p *BasePacket) GetTotalLen() int32 {
@@ -55,28 +127,67 @@ func (p *BasePacket) GetData() []byte {
 	return p.Data
 }
 
+// 包体长度，长度字段不完整或者不合法时返回-1
 func (p *BasePacket) GetBodyLen() int32 {
-	return int32(binary.LittleEndian.Uint16(p.Data[p.BodyLenIndex:]))
+	if nil == p.LengthField {
+		return int32(binary.LittleEndian.Uint16(p.Data[p.BodyLenIndex:]))
+	}
+	length := p.lengthValue()
+	if length < 0 {
+		return -1
+	}
+	if p.LengthField.IncludesHead {
+		length -= int64(p.GetHeadLen())
+	}
+	if length < 0 || length > math.MaxInt32 {
+		return -1
+	}
+	return int32(length)
 }
 
+// 包头长度，长度字段使用变长编码时和长度字段的实际字节数有关
 func (p *BasePacket) GetHeadLen() int32 {
+	if nil != p.LengthField && LENGTH_WIDTH_VARINT == p.LengthField.Width {
+		if _, n := p.lengthUvarint(); n > 0 {
+			return p.BodyLenIndex + int32(n)
+		}
+	}
 	return p.HeadLen
 }
 
 func (p *BasePacket) Copy(Data []byte) {
 	p.Data = append(p.Data[0:], Data...)
 	p.Index += p.GetHeadLen()
+	p.err = nil
 }
 
 func (p *BasePacket) Refer(Data []byte) {
 	p.Data = Data
 	p.Index = p.GetHeadLen()
+	p.err = nil
+}
+
+// 第一次读取出错的原因，没有出错时为nil
+func (p *BasePacket) Err() error {
+	return p.err
+}
+
+// 检查剩余数据是否足够读取n个字节，不够时记录错误
+func (p *BasePacket) check(n int32) bool {
+	if nil != p.err {
+		return false
+	}
+	if n < 0 || p.Index < 0 || int64(p.Index)+int64(n) > int64(len(p.Data)) {
+		p.err = &UnderflowError{Index: p.Index, Need: n, Len: int32(len(p.Data))}
+		return false
+	}
+	return true
 }
 
 /**********************基本数据读取**********************/
 func (p *BasePacket) ReadByte() byte {
 	var value byte = byte(0)
-	if p.Index < int32(len(p.Data)) {
+	if p.check(1) {
 		value = p.Data[p.Index]
 		p.Index++
 	}
@@ -85,10 +196,10 @@ func (p *BasePacket) ReadByte() byte {
 
 func (p *BasePacket) ReadString() string {
 	value := string("")
-	if p.Index+4 <= int32(len(p.Data)) {
+	if p.check(4) {
 		strLen := int32(binary.LittleEndian.Uint32(p.Data[p.Index:]))
 		p.Index += 4
-		if p.Index+strLen <= int32(len(p.Data)) {
+		if p.check(strLen) && strLen > 0 {
 			//包中的字符串是'\0'结尾，在C/C++中不会有任何问题，但是golang的string内部
 			//都是字节序，'\0'并无特殊对待，作为正常的字符处理，这里需要剔除末尾的'\0'
 			if 0 == p.Data[p.Index+strLen-1] {
@@ -105,7 +216,7 @@ func (p *BasePacket) ReadString() string {
 //小端读取
 func (p *BasePacket) ReadInt16() int16 {
 	var value int16 = -1
-	if p.Index+2 <= int32(len(p.Data)) {
+	if p.check(2) {
 		value = int16(binary.LittleEndian.Uint16(p.Data[p.Index:]))
 		p.Index += 2
 	}
@@ -114,7 +225,7 @@ func (p *BasePacket) ReadInt16() int16 {
 
 func (p *BasePacket) ReadInt32() int32 {
 	var value int32 = -1
-	if p.Index+4 <= int32(len(p.Data)) {
+	if p.check(4) {
 		value = int32(binary.LittleEndian.Uint32(p.Data[p.Index:]))
 		p.Index += 4
 	}
@@ -123,7 +234,7 @@ func (p *BasePacket) ReadInt32() int32 {
 
 func (p *BasePacket) ReadInt64() int64 {
 	var value int64 = -1
-	if p.Index+8 <= int32(len(p.Data)) {
+	if p.check(8) {
 		value = int64(binary.LittleEndian.Uint64(p.Data[p.Index:]))
 		p.Index += 8
 	}
@@ -133,7 +244,7 @@ func (p *BasePacket) ReadInt64() int64 {
 //大端读取
 func (p *BasePacket) ReadInt16B() int16 {
 	var value int16 = -1
-	if p.Index+2 <= int32(len(p.Data)) {
+	if p.check(2) {
 		value = int16(binary.BigEndian.Uint16(p.Data[p.Index:]))
 		p.Index += 2
 	}
@@ -142,7 +253,7 @@ func (p *BasePacket) ReadInt16B() int16 {
 
 func (p *BasePacket) ReadInt32B() int32 {
 	var value int32 = -1
-	if p.Index+4 <= int32(len(p.Data)) {
+	if p.check(4) {
 		value = int32(binary.BigEndian.Uint32(p.Data[p.Index:]))
 		p.Index += 4
 	}
@@ -151,7 +262,7 @@ func (p *BasePacket) ReadInt32B() int32 {
 
 func (p *BasePacket) ReadInt64B() int64 {
 	var value int64 = -1
-	if p.Index+8 <= int32(len(p.Data)) {
+	if p.check(8) {
 		value = int64(binary.BigEndian.Uint64(p.Data[p.Index:]))
 		p.Index += 8
 	}
@@ -0,0 +1,27 @@
+package solidnet
+
+import (
+	"testing"
+)
+
+// 只实现IPacket的数据包
+type legacyPacket struct {
+	IPacket
+}
+
+func TestPacketErr(t *testing.T) {
+	var p IPacket = &BasePacket{HeadLen: 4, BodyLenIndex: 0}
+	p.Refer(testPacket(1, 2))
+	p.ReadInt16()
+	if nil != PacketErr(p) {
+		t.Fatal(PacketErr(p))
+	}
+	p.ReadInt32()
+	if _, ok := PacketErr(p).(*UnderflowError); !ok {
+		t.Fatalf("got %v, want underflow", PacketErr(p))
+	}
+
+	if nil != PacketErr(legacyPacket{p}) {
+		t.Fatal("packet without Err reports an error")
+	}
+}
@@ -0,0 +1,267 @@
+package solidnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+/**********************扩展类型的读取**********************/
+func (p *BasePacket) ReadUint8() uint8 {
+	return p.ReadByte()
+}
+
+func (p *BasePacket) ReadBool() bool {
+	return 0 != p.ReadByte()
+}
+
+// 读取n个字节，返回的切片引用包中的数据
+func (p *BasePacket) ReadBytes(n int32) []byte {
+	if !p.check(n) {
+		return nil
+	}
+	value := p.Data[p.Index : p.Index+n]
+	p.Index += n
+	return value
+}
+
+// 小端读取
+func (p *BasePacket) ReadUint16() uint16 {
+	var value uint16
+	if p.check(2) {
+		value = binary.LittleEndian.Uint16(p.Data[p.Index:])
+		p.Index += 2
+	}
+	return value
+}
+
+func (p *BasePacket) ReadUint32() uint32 {
+	var value uint32
+	if p.check(4) {
+		value = binary.LittleEndian.Uint32(p.Data[p.Index:])
+		p.Index += 4
+	}
+	return value
+}
+
+func (p *BasePacket) ReadUint64() uint64 {
+	var value uint64
+	if p.check(8) {
+		value = binary.LittleEndian.Uint64(p.Data[p.Index:])
+		p.Index += 8
+	}
+	return value
+}
+
+func (p *BasePacket) ReadFloat32() float32 {
+	return math.Float32frombits(p.ReadUint32())
+}
+
+func (p *BasePacket) ReadFloat64() float64 {
+	return math.Float64frombits(p.ReadUint64())
+}
+
+// 读取uint32长度前缀的字节数组，返回的切片引用包中的数据
+func (p *BasePacket) ReadLenBytes() []byte {
+	if !p.check(4) {
+		return nil
+	}
+	n := binary.LittleEndian.Uint32(p.Data[p.Index:])
+	if n > math.MaxInt32 {
+		p.check(math.MaxInt32)
+		return nil
+	}
+	p.Index += 4
+	return p.ReadBytes(int32(n))
+}
+
+// 大端读取
+func (p *BasePacket) ReadUint16B() uint16 {
+	var value uint16
+	if p.check(2) {
+		value = binary.BigEndian.Uint16(p.Data[p.Index:])
+		p.Index += 2
+	}
+	return value
+}
+
+func (p *BasePacket) ReadUint32B() uint32 {
+	var value uint32
+	if p.check(4) {
+		value = binary.BigEndian.Uint32(p.Data[p.Index:])
+		p.Index += 4
+	}
+	return value
+}
+
+func (p *BasePacket) ReadUint64B() uint64 {
+	var value uint64
+	if p.check(8) {
+		value = binary.BigEndian.Uint64(p.Data[p.Index:])
+		p.Index += 8
+	}
+	return value
+}
+
+func (p *BasePacket) ReadFloat32B() float32 {
+	return math.Float32frombits(p.ReadUint32B())
+}
+
+func (p *BasePacket) ReadFloat64B() float64 {
+	return math.Float64frombits(p.ReadUint64B())
+}
+
+func (p *BasePacket) ReadLenBytesB() []byte {
+	if !p.check(4) {
+		return nil
+	}
+	n := binary.BigEndian.Uint32(p.Data[p.Index:])
+	if n > math.MaxInt32 {
+		p.check(math.MaxInt32)
+		return nil
+	}
+	p.Index += 4
+	return p.ReadBytes(int32(n))
+}
+
+// 变长编码，和encoding/binary的Uvarint兼容
+func (p *BasePacket) ReadUvarint() uint64 {
+	if nil != p.err {
+		return 0
+	}
+	if p.Index < 0 || p.Index > int32(len(p.Data)) {
+		p.check(1)
+		return 0
+	}
+	value, n := binary.Uvarint(p.Data[p.Index:])
+	if 0 == n {
+		// 数据不足
+		p.check(int32(len(p.Data)) - p.Index + 1)
+		return 0
+	}
+	if n < 0 {
+		p.err = fmt.Errorf("packet varint overflow at index %d", p.Index)
+		return 0
+	}
+	p.Index += int32(n)
+	return value
+}
+
+// zigzag变长编码，和encoding/binary的Varint兼容
+func (p *BasePacket) ReadVarint() int64 {
+	ux := p.ReadUvarint()
+	value := int64(ux >> 1)
+	if 0 != ux&1 {
+		value = ^value
+	}
+	return value
+}
+
+// 读取n字节的定长字符串，剔除末尾填充的'\0'
+func (p *BasePacket) ReadFixedString(n int32) string {
+	b := p.ReadBytes(n)
+	for len(b) > 0 && 0 == b[len(b)-1] {
+		b = b[:len(b)-1]
+	}
+	return string(b)
+}
+
+/**********************扩展类型的写入**********************/
+func (p *BasePacket) WriteUint8(value uint8) {
+	p.WriteByte(value)
+}
+
+func (p *BasePacket) WriteBool(value bool) {
+	if value {
+		p.WriteByte(1)
+	} else {
+		p.WriteByte(0)
+	}
+}
+
+// 小端写入
+func (p *BasePacket) WriteUint16(value uint16) {
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf[0:], value)
+	p.Data = append(p.Data, buf...)
+}
+
+func (p *BasePacket) WriteUint32(value uint32) {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf[0:], value)
+	p.Data = append(p.Data, buf...)
+}
+
+func (p *BasePacket) WriteUint64(value uint64) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf[0:], value)
+	p.Data = append(p.Data, buf...)
+}
+
+func (p *BasePacket) WriteFloat32(value float32) {
+	p.WriteUint32(math.Float32bits(value))
+}
+
+func (p *BasePacket) WriteFloat64(value float64) {
+	p.WriteUint64(math.Float64bits(value))
+}
+
+// 写入uint32长度前缀的字节数组
+func (p *BasePacket) WriteLenBytes(value []byte) {
+	p.WriteUint32(uint32(len(value)))
+	p.WriteBytes(value)
+}
+
+// 大端写入
+func (p *BasePacket) WriteUint16B(value uint16) {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf[0:], value)
+	p.Data = append(p.Data, buf...)
+}
+
+func (p *BasePacket) WriteUint32B(value uint32) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf[0:], value)
+	p.Data = append(p.Data, buf...)
+}
+
+func (p *BasePacket) WriteUint64B(value uint64) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf[0:], value)
+	p.Data = append(p.Data, buf...)
+}
+
+func (p *BasePacket) WriteFloat32B(value float32) {
+	p.WriteUint32B(math.Float32bits(value))
+}
+
+func (p *BasePacket) WriteFloat64B(value float64) {
+	p.WriteUint64B(math.Float64bits(value))
+}
+
+func (p *BasePacket) WriteLenBytesB(value []byte) {
+	p.WriteUint32B(uint32(len(value)))
+	p.WriteBytes(value)
+}
+
+func (p *BasePacket) WriteUvarint(value uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, value)
+	p.Data = append(p.Data, buf[:n]...)
+}
+
+func (p *BasePacket) WriteVarint(value int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, value)
+	p.Data = append(p.Data, buf[:n]...)
+}
+
+// 写入n字节的定长字符串，不足时用'\0'填充，超过时截断，n不大于0时不写入
+func (p *BasePacket) WriteFixedString(value string, n int32) {
+	if n <= 0 {
+		return
+	}
+	buf := make([]byte, n)
+	copy(buf, value)
+	p.Data = append(p.Data, buf...)
+}
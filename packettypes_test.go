@@ -0,0 +1,283 @@
+package solidnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// 扩展类型的写入和读取，encoded为写入的字节，为空时不检查
+type packetTypeCase struct {
+	name    string
+	write   func(p *BasePacket)
+	read    func(p *BasePacket) interface{}
+	want    interface{}
+	encoded []byte
+}
+
+// 和字节序无关的类型
+var packetTypeCases = []packetTypeCase{
+	{"uint8", func(p *BasePacket) { p.WriteUint8(0xfe) }, func(p *BasePacket) interface{} { return p.ReadUint8() }, uint8(0xfe), []byte{0xfe}},
+	{"bool true", func(p *BasePacket) { p.WriteBool(true) }, func(p *BasePacket) interface{} { return p.ReadBool() }, true, []byte{1}},
+	{"bool false", func(p *BasePacket) { p.WriteBool(false) }, func(p *BasePacket) interface{} { return p.ReadBool() }, false, []byte{0}},
+	{"bytes", func(p *BasePacket) { p.WriteBytes([]byte{1, 2, 3}) }, func(p *BasePacket) interface{} { return p.ReadBytes(3) }, []byte{1, 2, 3}, []byte{1, 2, 3}},
+	{"uvarint", func(p *BasePacket) { p.WriteUvarint(300) }, func(p *BasePacket) interface{} { return p.ReadUvarint() }, uint64(300), []byte{0xac, 0x02}},
+	{"uvarint max", func(p *BasePacket) { p.WriteUvarint(math.MaxUint64) }, func(p *BasePacket) interface{} { return p.ReadUvarint() }, uint64(math.MaxUint64), nil},
+	{"varint", func(p *BasePacket) { p.WriteVarint(-3) }, func(p *BasePacket) interface{} { return p.ReadVarint() }, int64(-3), []byte{0x05}},
+	{"varint min", func(p *BasePacket) { p.WriteVarint(math.MinInt64) }, func(p *BasePacket) interface{} { return p.ReadVarint() }, int64(math.MinInt64), nil},
+	{"fixed string", func(p *BasePacket) { p.WriteFixedString("ab", 4) }, func(p *BasePacket) interface{} { return p.ReadFixedString(4) }, "ab", []byte{'a', 'b', 0, 0}},
+	{"fixed string truncated", func(p *BasePacket) { p.WriteFixedString("abcdef", 3) }, func(p *BasePacket) interface{} { return p.ReadFixedString(3) }, "abc", []byte{'a', 'b', 'c'}},
+}
+
+// 区分字节序的类型，big为true时使用大端的读写方法
+type endianCase struct {
+	name    string
+	write   func(p *BasePacket, big bool)
+	read    func(p *BasePacket, big bool) interface{}
+	want    interface{}
+	encoded func(order binary.AppendByteOrder) []byte
+}
+
+var endianCases = []endianCase{
+	{"uint16",
+		func(p *BasePacket, big bool) {
+			if big {
+				p.WriteUint16B(0x0102)
+			} else {
+				p.WriteUint16(0x0102)
+			}
+		},
+		func(p *BasePacket, big bool) interface{} {
+			if big {
+				return p.ReadUint16B()
+			}
+			return p.ReadUint16()
+		},
+		uint16(0x0102),
+		func(order binary.AppendByteOrder) []byte { return order.AppendUint16(nil, 0x0102) }},
+	{"uint32",
+		func(p *BasePacket, big bool) {
+			if big {
+				p.WriteUint32B(0x01020304)
+			} else {
+				p.WriteUint32(0x01020304)
+			}
+		},
+		func(p *BasePacket, big bool) interface{} {
+			if big {
+				return p.ReadUint32B()
+			}
+			return p.ReadUint32()
+		},
+		uint32(0x01020304),
+		func(order binary.AppendByteOrder) []byte { return order.AppendUint32(nil, 0x01020304) }},
+	{"uint64",
+		func(p *BasePacket, big bool) {
+			if big {
+				p.WriteUint64B(0x0102030405060708)
+			} else {
+				p.WriteUint64(0x0102030405060708)
+			}
+		},
+		func(p *BasePacket, big bool) interface{} {
+			if big {
+				return p.ReadUint64B()
+			}
+			return p.ReadUint64()
+		},
+		uint64(0x0102030405060708),
+		func(order binary.AppendByteOrder) []byte { return order.AppendUint64(nil, 0x0102030405060708) }},
+	{"float32",
+		func(p *BasePacket, big bool) {
+			if big {
+				p.WriteFloat32B(-1.5)
+			} else {
+				p.WriteFloat32(-1.5)
+			}
+		},
+		func(p *BasePacket, big bool) interface{} {
+			if big {
+				return p.ReadFloat32B()
+			}
+			return p.ReadFloat32()
+		},
+		float32(-1.5),
+		func(order binary.AppendByteOrder) []byte { return order.AppendUint32(nil, math.Float32bits(-1.5)) }},
+	{"float64",
+		func(p *BasePacket, big bool) {
+			if big {
+				p.WriteFloat64B(math.Inf(-1))
+			} else {
+				p.WriteFloat64(math.Inf(-1))
+			}
+		},
+		func(p *BasePacket, big bool) interface{} {
+			if big {
+				return p.ReadFloat64B()
+			}
+			return p.ReadFloat64()
+		},
+		math.Inf(-1),
+		func(order binary.AppendByteOrder) []byte {
+			return order.AppendUint64(nil, math.Float64bits(math.Inf(-1)))
+		}},
+	{"len bytes",
+		func(p *BasePacket, big bool) {
+			if big {
+				p.WriteLenBytesB([]byte{9, 8})
+			} else {
+				p.WriteLenBytes([]byte{9, 8})
+			}
+		},
+		func(p *BasePacket, big bool) interface{} {
+			if big {
+				return p.ReadLenBytesB()
+			}
+			return p.ReadLenBytes()
+		},
+		[]byte{9, 8},
+		func(order binary.AppendByteOrder) []byte { return append(order.AppendUint32(nil, 2), 9, 8) }},
+	{"len bytes empty",
+		func(p *BasePacket, big bool) {
+			if big {
+				p.WriteLenBytesB(nil)
+			} else {
+				p.WriteLenBytes(nil)
+			}
+		},
+		func(p *BasePacket, big bool) interface{} {
+			if big {
+				return p.ReadLenBytesB()
+			}
+			return p.ReadLenBytes()
+		},
+		[]byte{},
+		func(order binary.AppendByteOrder) []byte { return order.AppendUint32(nil, 0) }},
+}
+
+// 指定字节序的用例
+func endianPacketCases(big bool) []packetTypeCase {
+	order := binary.AppendByteOrder(binary.LittleEndian)
+	if big {
+		order = binary.BigEndian
+	}
+	cases := make([]packetTypeCase, 0, len(endianCases))
+	for _, c := range endianCases {
+		c := c
+		cases = append(cases, packetTypeCase{
+			name:    c.name,
+			write:   func(p *BasePacket) { c.write(p, big) },
+			read:    func(p *BasePacket) interface{} { return c.read(p, big) },
+			want:    c.want,
+			encoded: c.encoded(order),
+		})
+	}
+	return cases
+}
+
+func testPacketTypes(t *testing.T, name string, cases []packetTypeCase) {
+	for _, c := range cases {
+		p := &BasePacket{}
+		c.write(p)
+		if nil != c.encoded && !bytes.Equal(c.encoded, p.Data) {
+			t.Errorf("%s %s: encoded %v, want %v", name, c.name, p.Data, c.encoded)
+			continue
+		}
+		encoded := p.Data
+
+		// 前后各写入一个字节，检查读取的位置
+		p = &BasePacket{}
+		p.WriteByte(0xaa)
+		c.write(p)
+		p.WriteByte(0xbb)
+		p.Refer(p.Data)
+		p.ReadByte()
+		if got := c.read(p); !reflect.DeepEqual(c.want, got) {
+			t.Errorf("%s %s: read %v, want %v", name, c.name, got, c.want)
+		}
+		if 0xbb != p.ReadByte() || nil != p.Err() {
+			t.Errorf("%s %s: read position is wrong, error[%v]", name, c.name, p.Err())
+		}
+
+		// 数据不足时不panic，记录错误
+		if 0 == len(encoded) {
+			continue
+		}
+		p = &BasePacket{}
+		p.Refer(encoded[:len(encoded)-1])
+		c.read(p)
+		if nil == p.Err() {
+			t.Errorf("%s %s: no error on short input", name, c.name)
+		}
+	}
+}
+
+func TestPacketTypesRoundTrip(t *testing.T) {
+	testPacketTypes(t, "", packetTypeCases)
+	testPacketTypes(t, "little endian", endianPacketCases(false))
+	testPacketTypes(t, "big endian", endianPacketCases(true))
+}
+
+func TestWriteFixedStringNegative(t *testing.T) {
+	p := &BasePacket{}
+	p.WriteFixedString("abc", -1)
+	p.WriteFixedString("abc", 0)
+	if 0 != len(p.Data) {
+		t.Fatalf("wrote %v", p.Data)
+	}
+	p.Refer(p.Data)
+	if "" != p.ReadFixedString(-1) || nil == p.Err() {
+		t.Fatal("negative length is accepted")
+	}
+}
+
+func FuzzReadUvarint(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0xac, 0x02})
+	f.Add([]byte{0x80, 0x80})
+	f.Add(bytes.Repeat([]byte{0xff}, 11))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := &BasePacket{}
+		p.Refer(data)
+		got := p.ReadUvarint()
+		want, n := binary.Uvarint(data)
+		if n <= 0 {
+			if nil == p.Err() || 0 != got {
+				t.Fatalf("invalid input %v: got %d, error[%v]", data, got, p.Err())
+			}
+			return
+		}
+		if nil != p.Err() || want != got || int32(n) != p.Index {
+			t.Fatalf("input %v: got %d at %d, want %d at %d, error[%v]", data, got, p.Index, want, n, p.Err())
+		}
+	})
+}
+
+func FuzzReadLenBytes(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{2, 0, 0})
+	f.Add([]byte{2, 0, 0, 0, 1, 2})
+	f.Add([]byte{0, 0, 0, 2, 1, 2})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		check := func(name string, order binary.ByteOrder, read func(p *BasePacket) []byte) {
+			p := &BasePacket{}
+			p.Refer(data)
+			got := read(p)
+			if len(data) < 4 || uint64(4)+uint64(order.Uint32(data)) > uint64(len(data)) {
+				if nil == p.Err() || nil != got {
+					t.Fatalf("%s short input %v: got %v, error[%v]", name, data, got, p.Err())
+				}
+				return
+			}
+			want := data[4 : 4+order.Uint32(data)]
+			if nil != p.Err() || !bytes.Equal(want, got) {
+				t.Fatalf("%s input %v: got %v, want %v, error[%v]", name, data, got, want, p.Err())
+			}
+		}
+		check("little endian", binary.LittleEndian, (*BasePacket).ReadLenBytes)
+		check("big endian", binary.BigEndian, (*BasePacket).ReadLenBytesB)
+	})
+}
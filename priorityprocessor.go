@@ -0,0 +1,290 @@
+package solidnet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	logger "github.com/idakun/tinylog"
+)
+
+// 优先级通道，数值越小优先级越高
+const (
+	LANE_CONTROL = 0 // 状态消息
+	LANE_TIMER   = 1 // 定时器消息
+	LANE_NET     = 2 // 网络消息和其他消息
+	LANE_NUM     = 3
+)
+
+// 公平策略
+const (
+	FAIRNESS_STRICT   = 0 // 严格优先级：高优先级通道有消息时总是先处理
+	FAIRNESS_WEIGHTED = 1 // 加权轮询：每一轮按权重分配处理次数，低优先级通道也不会饿死
+)
+
+// 优先级处理器：状态、定时器、网络消息分别进入不同的通道，大量的网络消息
+// 不会延迟断线通知和登录超时之类的控制消息。只能有一个协程调用Epoll。
+// 同一个客户端的状态消息不会越过它之前投递的网络消息，例如STATE_CLOSED总是在
+// 该客户端之前的网络消息处理完之后才返回，没有积压网络消息的客户端不受影响
+type PriorityProcessor struct {
+	lanes    [LANE_NUM]chan IMessage
+	Fairness int
+	Weights  [LANE_NUM]int      // 加权轮询时每个通道的权重，必须大于0
+	LaneFunc func(IMessage) int // 消息所属的通道，默认实现见defaultLane
+	credits  [LANE_NUM]int      // 本轮剩余的处理次数
+	quit     IMessage           // 其他通道处理完后再返回的退出消息
+	Timeout  time.Duration      // 网络消息在队列已满时的等待时间
+	Clock    IClock
+
+	mutex   sync.Mutex
+	clients map[IClient]*clientSeq // 有网络消息或者状态消息在队列中的客户端
+	ready   []IMessage             // 之前的网络消息已经处理完，可以返回的状态消息
+}
+
+// 客户端的消息顺序
+type clientSeq struct {
+	dispatched uint64            // 投递的网络消息数量
+	handled    uint64            // 已经返回或者丢弃的网络消息数量
+	states     int               // 还没有返回的状态消息数量
+	deferred   []*barrierMessage // 等待之前的网络消息处理完的状态消息
+}
+
+// 带有顺序屏障的状态消息，barrier为投递时该客户端已经投递的网络消息数量
+type barrierMessage struct {
+	*StateMessage
+	barrier uint64
+}
+
+func NewPriorityProcessor(fairness int) *PriorityProcessor {
+	p := new(PriorityProcessor)
+	for i := range p.lanes {
+		p.lanes[i] = make(chan IMessage, defaultOptions.channelLen())
+	}
+	p.Timeout = defaultOptions.sendTimeout()
+	p.Fairness = fairness
+	p.Weights = [LANE_NUM]int{8, 4, 1}
+	p.LaneFunc = defaultLane
+	p.Clock = RealClock
+	p.clients = make(map[IClient]*clientSeq)
+	return p
+}
+
+// 检查配置，Game.Init时调用
+func (p *PriorityProcessor) Validate() error {
+	if FAIRNESS_WEIGHTED != p.Fairness {
+		return nil
+	}
+	for i, weight := range p.Weights {
+		if weight <= 0 {
+			// 权重为0的通道永远不会被处理
+			return fmt.Errorf("weight[%d] of lane[%d] must be positive", weight, i)
+		}
+	}
+	return nil
+}
+
+func defaultLane(message IMessage) int {
+	switch message.(type) {
+	case *StateMessage:
+		return LANE_CONTROL
+	case *TimerMessage:
+		return LANE_TIMER
+	default:
+		return LANE_NET
+	}
+}
+
+func (p *PriorityProcessor) Dispatch(message IMessage) {
+	lane := p.LaneFunc(message)
+	if lane < 0 || lane >= LANE_NUM {
+		lane = LANE_NET
+	}
+	message = p.track(message)
+	if !isSheddable(message) {
+		// 只有网络消息会在超时后丢弃，其他消息丢失会导致状态不一致，一直等待
+		p.lanes[lane] <- message
+		return
+	}
+	timer := p.Clock.NewTimer(p.Timeout)
+	defer timer.Stop()
+	select {
+	case p.lanes[lane] <- message:
+
+	case <-timer.C():
+		//超时，导致数据丢弃
+		logger.Error("send to lane[%d] channel timeout!!!", lane)
+		p.handled(message.(*NetMessage).client)
+	}
+}
+
+// 记录客户端的消息顺序，状态消息加上顺序屏障
+func (p *PriorityProcessor) track(message IMessage) IMessage {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	switch m := message.(type) {
+	case *NetMessage:
+		p.seq(m.client).dispatched++
+	case *StateMessage:
+		s := p.seq(m.client)
+		s.states++
+		return &barrierMessage{m, s.dispatched}
+	}
+	return message
+}
+
+// 调用前必须加锁
+func (p *PriorityProcessor) seq(client IClient) *clientSeq {
+	s, ok := p.clients[client]
+	if !ok {
+		s = new(clientSeq)
+		p.clients[client] = s
+	}
+	return s
+}
+
+// 客户端的一个网络消息已经返回或者丢弃，之前等待它的状态消息可以返回
+func (p *PriorityProcessor) handled(client IClient) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s := p.clients[client]
+	s.handled++
+	for len(s.deferred) > 0 && s.deferred[0].barrier <= s.handled {
+		p.ready = append(p.ready, s.deferred[0].StateMessage)
+		s.deferred = s.deferred[1:]
+		s.states--
+	}
+	p.release(client, s)
+}
+
+// 状态消息之前的网络消息都已经处理完时返回true，否则暂存到这些网络消息处理完
+func (p *PriorityProcessor) reached(m *barrierMessage) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s := p.clients[m.client]
+	if m.barrier > s.handled || len(s.deferred) > 0 {
+		s.deferred = append(s.deferred, m)
+		return false
+	}
+	s.states--
+	p.release(m.client, s)
+	return true
+}
+
+// 客户端没有在队列中的消息时删除，调用前必须加锁
+func (p *PriorityProcessor) release(client IClient, s *clientSeq) {
+	if s.dispatched == s.handled && 0 == s.states {
+		delete(p.clients, client)
+	}
+}
+
+// 实现 offerer，需要记录顺序的网络消息和状态消息不支持
+func (p *PriorityProcessor) offer(message IMessage) bool {
+	switch message.(type) {
+	case *NetMessage, *StateMessage:
+		return false
+	}
+	lane := p.LaneFunc(message)
+	if lane < 0 || lane >= LANE_NUM {
+		lane = LANE_NET
+	}
+	select {
+	case p.lanes[lane] <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *PriorityProcessor) Epoll() IMessage {
+	for {
+		if message := p.nextReady(); nil != message {
+			return message
+		}
+		message := p.poll()
+		if nil == message {
+			// 所有通道都为空，等待任意通道的消息
+			select {
+			case message = <-p.lanes[LANE_CONTROL]:
+			case message = <-p.lanes[LANE_TIMER]:
+			case message = <-p.lanes[LANE_NET]:
+			}
+		}
+		if _, ok := message.(*quitMessage); ok && !p.empty() {
+			// 退出消息等其他消息都处理完再返回
+			p.quit = message
+			continue
+		}
+		switch m := message.(type) {
+		case *NetMessage:
+			p.handled(m.client)
+		case *barrierMessage:
+			if !p.reached(m) {
+				continue
+			}
+			return m.StateMessage
+		}
+		return message
+	}
+}
+
+func (p *PriorityProcessor) nextReady() IMessage {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if 0 == len(p.ready) {
+		return nil
+	}
+	message := p.ready[0]
+	p.ready = p.ready[1:]
+	return message
+}
+
+// 按公平策略取一条消息，所有通道都为空时，返回暂存的退出消息或者nil
+func (p *PriorityProcessor) poll() IMessage {
+	if FAIRNESS_WEIGHTED == p.Fairness {
+		for round := 0; round < 2; round++ {
+			for i := range p.lanes {
+				if p.credits[i] <= 0 {
+					continue
+				}
+				select {
+				case message := <-p.lanes[i]:
+					p.credits[i]--
+					return message
+				default:
+				}
+			}
+			// 本轮的处理次数已经用完，或者有剩余次数的通道都为空，开始新的一轮
+			p.credits = p.Weights
+		}
+	} else {
+		for i := range p.lanes {
+			select {
+			case message := <-p.lanes[i]:
+				return message
+			default:
+			}
+		}
+	}
+
+	if nil != p.quit {
+		message := p.quit
+		p.quit = nil
+		return message
+	}
+	return nil
+}
+
+func (p *PriorityProcessor) empty() bool {
+	p.mutex.Lock()
+	ready := len(p.ready)
+	p.mutex.Unlock()
+	if ready > 0 {
+		return false
+	}
+	for i := range p.lanes {
+		if len(p.lanes[i]) > 0 {
+			return false
+		}
+	}
+	return true
+}
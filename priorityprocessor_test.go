@@ -0,0 +1,61 @@
+package solidnet
+
+import (
+	"testing"
+)
+
+func TestPriorityProcessorClientOrder(t *testing.T) {
+	p := NewPriorityProcessor(FAIRNESS_STRICT)
+	a := NewConnector("a", nil, testFactory{})
+	b := NewConnector("b", nil, testFactory{})
+	a1 := &NetMessage{testPacket(1, 0), a}
+	a2 := &NetMessage{testPacket(2, 0), a}
+	closed := &StateMessage{STATE_CLOSED, a, CLOSE_REASON_NONE}
+	connected := &StateMessage{STATE_CONNECTED, b, CLOSE_REASON_NONE}
+	p.Dispatch(a1)
+	p.Dispatch(a2)
+	p.Dispatch(closed)
+	p.Dispatch(connected)
+
+	// b没有积压的网络消息，状态消息优先；a的断线通知在它之前的网络消息之后
+	for i, want := range []IMessage{connected, a1, a2, closed} {
+		if got := p.Epoll(); got != want {
+			t.Fatalf("message %d: got %v, want %v", i, got, want)
+		}
+	}
+	if 0 != len(p.clients) {
+		t.Fatalf("%d clients are not released", len(p.clients))
+	}
+}
+
+func TestPriorityProcessorStateBeforeNet(t *testing.T) {
+	p := NewPriorityProcessor(FAIRNESS_WEIGHTED)
+	a := NewConnector("a", nil, testFactory{})
+	connected := &StateMessage{STATE_CONNECTED, a, CLOSE_REASON_NONE}
+	a1 := &NetMessage{testPacket(1, 0), a}
+	p.Dispatch(a1)
+	p.Dispatch(connected)
+	p.Dispatch(&NetMessage{testPacket(2, 0), a})
+
+	// 状态消息只等待它之前投递的网络消息
+	for i, want := range []IMessage{a1, connected} {
+		if got := p.Epoll(); got != want {
+			t.Fatalf("message %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestPriorityProcessorValidate(t *testing.T) {
+	p := NewPriorityProcessor(FAIRNESS_WEIGHTED)
+	if err := p.Validate(); nil != err {
+		t.Fatal(err)
+	}
+	p.Weights[LANE_TIMER] = 0
+	if nil == p.Validate() {
+		t.Fatal("zero weight is accepted")
+	}
+	p.Fairness = FAIRNESS_STRICT
+	if err := p.Validate(); nil != err {
+		t.Fatal(err)
+	}
+}
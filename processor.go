@@ -17,25 +17,184 @@ type IProcessor interface {
 	Epoll() IMessage
 }
 
+// 获取默认的全局处理器，兼容旧的用法。需要在一个进程中运行多个Game时，
+// 每个Game使用NewChannelProcessor创建自己的处理器
 func GetProcessor() IProcessor {
 	once.Do(func() {
-		p = &ChannelProcessor{make(chan IMessage, MAX_CHANNEL_LEN)}
+		p = NewChannelProcessor()
 	})
 	return p
 }
 
+// 使用默认配置创建处理器，队列长度和等待时间可以用环境变量调整
+func NewChannelProcessor() *ChannelProcessor {
+	return NewChannelProcessorWithOptions(defaultOptions)
+}
+
+// 创建指定队列长度的处理器
+func NewChannelProcessorWithLen(n int) *ChannelProcessor {
+	return &ChannelProcessor{messageChannel: make(chan IMessage, n), Timeout: defaultOptions.sendTimeout(), Clock: RealClock}
+}
+
+// 按配置创建处理器，队列长度为MaxChannelLen，队列已满时等待SendTimeout秒
+func NewChannelProcessorWithOptions(o *Options) *ChannelProcessor {
+	p := NewChannelProcessorWithLen(o.channelLen())
+	p.Timeout = o.sendTimeout()
+	return p
+}
+
+// 过载时可以丢弃的消息，只有网络消息。其他消息（包括业务层投递的TaskMessage）
+// 在队列已满时一直等待，逻辑协程中不能在队列已满时投递这些消息
+func isSheddable(message IMessage) bool {
+	_, ok := message.(*NetMessage)
+	return ok
+}
+
+// 投递网络消息，处理器实现了IOverloadProcessor时按过载策略处理，返回过载错误
+func tryDispatch(p IProcessor, message IMessage) error {
+	if op, ok := p.(IOverloadProcessor); ok {
+		return op.TryDispatch(message)
+	}
+	p.Dispatch(message)
+	return nil
+}
+
+// 需要检查配置的处理器，Game.Init时调用Validate
+type IValidProcessor interface {
+	IProcessor
+	Validate() error
+}
+
+// 支持返回过载错误的处理器
+type IOverloadProcessor interface {
+	IProcessor
+	TryDispatch(IMessage) error
+}
+
 type ChannelProcessor struct {
 	messageChannel chan IMessage
+	mutex          sync.RWMutex // 丢弃最旧的消息时需要重排队列，期间暂停其他投递
+
+	Policy     int                // 队列已满时的处理策略，默认OVERLOAD_POLICY_TIMEOUT
+	OnOverload func(IMessage) int // 队列已满时决定该消息使用的策略，为空时使用Policy
+	Timeout    time.Duration      // OVERLOAD_POLICY_TIMEOUT的等待时间
+	Stats      OverloadStats
+	Clock      IClock
 }
 
 func (p *ChannelProcessor) Dispatch(message IMessage) {
+	p.TryDispatch(message)
+}
 
+// 投递消息，消息没有放入队列时返回ErrOverload。
+// 过载策略只作用于网络消息，状态、定时器和退出消息丢失会导致状态不一致，队列已满时一直等待
+func (p *ChannelProcessor) TryDispatch(message IMessage) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if !isSheddable(message) {
+		p.messageChannel <- message
+		return nil
+	}
 	select {
 	case p.messageChannel <- message:
+		return nil
+	default:
+	}
+
+	// 队列已满，按策略处理
+	policy := p.Policy
+	if nil != p.OnOverload {
+		policy = p.OnOverload(message)
+	}
+	p.Stats.add(policy)
+
+	switch policy {
+	case OVERLOAD_POLICY_BLOCK:
+		p.messageChannel <- message
+		return nil
+	case OVERLOAD_POLICY_DROP_NEWEST:
+		logger.Error("packet channel is full, drop newest message!!!")
+		return ErrOverload
+	case OVERLOAD_POLICY_DROP_OLDEST:
+		p.mutex.RUnlock()
+		dropped := p.dropOldest(message)
+		p.mutex.RLock()
+		if !dropped {
+			// 队列中都是不能丢弃的消息，等待
+			p.messageChannel <- message
+		}
+		return nil
+	case OVERLOAD_POLICY_DISCONNECT:
+		client := (message.Args()).(IClient)
+		logger.Error("packet channel is full, disconnect client[%s]!!!", client.RemoteAddr())
+		CloseClient(client)
+		return ErrOverload
+	case OVERLOAD_POLICY_REJECT:
+		return ErrOverload
+	default:
+		timer := p.Clock.NewTimer(p.Timeout)
+		defer timer.Stop()
+		select {
+		case p.messageChannel <- message:
+			return nil
+		case <-timer.C():
+			//超时，导致数据丢弃
+			logger.Error("send to packet channel timeout!!!")
+			return ErrOverload
+		}
+	}
+}
+
+// 丢弃队列中最旧的网络消息并放入新消息，其他消息保持原来的顺序。
+// 队列中没有网络消息时返回false，新消息没有放入队列
+func (p *ChannelProcessor) dropOldest(message IMessage) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	select {
+	case p.messageChannel <- message:
+		return true
+	default:
+	}
 
-	case <-time.After(time.Second * MAX_SEND_TIMEOUT):
-		//超时，导致数据丢弃
-		logger.Error("send to packet channel timeout!!!")
+	// 其他投递已经暂停，取出队列中的消息，逻辑协程同时取走的是更早的消息，顺序不变
+	var held []IMessage
+	for {
+		select {
+		case m := <-p.messageChannel:
+			held = append(held, m)
+			continue
+		default:
+		}
+		break
+	}
+	dropped := false
+	for i, m := range held {
+		if !isSheddable(m) {
+			continue
+		}
+		logger.Error("packet channel is full, drop oldest message!!!")
+		held = append(held[:i], held[i+1:]...)
+		dropped = true
+		break
+	}
+	if dropped {
+		held = append(held, message)
+	}
+	for _, m := range held {
+		p.messageChannel <- m
+	}
+	return dropped
+}
+
+// 实现 offerer
+func (p *ChannelProcessor) offer(message IMessage) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	select {
+	case p.messageChannel <- message:
+		return true
+	default:
+		return false
 	}
 }
 
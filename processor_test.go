@@ -0,0 +1,71 @@
+package solidnet
+
+import (
+	"testing"
+	"time"
+)
+
+func testNetMessage(i int) *NetMessage {
+	return &NetMessage{testPacket(i, 0), nil}
+}
+
+func TestDropOldestKeepsControlMessages(t *testing.T) {
+	p := NewChannelProcessorWithLen(3)
+	p.Policy = OVERLOAD_POLICY_DROP_OLDEST
+	state := &StateMessage{STATE_CONNECTED, nil, CLOSE_REASON_NONE}
+	p.Dispatch(state)
+	for i := 1; i <= 4; i++ {
+		if err := p.TryDispatch(testNetMessage(i)); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	// 状态消息保留在原来的位置，丢弃的是最旧的网络消息1
+	if m := p.Epoll(); m != state {
+		t.Fatalf("got %T, want the state message", m)
+	}
+	for _, want := range []int{3, 4} {
+		m := p.Epoll().(*NetMessage)
+		if got := testCmd(&BasePacket{Data: m.packet}); int32(want) != got {
+			t.Fatalf("got net message %d, want %d", got, want)
+		}
+	}
+	if 2 != p.Stats.Get(OVERLOAD_POLICY_DROP_OLDEST) {
+		t.Fatalf("got %d overloads", p.Stats.Get(OVERLOAD_POLICY_DROP_OLDEST))
+	}
+}
+
+func TestControlMessagesAreNotShed(t *testing.T) {
+	for _, policy := range []int{OVERLOAD_POLICY_DROP_NEWEST, OVERLOAD_POLICY_DROP_OLDEST, OVERLOAD_POLICY_REJECT} {
+		p := NewChannelProcessorWithLen(1)
+		p.Policy = policy
+		p.Dispatch(&StateMessage{STATE_CONNECTED, nil, CLOSE_REASON_NONE})
+
+		done := make(chan error, 1)
+		go func() {
+			done <- p.TryDispatch(&TimerMessage{id: 1})
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("policy %d: timer message is not blocked, error[%v]", policy, err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		p.Epoll()
+		if err := <-done; nil != err {
+			t.Fatalf("policy %d: %s", policy, err.Error())
+		}
+		if _, ok := p.Epoll().(*TimerMessage); !ok {
+			t.Fatalf("policy %d: timer message is lost", policy)
+		}
+	}
+}
+
+func TestNetMessagesAreShed(t *testing.T) {
+	p := NewChannelProcessorWithLen(1)
+	p.Policy = OVERLOAD_POLICY_REJECT
+	p.Dispatch(testNetMessage(1))
+	if ErrOverload != p.TryDispatch(testNetMessage(2)) {
+		t.Fatal("net message is not rejected")
+	}
+}
@@ -0,0 +1,177 @@
+package solidnet
+
+import (
+	"fmt"
+	"reflect"
+
+	logger "github.com/idakun/tinylog"
+)
+
+// 命令处理函数
+type RouteHandler func(IPacket, IClient)
+
+// 从数据包中取出命令字
+type CmdExtractor func(IPacket) int32
+
+// 命令路由，实现 IHandler：网络消息按命令字派发到注册的处理函数，
+// 定时器消息派发到ITimerHandler，状态消息派发到状态处理函数。
+// 所有注册必须在Game.Run之前完成
+type Router struct {
+	factory   IPacketFactory
+	extractor CmdExtractor
+	handlers  map[int32]RouteHandler
+	modules   []*RouterModule
+	fallback  func(int32, IPacket, IClient)
+	onState   func(int32, int32, IClient)
+}
+
+// 模块，独占一段命令字[Min, Max]，不同的团队可以各自负责不同的模块
+type RouterModule struct {
+	Name     string
+	Min      int32
+	Max      int32
+	router   *Router
+	fallback RouteHandler
+}
+
+func NewRouter(f IPacketFactory, extractor CmdExtractor) *Router {
+	r := new(Router)
+	r.factory = f
+	r.extractor = extractor
+	r.handlers = make(map[int32]RouteHandler)
+	r.fallback = func(cmd int32, p IPacket, c IClient) {
+		logger.Error("Not find handler of cmd:%x, client[%s]", cmd, c.RemoteAddr())
+	}
+	return r
+}
+
+// 注册命令处理函数，命令字已经注册或者属于其他模块时返回错误
+func (r *Router) Register(cmd int32, h RouteHandler) error {
+	if m := r.findModule(cmd); nil != m {
+		return fmt.Errorf("cmd[%x] belongs to module[%s]", cmd, m.Name)
+	}
+	return r.register(cmd, h)
+}
+
+func (r *Router) register(cmd int32, h RouteHandler) error {
+	if _, ok := r.handlers[cmd]; ok {
+		return fmt.Errorf("cmd[%x] is already registered", cmd)
+	}
+	r.handlers[cmd] = h
+	return nil
+}
+
+// 注册模块，命令字范围和已有的模块或者已经注册的命令字重叠时返回错误
+func (r *Router) RegisterModule(name string, min int32, max int32) (*RouterModule, error) {
+	if min > max {
+		return nil, fmt.Errorf("range[%x, %x] of module[%s] is error", min, max, name)
+	}
+	for _, m := range r.modules {
+		if min <= m.Max && m.Min <= max {
+			return nil, fmt.Errorf("range[%x, %x] of module[%s] overlaps module[%s]", min, max, name, m.Name)
+		}
+	}
+	for cmd := range r.handlers {
+		if min <= cmd && cmd <= max {
+			return nil, fmt.Errorf("range[%x, %x] of module[%s] contains registered cmd[%x]", min, max, name, cmd)
+		}
+	}
+	m := &RouterModule{Name: name, Min: min, Max: max, router: r}
+	r.modules = append(r.modules, m)
+	return m, nil
+}
+
+// 设置未注册命令的处理函数，默认记录错误日志
+func (r *Router) SetFallback(h func(int32, IPacket, IClient)) {
+	r.fallback = h
+}
+
+// 设置状态消息的处理函数，参数为状态、断线原因(CLOSE_REASON_*，只有STATE_CLOSED时有意义)和客户端
+func (r *Router) SetStateHandler(h func(state int32, reason int32, client IClient)) {
+	r.onState = h
+}
+
+func (r *Router) findModule(cmd int32) *RouterModule {
+	for _, m := range r.modules {
+		if m.Min <= cmd && cmd <= m.Max {
+			return m
+		}
+	}
+	return nil
+}
+
+// 实现 IHandler
+func (r *Router) HandleTimer(message IMessage) {
+	id := (message.Data()).(int32)
+	handler := (message.Args()).(ITimerHandler)
+	handler.DoTimerAction(id)
+}
+
+func (r *Router) HandleNet(message IMessage) {
+	data := (message.Data()).([]byte)
+	c := (message.Args()).(IClient)
+
+	p := r.factory.NewPacket()
+	p.Refer(data)
+	cmd := r.extractor(p)
+	if h, ok := r.handlers[cmd]; ok {
+		h(p, c)
+		return
+	}
+	if m := r.findModule(cmd); nil != m && nil != m.fallback {
+		m.fallback(p, c)
+		return
+	}
+	r.fallback(cmd, p, c)
+}
+
+func (r *Router) HandleState(message IMessage) {
+	if nil == r.onState {
+		return
+	}
+	state := (message.Data()).(int32)
+	c := (message.Args()).(IClient)
+	r.onState(state, stateReason(message), c)
+}
+
+// 注册指定数据包类型的处理函数，处理函数中不需要再做类型断言。
+// T必须是Router的IPacketFactory创建的数据包类型，否则返回错误
+func Handle[T IPacket](r *Router, cmd int32, h func(T, IClient)) error {
+	handler, err := typedHandler(r, h)
+	if nil != err {
+		return err
+	}
+	return r.Register(cmd, handler)
+}
+
+// 在模块内注册指定数据包类型的处理函数，见Handle
+func HandleModule[T IPacket](m *RouterModule, cmd int32, h func(T, IClient)) error {
+	handler, err := typedHandler(m.router, h)
+	if nil != err {
+		return err
+	}
+	return m.Register(cmd, handler)
+}
+
+func typedHandler[T IPacket](r *Router, h func(T, IClient)) (RouteHandler, error) {
+	p := r.factory.NewPacket()
+	if _, ok := p.(T); !ok {
+		return nil, fmt.Errorf("factory creates %T, not %s", p, reflect.TypeOf((*T)(nil)).Elem())
+	}
+	return func(p IPacket, c IClient) {
+		h(p.(T), c)
+	}, nil
+}
+
+// 在模块内注册命令处理函数，命令字不在模块范围内时返回错误
+func (m *RouterModule) Register(cmd int32, h RouteHandler) error {
+	if cmd < m.Min || cmd > m.Max {
+		return fmt.Errorf("cmd[%x] out of range[%x, %x] of module[%s]", cmd, m.Min, m.Max, m.Name)
+	}
+	return m.router.register(cmd, h)
+}
+
+// 设置模块内未注册命令的处理函数，为空时使用Router的处理函数
+func (m *RouterModule) SetFallback(h RouteHandler) {
+	m.fallback = h
+}
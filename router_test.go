@@ -0,0 +1,187 @@
+package solidnet
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// 和testFactory创建的数据包类型不同
+type otherPacket struct {
+	BasePacket
+}
+
+func testCmd(p IPacket) int32 {
+	return int32(binary.LittleEndian.Uint16(p.GetData()[2:]))
+}
+
+func TestHandleTyped(t *testing.T) {
+	r := NewRouter(testFactory{}, testCmd)
+	var got *BasePacket
+	if err := Handle(r, 7, func(p *BasePacket, c IClient) {
+		got = p
+	}); nil != err {
+		t.Fatal(err)
+	}
+	m, err := r.RegisterModule("test", 100, 199)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var gotModule *BasePacket
+	if err := HandleModule(m, 100, func(p *BasePacket, c IClient) {
+		gotModule = p
+	}); nil != err {
+		t.Fatal(err)
+	}
+
+	c := NewConnector("test", nil, testFactory{})
+	r.HandleNet(&NetMessage{testPacket(7, 2), c})
+	if nil == got || 7 != testCmd(got) {
+		t.Fatalf("typed handler of cmd 7 is not called")
+	}
+	r.HandleNet(&NetMessage{testPacket(100, 2), c})
+	if nil == gotModule || 100 != testCmd(gotModule) {
+		t.Fatalf("typed handler of cmd 100 is not called")
+	}
+}
+
+func TestHandleTypeMismatch(t *testing.T) {
+	r := NewRouter(testFactory{}, testCmd)
+	if err := Handle(r, 7, func(p *otherPacket, c IClient) {}); nil == err {
+		t.Fatal("handler of another packet type is registered")
+	}
+	m, _ := r.RegisterModule("test", 100, 199)
+	if err := HandleModule(m, 100, func(p *otherPacket, c IClient) {}); nil == err {
+		t.Fatal("handler of another packet type is registered in module")
+	}
+	// 注册失败的命令字可以重新注册
+	if err := Handle(r, 7, func(p *BasePacket, c IClient) {}); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestStateHandlerReason(t *testing.T) {
+	r := NewRouter(testFactory{}, testCmd)
+	var gotState, gotReason, ctxReason int32
+	r.SetStateHandler(func(state int32, reason int32, c IClient) {
+		gotState, gotReason = state, reason
+	})
+	chain := NewHandlerChain(r, func(ctx *MessageContext, next func()) {
+		ctxReason = ctx.Reason
+		next()
+	})
+
+	c := NewConnector("test", nil, testFactory{})
+	chain.HandleState(&StateMessage{STATE_CLOSED, c, CLOSE_REASON_DUPLICATE_LOGIN})
+	if STATE_CLOSED != gotState || CLOSE_REASON_DUPLICATE_LOGIN != gotReason {
+		t.Fatalf("state handler got state[%d] reason[%d]", gotState, gotReason)
+	}
+	if CLOSE_REASON_DUPLICATE_LOGIN != ctxReason {
+		t.Fatalf("middleware got reason[%d]", ctxReason)
+	}
+}
+
+// 按命令字记录处理次数
+func newCountRouter() (*Router, map[int32]int) {
+	r := NewRouter(testFactory{}, testCmd)
+	handled := make(map[int32]int)
+	r.SetFallback(func(cmd int32, p IPacket, c IClient) {
+		handled[cmd]++
+	})
+	return r, handled
+}
+
+func TestHandlerChainOrder(t *testing.T) {
+	r, handled := newCountRouter()
+	var order []string
+	record := func(name string) Middleware {
+		return func(ctx *MessageContext, next func()) {
+			order = append(order, name+">")
+			next()
+			order = append(order, "<"+name)
+		}
+	}
+	// 按注册的顺序执行，Use追加的在最后
+	chain := NewHandlerChain(r, record("a"), record("b"))
+	chain.Use(record("c"))
+
+	c := NewConnector("test", nil, testFactory{})
+	chain.HandleNet(&NetMessage{testPacket(7, 0), c})
+	want := []string{"a>", "b>", "c>", "<c", "<b", "<a"}
+	if len(want) != len(order) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	for i := range want {
+		if want[i] != order[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+	if 1 != handled[7] {
+		t.Fatal("handler is not called")
+	}
+}
+
+func TestHandlerChainShortCircuit(t *testing.T) {
+	r, handled := newCountRouter()
+	var after bool
+	// 不调用next时，后面的中间件和处理函数都不执行
+	chain := NewHandlerChain(r, func(ctx *MessageContext, next func()) {
+		if 7 == packetCmd(testFactory{}, testCmd, ctx.Data) {
+			return
+		}
+		next()
+	}, func(ctx *MessageContext, next func()) {
+		after = true
+		next()
+	})
+
+	c := NewConnector("test", nil, testFactory{})
+	chain.HandleNet(&NetMessage{testPacket(7, 0), c})
+	if after || 0 != handled[7] {
+		t.Fatal("chain is not interrupted")
+	}
+	chain.HandleNet(&NetMessage{testPacket(8, 0), c})
+	if !after || 1 != handled[8] {
+		t.Fatal("chain is interrupted")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	r, handled := newCountRouter()
+	clock := NewFakeClock(time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC))
+	chain := NewHandlerChain(r, RateLimitMiddlewareWithClock(testFactory{}, testCmd, 2, 3, clock))
+	c := NewConnector("a", nil, testFactory{})
+	other := NewConnector("b", nil, testFactory{})
+
+	// 突发burst个，超出的丢弃
+	for i := 0; i < 5; i++ {
+		chain.HandleNet(&NetMessage{testPacket(7, 0), c})
+	}
+	if 3 != handled[7] {
+		t.Fatalf("handled %d, want burst 3", handled[7])
+	}
+	// 不同的命令字和不同的客户端各自一个令牌桶
+	chain.HandleNet(&NetMessage{testPacket(8, 0), c})
+	chain.HandleNet(&NetMessage{testPacket(7, 0), other})
+	if 1 != handled[8] || 4 != handled[7] {
+		t.Fatalf("handled cmd 8 %d times and cmd 7 %d times, want 1 and 4", handled[8], handled[7])
+	}
+
+	// 每秒补充rate个
+	clock.Advance(time.Second)
+	for i := 0; i < 5; i++ {
+		chain.HandleNet(&NetMessage{testPacket(7, 0), c})
+	}
+	if 6 != handled[7] {
+		t.Fatalf("handled %d, want 2 more after a second", handled[7]-4)
+	}
+
+	// 断线后清理该客户端的令牌桶，之后重新按burst计数
+	chain.HandleState(&StateMessage{STATE_CLOSED, c, CLOSE_REASON_NONE})
+	for i := 0; i < 5; i++ {
+		chain.HandleNet(&NetMessage{testPacket(7, 0), c})
+	}
+	if 9 != handled[7] {
+		t.Fatalf("handled %d, want burst 3 after the bucket is cleaned", handled[7]-6)
+	}
+}
@@ -0,0 +1,176 @@
+package solidnet
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	SCHEDULE_CHECK_INTERVAL = 60 // 最长检查间隔，秒。定时器按单调时钟计时，定期对照墙上时间，避免系统休眠或者调整时间后触发不准
+	SCHEDULE_MAX_MISSED     = 10000
+)
+
+// 按墙上时间触发的定时任务调度器，支持cron表达式和绝对时间，
+// 和普通定时器一样通过TimerMessage在逻辑协程中回调ITimerHandler
+type Scheduler struct {
+	mutex     sync.Mutex
+	loc       *time.Location
+	processor IProcessor
+	clock     IClock
+	jobs      map[int32]*scheduleJob
+	onMissed  func(id int32, missed int, scheduled time.Time)
+}
+
+type scheduleJob struct {
+	id        int32
+	scheduler *Scheduler
+	schedule  ISchedule
+	handler   ITimerHandler
+	timer     *Timer
+	next      time.Time // 下一次触发的时间
+}
+
+// 创建调度器，loc为cron表达式使用的时区，为空时使用time.Local
+func NewScheduler(p IProcessor, loc *time.Location) *Scheduler {
+	return NewSchedulerWithClock(p, loc, RealClock)
+}
+
+// 创建使用指定时钟的调度器，测试时可以使用FakeClock
+func NewSchedulerWithClock(p IProcessor, loc *time.Location, clock IClock) *Scheduler {
+	s := new(Scheduler)
+	s.clock = clock
+	if nil == loc {
+		loc = time.Local
+	}
+	s.loc = loc
+	s.processor = p
+	s.jobs = make(map[int32]*scheduleJob)
+	return s
+}
+
+// 设置错过触发时的回调，进程暂停或者系统休眠后，错过的多次触发只回调一次ITimerHandler，
+// 并通过该回调报告错过的次数和第一次错过的时间，在逻辑协程中调用
+func (s *Scheduler) SetMissedHandler(h func(id int32, missed int, scheduled time.Time)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onMissed = h
+}
+
+// 按cron表达式添加定时任务，例如"0 5 * * *"每天5点，"0 0 * * MON"每周一0点
+func (s *Scheduler) AddCron(id int32, expr string, h ITimerHandler) error {
+	schedule, err := ParseCron(expr, s.loc)
+	if nil != err {
+		return err
+	}
+	return s.Add(id, schedule, h)
+}
+
+// 添加在绝对时间触发一次的定时任务
+func (s *Scheduler) AddAt(id int32, at time.Time, h ITimerHandler) error {
+	if !at.After(s.clock.Now()) {
+		return errors.New("time already passed")
+	}
+	return s.Add(id, &AtSchedule{at}, h)
+}
+
+// 添加定时任务，id已经存在时替换原来的任务
+func (s *Scheduler) Add(id int32, schedule ISchedule, h ITimerHandler) error {
+	next := schedule.Next(s.clock.Now())
+	if next.IsZero() {
+		return errors.New("schedule never fires")
+	}
+
+	job := &scheduleJob{id: id, scheduler: s, schedule: schedule, handler: h, next: next}
+	job.timer = NewTimerWithClock(id, job, s.processor, s.clock)
+
+	s.mutex.Lock()
+	old := s.jobs[id]
+	s.jobs[id] = job
+	s.mutex.Unlock()
+
+	if nil != old {
+		old.timer.Stop()
+	}
+	job.arm(s.clock.Now())
+	return nil
+}
+
+// 删除定时任务
+func (s *Scheduler) Remove(id int32) {
+	s.mutex.Lock()
+	job := s.jobs[id]
+	delete(s.jobs, id)
+	s.mutex.Unlock()
+
+	if nil != job {
+		job.timer.Stop()
+	}
+}
+
+// 下一次触发的时间，任务不存在时返回零值
+func (s *Scheduler) Next(id int32) time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		return job.next
+	}
+	return time.Time{}
+}
+
+// 启动定时器，最长等待SCHEDULE_CHECK_INTERVAL后重新对照墙上时间
+func (j *scheduleJob) arm(now time.Time) {
+	wait := j.next.Sub(now)
+	if wait > time.Second*SCHEDULE_CHECK_INTERVAL {
+		wait = time.Second * SCHEDULE_CHECK_INTERVAL
+	}
+	j.timer.Start(wait, false)
+}
+
+// 实现 ITimerHandler，在逻辑协程中调用
+func (j *scheduleJob) DoTimerAction(id int32) {
+	s := j.scheduler
+	s.mutex.Lock()
+	current := s.jobs[id] == j
+	onMissed := s.onMissed
+	s.mutex.Unlock()
+	if !current {
+		// 已经被删除或者替换
+		return
+	}
+
+	// j.next只在逻辑协程中修改，这里读取不需要加锁
+	now := s.clock.Now()
+	if now.Before(j.next) {
+		// 还没有到触发时间，继续等待
+		j.arm(now)
+		return
+	}
+
+	// 统计错过的触发次数
+	scheduled := j.next
+	missed := 0
+	next := j.schedule.Next(j.next)
+	for !next.IsZero() && !next.After(now) && missed < SCHEDULE_MAX_MISSED {
+		missed++
+		next = j.schedule.Next(next)
+	}
+	if missed > 0 && nil != onMissed {
+		onMissed(id, missed, scheduled)
+	}
+
+	j.handler.DoTimerAction(id)
+
+	if next.IsZero() {
+		s.mutex.Lock()
+		if s.jobs[id] == j {
+			delete(s.jobs, id)
+		}
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Lock()
+	j.next = next
+	s.mutex.Unlock()
+	j.arm(s.clock.Now())
+}
@@ -0,0 +1,149 @@
+package solidnet
+
+import (
+	"testing"
+	"time"
+)
+
+// 记录触发的时间
+type recordTimerHandler struct {
+	clock IClock
+	fired []time.Time
+}
+
+func (h *recordTimerHandler) DoTimerAction(id int32) {
+	h.fired = append(h.fired, h.clock.Now())
+}
+
+// 按step推进时钟d，每一步之后在当前协程中处理超时消息，代替逻辑协程
+func advanceScheduler(clock *FakeClock, p *ChannelProcessor, d time.Duration, step time.Duration) {
+	for end := clock.Now().Add(d); clock.Now().Before(end); {
+		clock.Advance(step)
+		for 0 != len(p.messageChannel) {
+			m := p.Epoll().(*TimerMessage)
+			if m.IsValid() {
+				m.handler.DoTimerAction(m.id)
+			}
+		}
+	}
+}
+
+func newTestScheduler(start time.Time, loc *time.Location) (*Scheduler, *FakeClock, *ChannelProcessor) {
+	clock := NewFakeClock(start)
+	p := NewChannelProcessorWithLen(100)
+	return NewSchedulerWithClock(p, loc, clock), clock, p
+}
+
+func TestSchedulerAt(t *testing.T) {
+	start := time.Date(2024, 9, 1, 10, 0, 0, 0, time.UTC)
+	s, clock, p := newTestScheduler(start, time.UTC)
+	h := &recordTimerHandler{clock: clock}
+	if nil == s.AddAt(1, start, h) {
+		t.Fatal("time already passed is accepted")
+	}
+	at := start.Add(150 * time.Second)
+	if err := s.AddAt(1, at, h); nil != err {
+		t.Fatal(err)
+	}
+	if !s.Next(1).Equal(at) {
+		t.Fatalf("next at %s, want %s", s.Next(1), at)
+	}
+
+	advanceScheduler(clock, p, 10*time.Minute, time.Second)
+	if 1 != len(h.fired) || h.fired[0].Before(at) || h.fired[0].Sub(at) > time.Second {
+		t.Fatalf("fired at %v, want once at %s", h.fired, at)
+	}
+	// 只触发一次，触发后删除
+	if !s.Next(1).IsZero() {
+		t.Fatal("job is not removed after firing")
+	}
+}
+
+func TestSchedulerCronAndRemove(t *testing.T) {
+	start := time.Date(2024, 9, 1, 10, 0, 30, 0, time.UTC)
+	s, clock, p := newTestScheduler(start, time.UTC)
+	h := &recordTimerHandler{clock: clock}
+	if nil == s.AddCron(1, "* * * *", h) {
+		t.Fatal("invalid cron is accepted")
+	}
+	if err := s.AddCron(1, "*/10 * * * *", h); nil != err {
+		t.Fatal(err)
+	}
+	advanceScheduler(clock, p, 35*time.Minute, time.Second)
+	if 3 != len(h.fired) {
+		t.Fatalf("fired %d times in 35 minutes, want 3", len(h.fired))
+	}
+	for i, fired := range h.fired {
+		want := time.Date(2024, 9, 1, 10, 10*(i+1), 0, 0, time.UTC)
+		if fired.Before(want) || fired.Sub(want) > time.Second {
+			t.Fatalf("firing %d at %s, want %s", i, fired, want)
+		}
+	}
+
+	s.Remove(1)
+	advanceScheduler(clock, p, time.Hour, time.Minute)
+	if 3 != len(h.fired) {
+		t.Fatal("removed job fires")
+	}
+}
+
+func TestSchedulerMissed(t *testing.T) {
+	start := time.Date(2024, 9, 1, 0, 30, 0, 0, time.UTC)
+	s, clock, p := newTestScheduler(start, time.UTC)
+	h := &recordTimerHandler{clock: clock}
+	var missed int
+	var scheduled time.Time
+	s.SetMissedHandler(func(id int32, n int, at time.Time) {
+		missed = n
+		scheduled = at
+	})
+	if err := s.AddCron(1, "0 * * * *", h); nil != err {
+		t.Fatal(err)
+	}
+
+	// 时钟一次跳过5个小时，错过的01:00到05:00只回调一次，报告错过4次
+	advanceScheduler(clock, p, 5*time.Hour, 5*time.Hour)
+	if 1 != len(h.fired) {
+		t.Fatalf("fired %d times after the jump, want 1", len(h.fired))
+	}
+	if 4 != missed || !scheduled.Equal(time.Date(2024, 9, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Fatalf("missed %d from %s, want 4 from 01:00", missed, scheduled)
+	}
+	if want := time.Date(2024, 9, 1, 6, 0, 0, 0, time.UTC); !s.Next(1).Equal(want) {
+		t.Fatalf("next at %s, want %s", s.Next(1), want)
+	}
+}
+
+func TestSchedulerDaylightSaving(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+	for _, c := range []struct {
+		expr  string
+		start time.Time
+		want  time.Time
+		next  time.Time
+	}{
+		// 02:00跳到03:00，跳过的02:30在03:00触发一次
+		{"30 2 * * *", time.Date(2024, 3, 10, 1, 50, 0, 0, ny),
+			time.Date(2024, 3, 10, 3, 0, 0, 0, ny), time.Date(2024, 3, 11, 2, 30, 0, 0, ny)},
+		// 02:00回到01:00，重复的01:30只在第一次触发
+		{"30 1 * * *", time.Date(2024, 11, 3, 5, 0, 0, 0, time.UTC),
+			time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), time.Date(2024, 11, 4, 1, 30, 0, 0, ny)},
+	} {
+		s, clock, p := newTestScheduler(c.start, ny)
+		h := &recordTimerHandler{clock: clock}
+		if err := s.AddCron(1, c.expr, h); nil != err {
+			t.Fatal(err)
+		}
+		// 推进3个小时，越过切换和重复的时段
+		advanceScheduler(clock, p, 3*time.Hour, time.Minute)
+		if 1 != len(h.fired) {
+			t.Fatalf("%s: fired %v, want once at %s", c.expr, h.fired, c.want)
+		}
+		if fired := h.fired[0]; fired.Before(c.want) || fired.Sub(c.want) > time.Minute {
+			t.Fatalf("%s: fired at %s, want %s", c.expr, fired.In(ny), c.want.In(ny))
+		}
+		if !s.Next(1).Equal(c.next) {
+			t.Fatalf("%s: next at %s, want %s", c.expr, s.Next(1).In(ny), c.next.In(ny))
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package solidnet
+
+import (
+	"sync"
+	"sync/atomic"
+
+	logger "github.com/idakun/tinylog"
+)
+
+// 连接id，进程内唯一，从1开始递增
+var clientIdSeq uint64
+
+func nextClientId() uint64 {
+	return atomic.AddUint64(&clientIdSeq, 1)
+}
+
+// 连接id
+func (c *BaseClient) GetId() uint64 {
+	return c.id
+}
+
+// 绑定用户id，0表示解除绑定。服务端按用户id建立索引，见GetClientByUserId，
+// 同一个用户id重复登录时按DuplicateLogin的策略处理，返回false表示被拒绝，连接随后会被关闭
+func (c *BaseClient) BindUserId(userId int64) bool {
+	c.mutex.Lock()
+	old := c.userId
+	onBind := c.onBind
+	c.mutex.Unlock()
+
+	if old == userId {
+		return true
+	}
+	if nil != onBind && !onBind(userId) {
+		return false
+	}
+	c.mutex.Lock()
+	c.userId = userId
+	c.mutex.Unlock()
+	return true
+}
+
+// 绑定的用户id，未绑定时为0
+func (c *BaseClient) GetUserId() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.userId
+}
+
+// 设置属性，可以在任意协程中调用
+func (c *BaseClient) Set(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nil == c.attributes {
+		c.attributes = make(map[string]interface{})
+	}
+	c.attributes[key] = value
+}
+
+func (c *BaseClient) Get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value, ok := c.attributes[key]
+	return value, ok
+}
+
+func (c *BaseClient) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.attributes, key)
+}
+
+// 设置绑定用户id时的回调，由服务端用来维护用户id索引
+func (c *BaseClient) setOnBind(f func(userId int64) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onBind = f
+}
+
+// 把other的属性复制过来，已经存在的属性不覆盖
+func (c *BaseClient) takeAttributes(other *BaseClient) {
+	other.mutex.Lock()
+	attributes := make(map[string]interface{}, len(other.attributes))
+	for key, value := range other.attributes {
+		attributes[key] = value
+	}
+	other.mutex.Unlock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nil == c.attributes {
+		c.attributes = make(map[string]interface{})
+	}
+	for key, value := range attributes {
+		if _, ok := c.attributes[key]; !ok {
+			c.attributes[key] = value
+		}
+	}
+}
+
+// 重复登录的处理策略
+const (
+	DUPLICATE_LOGIN_KICK_OLD   = 0 // 踢掉旧的连接，新的连接接管会话
+	DUPLICATE_LOGIN_REJECT_NEW = 1 // 拒绝新的登录，关闭新的连接
+)
+
+// 重复登录的配置
+type DuplicateLogin struct {
+	Policy     int
+	Builder    func(f IPacketFactory, reason int32) []byte // 构造发给被关闭客户端的通知包，为空时不通知
+	OnTakeover func(old IClient, new IClient)              // 踢掉旧连接后的回调，在调用BindUserId的协程中调用
+}
+
+func (d *DuplicateLogin) notify(c *TcpClient, reason int32) []byte {
+	if nil == d.Builder {
+		return nil
+	}
+	return d.Builder(c.factory, reason)
+}
+
+// 按连接id和用户id查找客户端的索引，Game中的TcpServer、WsServer和UdpServer共用一个索引，
+// 用户通过不同的传输层重复登录时也可以检测到
+type clientIndex struct {
+	DuplicateLogin *DuplicateLogin // 重复登录的处理，为空时新的连接覆盖索引，旧的连接不受影响
+
+	indexMutex sync.Mutex
+	byId       map[uint64]*indexEntry
+	byUserId   map[int64]*TcpClient
+}
+
+type indexEntry struct {
+	client *TcpClient
+	userId int64 // 在索引中绑定的用户id
+}
+
+func newClientIndex() *clientIndex {
+	x := new(clientIndex)
+	x.byId = make(map[uint64]*indexEntry)
+	x.byUserId = make(map[int64]*TcpClient)
+	return x
+}
+
+func (x *clientIndex) addIndex(client *TcpClient) {
+	x.indexMutex.Lock()
+	x.byId[client.GetId()] = &indexEntry{client: client}
+	x.indexMutex.Unlock()
+
+	client.setOnBind(func(userId int64) bool {
+		return x.bindIndex(client, userId)
+	})
+	// 加入索引之前已经绑定了用户id
+	if userId := client.GetUserId(); 0 != userId {
+		x.bindIndex(client, userId)
+	}
+}
+
+func (x *clientIndex) delIndex(client *TcpClient) {
+	client.setOnBind(nil)
+
+	x.indexMutex.Lock()
+	defer x.indexMutex.Unlock()
+	e, ok := x.byId[client.GetId()]
+	if !ok {
+		return
+	}
+	delete(x.byId, client.GetId())
+	if 0 != e.userId && x.byUserId[e.userId] == client {
+		delete(x.byUserId, e.userId)
+	}
+}
+
+// 在索引中绑定用户id，返回false表示按DUPLICATE_LOGIN_REJECT_NEW拒绝了该连接
+func (x *clientIndex) bindIndex(client *TcpClient, userId int64) bool {
+	x.indexMutex.Lock()
+	e, ok := x.byId[client.GetId()]
+	if !ok {
+		// 已经从索引中删除
+		x.indexMutex.Unlock()
+		return true
+	}
+
+	var old *TcpClient
+	if 0 != userId {
+		old = x.byUserId[userId]
+	}
+	if old == client || (nil != old && CLOSE_REASON_NONE != old.getCloseReason()) {
+		// 旧的连接已经在关闭
+		old = nil
+	}
+	d := x.DuplicateLogin
+	if nil != old && nil != d && DUPLICATE_LOGIN_REJECT_NEW == d.Policy {
+		x.indexMutex.Unlock()
+		logger.Error("user[%d] already login on client[%s], reject client[%s]", userId, old.RemoteAddr(), client.RemoteAddr())
+		client.Kick(CLOSE_REASON_LOGIN_REJECTED, d.notify(client, CLOSE_REASON_LOGIN_REJECTED))
+		return false
+	}
+
+	if 0 != e.userId && x.byUserId[e.userId] == client {
+		delete(x.byUserId, e.userId)
+	}
+	e.userId = userId
+	if 0 != userId {
+		x.byUserId[userId] = client
+	}
+	x.indexMutex.Unlock()
+
+	if nil != old && nil != d {
+		logger.Error("user[%d] login on client[%s], kick client[%s]", userId, client.RemoteAddr(), old.RemoteAddr())
+		client.takeAttributes(old.BaseClient)
+		old.Kick(CLOSE_REASON_DUPLICATE_LOGIN, d.notify(old, CLOSE_REASON_DUPLICATE_LOGIN))
+		if nil != d.OnTakeover {
+			d.OnTakeover(old, client)
+		}
+	}
+	return true
+}
+
+// 按连接id查找客户端，不存在时返回nil
+func (x *clientIndex) GetClient(id uint64) *TcpClient {
+	x.indexMutex.Lock()
+	defer x.indexMutex.Unlock()
+	if e, ok := x.byId[id]; ok {
+		return e.client
+	}
+	return nil
+}
+
+// 按用户id查找客户端，不存在时返回nil
+func (x *clientIndex) GetClientByUserId(userId int64) *TcpClient {
+	x.indexMutex.Lock()
+	defer x.indexMutex.Unlock()
+	return x.byUserId[userId]
+}
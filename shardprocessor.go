@@ -0,0 +1,128 @@
+package solidnet
+
+import (
+	"reflect"
+	"time"
+
+	logger "github.com/idakun/tinylog"
+)
+
+// 多事件循环的处理器，Game对每个事件循环启动一个逻辑协程
+type IMultiProcessor interface {
+	IProcessor
+	Loops() int
+	EpollLoop(i int) IMessage
+}
+
+// 指定了分片键的消息
+type IKeyedMessage interface {
+	IMessage
+	ShardKey() uint64
+}
+
+// 分片处理器：N个事件循环，消息按键路由到固定的事件循环，
+// 同一个键的消息保持顺序，不同键的消息并行处理。
+// 注意：IHandler会在多个协程中同时被调用，业务层必须保证并发安全
+type ShardedProcessor struct {
+	shards  []chan IMessage
+	KeyFunc func(IMessage) uint64 // 取消息的键，默认实现见defaultShardKey
+	Timeout time.Duration         // 网络消息在队列已满时的等待时间
+	Clock   IClock
+}
+
+func NewShardedProcessor(n int) *ShardedProcessor {
+	if n <= 0 {
+		n = 1
+	}
+	p := new(ShardedProcessor)
+	p.shards = make([]chan IMessage, n)
+	for i := range p.shards {
+		p.shards[i] = make(chan IMessage, defaultOptions.channelLen())
+	}
+	p.KeyFunc = defaultShardKey
+	p.Timeout = defaultOptions.sendTimeout()
+	p.Clock = RealClock
+	return p
+}
+
+// 默认的键：IKeyedMessage使用自身的键，网络和状态消息按客户端，
+// 定时器消息按定时器的处理者，所以同一个TcpClient的消息都在同一个事件循环
+func defaultShardKey(message IMessage) uint64 {
+	if m, ok := message.(IKeyedMessage); ok {
+		return m.ShardKey()
+	}
+	v := reflect.ValueOf(message.Args())
+	if reflect.Ptr == v.Kind() {
+		return uint64(v.Pointer())
+	}
+	return 0
+}
+
+// 键对应的事件循环
+func (p *ShardedProcessor) Shard(key uint64) int {
+	// 打散键，指针地址的低位都是0
+	key *= 0x9E3779B97F4A7C15
+	return int((key >> 32) % uint64(len(p.shards)))
+}
+
+func (p *ShardedProcessor) Loops() int {
+	return len(p.shards)
+}
+
+func (p *ShardedProcessor) Dispatch(message IMessage) {
+	if _, ok := message.(*quitMessage); ok {
+		// 退出消息通知所有的事件循环
+		for i := range p.shards {
+			p.Post(i, message)
+		}
+		return
+	}
+	p.Post(p.Shard(p.KeyFunc(message)), message)
+}
+
+// 按指定的键投递消息，可以在任意协程中调用
+func (p *ShardedProcessor) DispatchKey(key uint64, message IMessage) {
+	p.Post(p.Shard(key), message)
+}
+
+// 投递消息到指定的事件循环，可以在任意协程中调用。
+// 只有网络消息会在超时后丢弃，其他消息丢失会导致状态不一致，一直等待
+func (p *ShardedProcessor) Post(shard int, message IMessage) {
+	if !isSheddable(message) {
+		p.shards[shard] <- message
+		return
+	}
+	timer := p.Clock.NewTimer(p.Timeout)
+	defer timer.Stop()
+	select {
+	case p.shards[shard] <- message:
+
+	case <-timer.C():
+		//超时，导致数据丢弃
+		logger.Error("send to shard[%d] channel timeout!!!", shard)
+	}
+}
+
+// 实现 offerer
+func (p *ShardedProcessor) offer(message IMessage) bool {
+	select {
+	case p.shards[p.Shard(p.KeyFunc(message))] <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *ShardedProcessor) EpollLoop(i int) IMessage {
+	return <-p.shards[i]
+}
+
+// 兼容单协程的用法，从所有事件循环中取消息
+func (p *ShardedProcessor) Epoll() IMessage {
+	cases := make([]reflect.SelectCase, len(p.shards))
+	for i, shard := range p.shards {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(shard)}
+	}
+	_, value, _ := reflect.Select(cases)
+	return value.Interface().(IMessage)
+}
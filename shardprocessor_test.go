@@ -0,0 +1,134 @@
+package solidnet
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 取出事件循环i中已有的消息
+func drainShard(p *ShardedProcessor, i int) []IMessage {
+	var messages []IMessage
+	for 0 != len(p.shards[i]) {
+		messages = append(messages, p.EpollLoop(i))
+	}
+	return messages
+}
+
+func TestShardedProcessorClientOrder(t *testing.T) {
+	p := NewShardedProcessor(4)
+	clients := make([]IClient, 8)
+	for i := range clients {
+		clients[i] = NewConnector(fmt.Sprintf("%d", i), nil, testFactory{})
+	}
+	// 交替投递各个客户端的消息
+	for seq := 0; seq < 20; seq++ {
+		for _, c := range clients {
+			p.Dispatch(&NetMessage{testPacket(seq, 0), c})
+		}
+	}
+
+	// 同一个客户端的消息都在同一个事件循环中，并且保持投递的顺序
+	shardOf := make(map[IClient]int)
+	next := make(map[IClient]int32)
+	for i := 0; i < p.Loops(); i++ {
+		for _, m := range drainShard(p, i) {
+			c := m.Args().(IClient)
+			if shard, ok := shardOf[c]; ok && shard != i {
+				t.Fatalf("client[%s] is in shard %d and %d", c.RemoteAddr(), shard, i)
+			}
+			shardOf[c] = i
+			if seq := testCmd(&BasePacket{Data: m.(*NetMessage).packet}); next[c] != seq {
+				t.Fatalf("client[%s] got message %d, want %d", c.RemoteAddr(), seq, next[c])
+			}
+			next[c]++
+		}
+	}
+	for _, c := range clients {
+		if 20 != next[c] {
+			t.Fatalf("client[%s] got %d messages, want 20", c.RemoteAddr(), next[c])
+		}
+	}
+}
+
+func TestShardedProcessorStateAfterNet(t *testing.T) {
+	p := NewShardedProcessor(4)
+	a := NewConnector("a", nil, testFactory{})
+	connected := &StateMessage{STATE_CONNECTED, a, CLOSE_REASON_NONE}
+	a1 := &NetMessage{testPacket(1, 0), a}
+	a2 := &NetMessage{testPacket(2, 0), a}
+	closed := &StateMessage{STATE_CLOSED, a, CLOSE_REASON_NONE}
+	for _, m := range []IMessage{connected, a1, a2, closed} {
+		p.Dispatch(m)
+	}
+
+	// 状态消息和网络消息在同一个事件循环，断线通知排在之前的网络消息之后
+	got := drainShard(p, p.Shard(p.KeyFunc(a1)))
+	want := []IMessage{connected, a1, a2, closed}
+	if len(want) != len(got) {
+		t.Fatalf("got %d messages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if want[i] != got[i] {
+			t.Fatalf("message %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestShardedProcessorQuit(t *testing.T) {
+	p := NewShardedProcessor(4)
+	p.Dispatch(&quitMessage{})
+	// 退出消息通知所有的事件循环
+	for i := 0; i < p.Loops(); i++ {
+		messages := drainShard(p, i)
+		if 1 != len(messages) {
+			t.Fatalf("shard %d got %d messages, want 1", i, len(messages))
+		}
+		if _, ok := messages[0].(*quitMessage); !ok {
+			t.Fatalf("shard %d got %v", i, messages[0])
+		}
+	}
+}
+
+// 并发安全的计数处理者
+type countNetHandler struct {
+	mutex sync.Mutex
+	count int
+}
+
+func (h *countNetHandler) HandleTimer(message IMessage) {
+}
+
+func (h *countNetHandler) HandleNet(message IMessage) {
+	h.mutex.Lock()
+	h.count++
+	h.mutex.Unlock()
+}
+
+func (h *countNetHandler) HandleState(message IMessage) {
+}
+
+func TestShardedProcessorGameShutdown(t *testing.T) {
+	h := &countNetHandler{}
+	g := newTestGame(t, h)
+	p := NewShardedProcessor(4)
+	g.SetProcessor(p)
+	for i := 0; i < 100; i++ {
+		p.Dispatch(&NetMessage{testPacket(i, 0), NewConnector(fmt.Sprintf("%d", i), nil, testFactory{})})
+	}
+	go g.Run()
+
+	// 所有事件循环处理完退出消息之前的消息后退出
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := g.Shutdown(ctx); nil != err {
+		t.Fatal(err)
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if 100 != h.count {
+		t.Fatalf("handled %d messages before quit, want 100", h.count)
+	}
+}
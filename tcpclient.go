@@ -1,10 +1,12 @@
 package solidnet
 
 import (
-	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	logger "github.com/idakun/tinylog"
 )
 
 const (
@@ -20,18 +22,31 @@ type TcpClient struct {
 	*BaseClient
 	processor      IProcessor
 	stopWait       sync.WaitGroup
-	closeFlag      chan int32
-	loginFlag      bool
+	closeFlag      chan int32    // 状态协程投递STATE_CLOSED后关闭，通知其他协程退出
+	authNotified   chan struct{} // 状态协程已经投递STATE_AUTHENTICATED
+	loginFlag      int32
 	loginAuthTimer ITimer
+	auth           *Auth // 登录认证，为空时由业务层自己认证
+	authPackets    int   // 已经交给Authenticator的包数量，只在投递协程中访问
+}
+
+func NewTcpClient(conn net.Conn, p IProcessor, f IPacketFactory) *TcpClient {
+	return NewTcpClientWithClock(conn, p, f, RealClock)
 }
 
-func NewTcpClient(conn *net.TCPConn, p IProcessor, f IPacketFactory) *TcpClient {
+// 创建使用指定时钟的客户端，登录认证定时器和各种等待都使用该时钟
+func NewTcpClientWithClock(conn net.Conn, p IProcessor, f IPacketFactory, clock IClock) *TcpClient {
+	return newTcpClient(conn, p, f, clock, defaultOptions)
+}
+
+func newTcpClient(conn net.Conn, p IProcessor, f IPacketFactory, clock IClock, o *Options) *TcpClient {
 	c := &TcpClient{
-		BaseClient: NewBaseClient(conn, f),
-		processor:  p,
-		closeFlag:  make(chan int32),
+		BaseClient:   newBaseClient(conn, f, clock, o),
+		processor:    p,
+		closeFlag:    make(chan int32),
+		authNotified: make(chan struct{}, 1),
 	}
-	c.loginAuthTimer = NewTimer(EVENT_LOGIN_AUTH_TIMER, c)
+	c.loginAuthTimer = NewTimerWithClock(EVENT_LOGIN_AUTH_TIMER, c, p, clock)
 	return c
 }
 
@@ -39,22 +54,36 @@ func NewTcpClient(conn *net.TCPConn, p IProcessor, f IPacketFactory) *TcpClient
 func (c *TcpClient) DoTimerAction(id int32) {
 	switch id {
 	case EVENT_LOGIN_AUTH_TIMER:
-		// 如果没有登录验证，则关闭连接
+		// 如果没有登录验证，则和认证失败一样带原因关闭连接
 		if !c.GetLoginFlag() {
-			fmt.Printf("client[%s] loginauth timeout", c.RemoteAddr())
-			c.stop()
+			logger.Error("client[%s] login auth timeout, close it", c.RemoteAddr())
+			c.closeWithReason(CLOSE_REASON_AUTH_TIMEOUT)
 		}
 	default:
 
 	}
 }
 
+// 设置input队列的过载策略
+func (c *TcpClient) SetInputOverload(o *InputOverload) {
+	c.setInputOverload(o, c)
+}
+
+// 设置应用层心跳
+func (c *TcpClient) SetHeartbeat(h *Heartbeat) {
+	c.setHeartbeat(h)
+}
+
 func (c *TcpClient) SetLoginFlag(flag bool) {
-	c.loginFlag = flag
+	var value int32
+	if flag {
+		value = 1
+	}
+	atomic.StoreInt32(&c.loginFlag, value)
 }
 
 func (c *TcpClient) GetLoginFlag() bool {
-	return c.loginFlag
+	return 1 == atomic.LoadInt32(&c.loginFlag)
 }
 
 func (c *TcpClient) Run() {
@@ -69,15 +98,22 @@ func (c *TcpClient) dispatchNetMessage() {
 	defer c.stopWait.Done()
 	for {
 		var data []byte
+		timer := c.clock.NewTimer(time.Second * time.Duration(c.options.DispatchWaitTime))
 		select {
 		case data = <-c.input:
 		case <-c.closeFlag:
 			// 退出协程
+			timer.Stop()
 			return
-		case <-time.After(time.Second * DISPATCH_WAIT_TIME):
+		case <-timer.C():
 			continue
 		}
-		c.processor.Dispatch(&NetMessage{data, c})
+		timer.Stop()
+		if !c.authenticate(data) {
+			continue
+		}
+		// 处理器过载时按过载策略丢弃或者断开，不再等待
+		tryDispatch(c.processor, &NetMessage{data, c})
 	}
 }
 
@@ -85,19 +121,28 @@ func (c *TcpClient) dispatchStateMessage() {
 	defer c.stopWait.Done()
 	for {
 		var state int32
+		timer := c.clock.NewTimer(time.Second * time.Duration(c.options.DispatchWaitTime))
 		select {
 		case state = <-c.state:
-		case <-time.After(time.Second * DISPATCH_WAIT_TIME):
+		case <-timer.C():
 			continue
 		}
-		c.processor.Dispatch(&StateMessage{state, c})
+		timer.Stop()
+		reason := int32(CLOSE_REASON_NONE)
+		if STATE_CLOSED == state {
+			reason = c.getCloseReason()
+		}
+		c.processor.Dispatch(&StateMessage{state, c, reason})
 		switch state {
+		case STATE_AUTHENTICATED:
+			c.authNotified <- struct{}{}
 		case STATE_CONNECTED:
 			// 连接后，一定时间内进行登录认证，否则视为非法用户
-			c.loginAuthTimer.Start(MAX_LOGIN_AUTH_TIME*time.Second, false)
+			c.loginAuthTimer.Start(time.Duration(c.options.LoginAuthTime)*time.Second, false)
 		case STATE_CLOSED:
-			// 连接已经关闭，通知其他协程
-			c.closeFlag <- 1
+			// 连接已经关闭，停止登录认证定时器，通知其他协程
+			c.loginAuthTimer.Stop()
+			close(c.closeFlag)
 			return
 		}
 	}
@@ -1,8 +1,12 @@
 package solidnet
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"net"
 	"sync"
+	"time"
 
 	logger "github.com/idakun/tinylog"
 )
@@ -14,26 +18,75 @@ const (
 type TcpServer struct {
 	Addr         string
 	Clients      map[net.Conn]*TcpClient
+	*clientIndex // 按连接id和用户id查找客户端
 	clientsWait  sync.WaitGroup
 	lsn          *net.TCPListener
 	clientsMutex sync.Mutex
+	quit         chan struct{} // 关闭通知
+	quitOnce     sync.Once
+	closing      bool          // Shutdown已经开始关闭客户端，受clientsMutex保护
+	deadline     time.Time     // 关闭客户端时发送剩余数据的截止时间
+	listenDone   chan struct{} // 监听协程已经退出
+	tlsConfig    *TlsConfig    // 非空时启用tls
+	tlsServer    *tls.Config
 
-	Processor IProcessor
-	Factory   IPacketFactory
+	Processor     IProcessor
+	Factory       IPacketFactory
+	InputOverload *InputOverload // 客户端input队列的过载策略，为空时使用默认策略
+	Clock         IClock         // 客户端使用的时钟
+	Heartbeat     *Heartbeat     // 客户端的应用层心跳，为空时不发送ping
+	Auth          *Auth          // 客户端的登录认证，为空时由业务层自己认证
+	options       *Options
 }
 
-func NewTcpServer(addr string, processor IProcessor, f IPacketFactory) *TcpServer {
+func NewTcpServer(addr string, processor IProcessor, f IPacketFactory, opts ...Option) *TcpServer {
 	s := new(TcpServer)
+	s.options = NewOptions(opts...)
 	s.Addr = addr
 	s.Processor = processor
 	s.Factory = f
+	s.Clock = RealClock
+	s.clientIndex = newClientIndex()
 	s.Clients = make(map[net.Conn]*TcpClient)
+	s.quit = make(chan struct{})
+	s.listenDone = make(chan struct{})
 	return s
 }
 
+// 创建启用tls的服务端，产生的客户端和普通tcp客户端一样，业务层无需区分
+func NewTlsServer(addr string, config *TlsConfig, processor IProcessor, f IPacketFactory, opts ...Option) *TcpServer {
+	s := NewTcpServer(addr, processor, f, opts...)
+	s.tlsConfig = config
+	return s
+}
+
+// 重新加载tls证书，之后的新连接使用新证书
+func (s *TcpServer) ReloadCertificate() error {
+	if nil == s.tlsConfig {
+		return errors.New("tls is not enabled")
+	}
+	return s.tlsConfig.Reload()
+}
+
 func (s *TcpServer) Start() bool {
+	err := s.options.Validate()
+	if nil != err {
+		logger.Error("invalid options: %s", err.Error())
+		return false
+	}
+	if err := validateFactory(s.Factory); nil != err {
+		logger.Error("invalid packet factory: %s", err.Error())
+		return false
+	}
+	if nil != s.tlsConfig {
+		s.tlsServer, err = s.tlsConfig.ServerConfig()
+		if nil != err {
+			logger.Error("load tls config error: %s", err.Error())
+			return false
+		}
+	}
+
 	addr, _ := net.ResolveTCPAddr("tcp", s.Addr)
-	var err error
 	s.lsn, err = net.ListenTCP("tcp", addr)
 	if err != nil {
 		logger.Error("net.Listen() error: %s", err.Error())
@@ -45,37 +98,104 @@ func (s *TcpServer) Start() bool {
 
 func (s *TcpServer) AddClient(conn net.Conn, client *TcpClient) {
 	s.clientsMutex.Lock()
-	defer s.clientsMutex.Unlock()
 	s.Clients[conn] = client
+	if s.closing {
+		// Shutdown已经遍历过客户端，关闭期间才加入的客户端在这里关闭
+		client.Shutdown(s.deadline)
+	}
 	logger.Debug("num of clients is : %d", len(s.Clients))
+	s.clientsMutex.Unlock()
+	s.addIndex(client)
 }
 
 func (s *TcpServer) DelClient(conn net.Conn) {
 	s.clientsMutex.Lock()
-	defer s.clientsMutex.Unlock()
+	client, ok := s.Clients[conn]
 	delete(s.Clients, conn)
 	logger.Debug("num of clients is : %d", len(s.Clients))
+	s.clientsMutex.Unlock()
+	if ok {
+		s.delIndex(client)
+	}
+}
+
+// 优雅关闭：停止接受新连接，发送完每个客户端的剩余数据后断开连接，
+// 断线通知(STATE_CLOSED)照常通过IProcessor投递。所有客户端结束或者ctx到期后返回
+func (s *TcpServer) Shutdown(ctx context.Context) error {
+	s.quitOnce.Do(func() {
+		close(s.quit)
+		s.lsn.Close()
+	})
+	// 等待监听协程退出，之后不会再有新的客户端
+	select {
+	case <-s.listenDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	deadline, _ := ctx.Deadline()
+	s.clientsMutex.Lock()
+	s.closing = true
+	s.deadline = deadline
+	for _, client := range s.Clients {
+		client.Shutdown(deadline)
+	}
+	s.clientsMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.clientsWait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// 超时，强制关闭剩余的客户端
+		s.clientsMutex.Lock()
+		for _, client := range s.Clients {
+			client.stop()
+		}
+		s.clientsMutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *TcpServer) isShutdown() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
 }
 
 func (s *TcpServer) stop() {
 	s.lsn.Close()
 	// 关闭所有客户端
+	s.clientsMutex.Lock()
 	for _, client := range s.Clients {
 		client.stop()
 	}
+	s.clientsMutex.Unlock()
 	s.clientsWait.Wait()
 }
 
 func (s *TcpServer) listen() {
-	defer s.stop()
+	defer close(s.listenDone)
 	for {
 		conn, err := s.lsn.AcceptTCP()
 		if err != nil {
+			if s.isShutdown() {
+				// 正在优雅关闭，客户端由Shutdown负责关闭
+				return
+			}
 			logger.Error("Listener.Accept() error: %s", err.Error())
+			s.stop()
 			return
 		}
 		s.clientsMutex.Lock()
-		if len(s.Clients) >= MAX_CLIENT_NUM {
+		if len(s.Clients) >= s.options.MaxClientNum {
 			conn.Close()
 			logger.Fatal("len[%d] of clients More than maxClientNum!!!", len(s.Clients))
 		} else {
@@ -86,10 +206,35 @@ func (s *TcpServer) listen() {
 	}
 }
 
-func (s *TcpServer) runClient(conn *net.TCPConn) {
+func (s *TcpServer) runClient(tcpConn *net.TCPConn) {
 	defer s.clientsWait.Done()
 
-	tcpClient := NewTcpClient(conn, s.Processor, s.Factory)
+	setKeepAlive(tcpConn, s.options.KeepAlive)
+	var conn net.Conn = tcpConn
+	if nil != s.tlsServer {
+		tlsConn := tls.Server(tcpConn, s.tlsServer)
+		if err := tlsHandshake(tlsConn); nil != err {
+			logger.Error("client[%s] tls handshake failed, error[%s]", tcpConn.RemoteAddr().String(), err.Error())
+			return
+		}
+		conn = tlsConn
+	}
+	if s.isShutdown() {
+		// 握手期间服务已经关闭
+		conn.Close()
+		return
+	}
+
+	tcpClient := newTcpClient(conn, s.Processor, s.Factory, s.Clock, s.options)
+	if nil != s.InputOverload {
+		tcpClient.SetInputOverload(s.InputOverload)
+	}
+	if nil != s.Heartbeat {
+		tcpClient.SetHeartbeat(s.Heartbeat)
+	}
+	if nil != s.Auth {
+		tcpClient.SetAuth(s.Auth)
+	}
 	s.AddClient(conn, tcpClient)
 	logger.Debug("client[%s] connected", conn.RemoteAddr().String())
 
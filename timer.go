@@ -1,10 +1,12 @@
 package solidnet
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// 这里必须初始化定时消息处理器
+// NewTimer创建的定时器默认使用全局处理器，NewGame按配置创建了处理器时指向该处理器
 var (
 	TimerMsgprocessor IProcessor = GetProcessor()
 )
@@ -18,39 +20,69 @@ type ITimerHandler interface {
 	DoTimerAction(int32)
 }
 
+// 定时器由时钟对应的时间轮驱动。每次Start和Stop都会增加序号，超时消息带有触发时的序号，
+// 处理时序号不一致的消息会被丢弃，所以Stop之后定时器不会再触发，
+// 包括已经投递到处理器但还没有处理的超时消息
 type Timer struct {
-	timer     *time.Timer
+	mutex     sync.Mutex
 	id        int32
-	isLoop    bool
-	timeout   time.Duration
-	isRunning bool
+	seq       uint64
+	entry     *wheelEntry
+	wheel     *timingWheel
 	handler   ITimerHandler
+	processor IProcessor
 }
 
 func NewTimer(id int32, h ITimerHandler) *Timer {
+	return NewTimerWithProcessor(id, h, TimerMsgprocessor)
+}
+
+// 创建定时器，超时消息投递到指定的处理器
+func NewTimerWithProcessor(id int32, h ITimerHandler, p IProcessor) *Timer {
+	return NewTimerWithClock(id, h, p, RealClock)
+}
+
+// 创建使用指定时钟的定时器，测试时可以使用FakeClock
+func NewTimerWithClock(id int32, h ITimerHandler, p IProcessor, clock IClock) *Timer {
 	t := new(Timer)
 	t.id = id
 	t.handler = h
+	t.processor = p
+	t.wheel = getTimingWheel(clock)
 	return t
 }
 
+// 启动定时器，已经启动的定时器重新开始计时
 func (t *Timer) Start(timeout time.Duration, isLoop bool) {
-	t.isLoop = isLoop
-	t.timeout = timeout
-	t.timer = time.AfterFunc(timeout, t.procTimeout)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.stop()
+	t.entry = t.wheel.add(t, atomic.LoadUint64(&t.seq), timeout, isLoop)
 }
 
 func (t *Timer) Stop() {
-	if nil != t.timer {
-		t.timer.Stop()
-	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.stop()
 }
 
-func (t *Timer) procTimeout() {
-	message := &TimerMessage{t.id, t.handler}
-	TimerMsgprocessor.Dispatch(message)
+func (t *Timer) stop() {
+	atomic.AddUint64(&t.seq, 1)
+	if nil != t.entry {
+		t.wheel.remove(t.entry)
+		t.entry = nil
+	}
+}
 
-	if t.isLoop {
-		t.timer.Reset(t.timeout)
+// 到期，投递超时消息
+func (t *Timer) fire(seq uint64) {
+	if !t.isValid(seq) {
+		return
 	}
+	t.wheel.post(t.processor, &TimerMessage{t.id, t.handler, t, seq})
+}
+
+// 触发时的序号和当前序号一致，说明之后没有Stop或者重新Start
+func (t *Timer) isValid(seq uint64) bool {
+	return atomic.LoadUint64(&t.seq) == seq
 }
@@ -0,0 +1,302 @@
+package solidnet
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 分层时间轮：第0层256个槽，每个槽一个刻度，其余4层每层64个槽，
+// 每个槽覆盖下一层一整圈的时间，定时器的添加和删除都是O(1)。
+// 同一个时钟的所有定时器共用一个时间轮，不再为每个定时器创建time.Timer
+const (
+	WHEEL_TICK        = 10 * time.Millisecond // 刻度
+	WHEEL_ROOT_BITS   = 8
+	WHEEL_LEVEL_BITS  = 6
+	WHEEL_ROOT_SIZE   = 1 << WHEEL_ROOT_BITS
+	WHEEL_LEVEL_SIZE  = 1 << WHEEL_LEVEL_BITS
+	WHEEL_LEVELS      = 4             // 第0层之外的层数
+	WHEEL_MAX_TIMEOUT = (1 << 32) - 1 // 最长的定时刻度数
+)
+
+var (
+	wheels      = make(map[IClock]*timingWheel) // 每个时钟一个时间轮
+	wheelsMutex sync.Mutex
+)
+
+// 自己保存时间轮的时钟，例如FakeClock，时钟释放后时间轮随之释放
+type wheelClock interface {
+	timingWheel() *timingWheel
+}
+
+// 获取时钟对应的时间轮。不能作为map键的时钟每次返回新的时间轮，
+// 时间轮只在有定时项时驱动，不会一直占用时钟
+func getTimingWheel(clock IClock) *timingWheel {
+	if c, ok := clock.(wheelClock); ok {
+		return c.timingWheel()
+	}
+	if !reflect.TypeOf(clock).Comparable() {
+		return newTimingWheel(WHEEL_TICK, clock)
+	}
+	wheelsMutex.Lock()
+	defer wheelsMutex.Unlock()
+	w, ok := wheels[clock]
+	if !ok {
+		w = newTimingWheel(WHEEL_TICK, clock)
+		wheels[clock] = w
+	}
+	return w
+}
+
+// 时间轮中的定时项
+type wheelEntry struct {
+	timer   *Timer
+	f       func() // 不为空时到期在时间轮协程中直接调用，不投递超时消息
+	seq     uint64 // 添加时定时器的序号
+	expire  uint64 // 到期的刻度
+	period  uint64 // 循环定时的间隔刻度数，0表示只触发一次
+	slot    *list.List
+	element *list.Element
+}
+
+type timingWheel struct {
+	mutex     sync.Mutex
+	tick      time.Duration
+	clock     IClock
+	start     time.Time
+	current   uint64 // 下一个要处理的刻度
+	count     int    // 时间轮中的定时项数量
+	scheduled bool   // 已经注册了下一个刻度的驱动回调
+	root      [WHEEL_ROOT_SIZE]*list.List
+	levels    [WHEEL_LEVELS][WHEEL_LEVEL_SIZE]*list.List
+
+	outboxMutex sync.Mutex
+	outboxes    map[IProcessor]*timerOutbox // 有超时消息等待投递的处理器
+}
+
+// 处理器队列已满时，超时消息暂存在该处理器的投递队列中，由单独的协程按顺序投递，
+// 时间轮协程不会因为某个处理器阻塞而延迟其他定时器
+type timerOutbox struct {
+	processor IProcessor
+	messages  []IMessage
+}
+
+// 不等待的投递，队列已满时返回false，消息没有放入队列
+type offerer interface {
+	offer(message IMessage) bool
+}
+
+func newTimingWheel(tick time.Duration, clock IClock) *timingWheel {
+	w := new(timingWheel)
+	w.tick = tick
+	w.clock = clock
+	w.start = clock.Now()
+	w.outboxes = make(map[IProcessor]*timerOutbox)
+	for i := range w.root {
+		w.root[i] = list.New()
+	}
+	for i := range w.levels {
+		for j := range w.levels[i] {
+			w.levels[i][j] = list.New()
+		}
+	}
+	return w
+}
+
+// 时长换算为刻度数，不足一个刻度按一个刻度
+func (w *timingWheel) ticks(d time.Duration) uint64 {
+	n := uint64((d + w.tick - 1) / w.tick)
+	if n < 1 {
+		n = 1
+	}
+	if n > WHEEL_MAX_TIMEOUT {
+		n = WHEEL_MAX_TIMEOUT
+	}
+	return n
+}
+
+// 添加定时项
+func (w *timingWheel) add(t *Timer, seq uint64, timeout time.Duration, isLoop bool) *wheelEntry {
+	return w.insert(&wheelEntry{timer: t, seq: seq}, timeout, isLoop)
+}
+
+// 添加回调定时项，f在时间轮协程中调用，不能阻塞
+func (w *timingWheel) addFunc(f func(), timeout time.Duration, isLoop bool) *wheelEntry {
+	return w.insert(&wheelEntry{f: f}, timeout, isLoop)
+}
+
+func (w *timingWheel) insert(e *wheelEntry, timeout time.Duration, isLoop bool) *wheelEntry {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if 0 == w.count {
+		// 空闲期间没有驱动，直接跳到当前刻度
+		if now := w.now(); now > w.current {
+			w.current = now
+		}
+	}
+	n := w.ticks(timeout)
+	if isLoop {
+		e.period = n
+	}
+	// 从当前时间开始计算，刻度处理可能落后于实际时间
+	e.expire = w.now() + n
+	if e.expire < w.current {
+		e.expire = w.current
+	}
+	w.place(e)
+	w.count++
+	if !w.scheduled {
+		w.scheduled = true
+		w.schedule()
+	}
+	return e
+}
+
+// 删除定时项，已经删除或者已经触发的定时项不做处理
+func (w *timingWheel) remove(e *wheelEntry) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if nil != e.slot {
+		e.slot.Remove(e.element)
+		e.slot = nil
+		e.element = nil
+		w.count--
+	}
+}
+
+// 实际时间对应的刻度
+func (w *timingWheel) now() uint64 {
+	return uint64(w.clock.Now().Sub(w.start) / w.tick)
+}
+
+// 按到期刻度放入对应层的槽，调用前必须加锁
+func (w *timingWheel) place(e *wheelEntry) {
+	delta := e.expire - w.current
+	var slot *list.List
+	if delta < WHEEL_ROOT_SIZE {
+		slot = w.root[e.expire&(WHEEL_ROOT_SIZE-1)]
+	} else {
+		for level := 0; level < WHEEL_LEVELS; level++ {
+			shift := uint(WHEEL_ROOT_BITS + level*WHEEL_LEVEL_BITS)
+			if delta < 1<<(shift+WHEEL_LEVEL_BITS) || WHEEL_LEVELS-1 == level {
+				slot = w.levels[level][(e.expire>>shift)&(WHEEL_LEVEL_SIZE-1)]
+				break
+			}
+		}
+	}
+	e.slot = slot
+	e.element = slot.PushBack(e)
+}
+
+// 把上层槽中的定时项重新放入下层
+func (w *timingWheel) cascade(level int, index uint64) {
+	slot := w.levels[level][index]
+	for elem := slot.Front(); nil != elem; {
+		next := elem.Next()
+		e := slot.Remove(elem).(*wheelEntry)
+		w.place(e)
+		elem = next
+	}
+}
+
+// 处理所有到期的刻度，返回到期的定时项
+func (w *timingWheel) advance(target uint64) []*wheelEntry {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var expired []*wheelEntry
+	for ; w.current <= target; w.current++ {
+		index := w.current & (WHEEL_ROOT_SIZE - 1)
+		if 0 == index {
+			for level := 0; level < WHEEL_LEVELS; level++ {
+				shift := uint(WHEEL_ROOT_BITS + level*WHEEL_LEVEL_BITS)
+				i := (w.current >> shift) & (WHEEL_LEVEL_SIZE - 1)
+				w.cascade(level, i)
+				if 0 != i {
+					break
+				}
+			}
+		}
+
+		slot := w.root[index]
+		for elem := slot.Front(); nil != elem; {
+			next := elem.Next()
+			e := slot.Remove(elem).(*wheelEntry)
+			e.slot = nil
+			e.element = nil
+			expired = append(expired, e)
+			if e.period > 0 {
+				// 循环定时，重新放入时间轮
+				e.expire = w.current + e.period
+				w.place(e)
+			} else {
+				w.count--
+			}
+			elem = next
+		}
+	}
+	return expired
+}
+
+// 有定时项时每个刻度驱动一次，调用前必须加锁
+func (w *timingWheel) schedule() {
+	w.clock.AfterFunc(w.tick, w.onTick)
+}
+
+// 在时间轮协程中投递超时消息，不会阻塞
+func (w *timingWheel) post(p IProcessor, message IMessage) {
+	if !reflect.TypeOf(p).Comparable() {
+		go p.Dispatch(message)
+		return
+	}
+	w.outboxMutex.Lock()
+	defer w.outboxMutex.Unlock()
+	box, ok := w.outboxes[p]
+	if !ok {
+		// 没有等待投递的消息时直接放入处理器的队列，保证和之前的消息顺序一致
+		if o, ok := p.(offerer); ok && o.offer(message) {
+			return
+		}
+		box = &timerOutbox{processor: p}
+		w.outboxes[p] = box
+		go w.deliver(box)
+	}
+	box.messages = append(box.messages, message)
+}
+
+// 按顺序投递暂存的超时消息，全部投递完后退出
+func (w *timingWheel) deliver(box *timerOutbox) {
+	for {
+		w.outboxMutex.Lock()
+		if 0 == len(box.messages) {
+			delete(w.outboxes, box.processor)
+			w.outboxMutex.Unlock()
+			return
+		}
+		message := box.messages[0]
+		box.messages[0] = nil
+		box.messages = box.messages[1:]
+		w.outboxMutex.Unlock()
+		box.processor.Dispatch(message)
+	}
+}
+
+func (w *timingWheel) onTick() {
+	for _, e := range w.advance(w.now()) {
+		if nil != e.f {
+			e.f()
+		} else {
+			e.timer.fire(e.seq)
+		}
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if 0 == w.count {
+		// 没有定时项，停止驱动，下次添加时重新开始
+		w.scheduled = false
+		return
+	}
+	w.schedule()
+}
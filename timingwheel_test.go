@@ -0,0 +1,118 @@
+package solidnet
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type countTimerHandler struct {
+	n int
+}
+
+func (h *countTimerHandler) DoTimerAction(id int32) {
+	h.n++
+}
+
+func TestTimingWheelExpire(t *testing.T) {
+	w := newTimingWheel(time.Hour, RealClock)
+	r := rand.New(rand.NewSource(1))
+	want := make(map[*wheelEntry]uint64)
+	for i := 0; i < 5000; i++ {
+		// 覆盖第0层和所有上层
+		n := uint64(r.Int63n(1<<22)) + 1
+		if 0 == i%3 {
+			n = uint64(r.Intn(600)) + 1
+		}
+		e := &wheelEntry{expire: n}
+		w.mutex.Lock()
+		w.place(e)
+		w.count++
+		w.mutex.Unlock()
+		want[e] = n
+	}
+
+	fired := make(map[*wheelEntry]uint64)
+	for current := uint64(0); current <= 1<<22+1; current++ {
+		for _, e := range w.advance(current) {
+			fired[e] = current
+		}
+	}
+	for e, n := range want {
+		if fired[e] != n {
+			t.Fatalf("entry expires at %d, fired at %d", n, fired[e])
+		}
+	}
+}
+
+func TestTimerStopDiscardsFired(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	p := NewChannelProcessorWithLen(100)
+	timer := NewTimerWithClock(1, &countTimerHandler{}, p, clock)
+	timer.Start(30*time.Millisecond, true)
+	clock.Advance(95 * time.Millisecond)
+	if 3 != len(p.messageChannel) {
+		t.Fatalf("fired %d times, want 3", len(p.messageChannel))
+	}
+
+	// 停止之前已经投递的超时消息失效
+	timer.Stop()
+	clock.Advance(time.Second)
+	if 3 != len(p.messageChannel) {
+		t.Fatalf("fired after stop")
+	}
+	for 0 != len(p.messageChannel) {
+		if m := p.Epoll().(*TimerMessage); m.IsValid() {
+			t.Fatal("message fired before stop is still valid")
+		}
+	}
+}
+
+func TestTimerBlockedProcessor(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	blocked := NewChannelProcessorWithLen(1)
+	blocked.Dispatch(&TaskMessage{})
+	p := NewChannelProcessorWithLen(10)
+	NewTimerWithClock(1, &countTimerHandler{}, blocked, clock).Start(10*time.Millisecond, false)
+	NewTimerWithClock(2, &countTimerHandler{}, p, clock).Start(20*time.Millisecond, false)
+
+	// 一个处理器的队列已满，不能延迟其他处理器的定时器
+	done := make(chan struct{})
+	go func() {
+		clock.Advance(30 * time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timing wheel is blocked by a full processor")
+	}
+	if m, ok := p.Epoll().(*TimerMessage); !ok || 2 != m.id {
+		t.Fatal("timer of another processor does not fire")
+	}
+
+	// 队列有空间后，暂存的超时消息继续投递
+	blocked.Epoll()
+	if m, ok := blocked.Epoll().(*TimerMessage); !ok || 1 != m.id {
+		t.Fatal("timer message of the full processor is lost")
+	}
+}
+
+func BenchmarkTimingWheel(b *testing.B) {
+	p := NewChannelProcessorWithLen(1)
+	timer := NewTimerWithClock(1, &countTimerHandler{}, p, NewFakeClock(time.Unix(0, 0)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		timer.Start(time.Second, false)
+		timer.Stop()
+	}
+}
+
+func BenchmarkAfterFunc(b *testing.B) {
+	f := func() {}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		t := time.AfterFunc(time.Second, f)
+		t.Stop()
+	}
+}
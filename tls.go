@@ -0,0 +1,148 @@
+package solidnet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_HANDSHAKE_TIME = 10 // tls握手的最长时间
+)
+
+// tls配置，服务端和客户端共用
+type TlsConfig struct {
+	CertFile           string // 证书文件（服务端证书，或者双向认证时的客户端证书）
+	KeyFile            string // 私钥文件
+	CAFile             string // 校验对端证书的CA文件，为空时使用系统CA
+	VerifyPeer         bool   // 服务端是否要求并校验客户端证书
+	ServerName         string // 客户端校验服务端证书时使用的名称
+	InsecureSkipVerify bool   // 客户端不校验服务端证书，仅用于测试
+
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+}
+
+func NewTlsConfig(certFile string, keyFile string) *TlsConfig {
+	c := new(TlsConfig)
+	c.CertFile = certFile
+	c.KeyFile = keyFile
+	return c
+}
+
+// 重新加载证书，已经建立的连接不受影响，之后的新连接使用新证书
+func (c *TlsConfig) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if nil != err {
+		return err
+	}
+	c.mutex.Lock()
+	c.cert = &cert
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *TlsConfig) certificate() (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if nil == c.cert {
+		return nil, errors.New("certificate not loaded")
+	}
+	return c.cert, nil
+}
+
+func (c *TlsConfig) loadCA() (*x509.CertPool, error) {
+	if "" == c.CAFile {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(c.CAFile)
+	if nil != err {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no valid certificate in " + c.CAFile)
+	}
+	return pool, nil
+}
+
+// 生成服务端使用的tls.Config，证书通过回调获取，所以Reload之后立即生效
+func (c *TlsConfig) ServerConfig() (*tls.Config, error) {
+	if err := c.Reload(); nil != err {
+		return nil, err
+	}
+	pool, err := c.loadCA()
+	if nil != err {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.certificate()
+		},
+	}
+	if c.VerifyPeer {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
+
+// 生成客户端（主动连接）使用的tls.Config，CertFile非空时携带客户端证书
+func (c *TlsConfig) ClientConfig() (*tls.Config, error) {
+	pool, err := c.loadCA()
+	if nil != err {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		RootCAs:            pool,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if "" != c.CertFile {
+		if err := c.Reload(); nil != err {
+			return nil, err
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.certificate()
+		}
+	}
+	return config, nil
+}
+
+// 完成tls握手，握手失败时关闭连接
+func tlsHandshake(conn *tls.Conn) error {
+	conn.SetDeadline(time.Now().Add(time.Second * MAX_HANDSHAKE_TIME))
+	err := conn.Handshake()
+	if nil != err {
+		conn.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	return nil
+}
+
+// 建立tls连接
+func DialTls(addr string, config *TlsConfig) (net.Conn, error) {
+	tlsConfig, err := config.ClientConfig()
+	if nil != err {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", addr, time.Second*MAX_HANDSHAKE_TIME)
+	if nil != err {
+		return nil, err
+	}
+	if "" == tlsConfig.ServerName {
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsHandshake(tlsConn); nil != err {
+		return nil, err
+	}
+	return tlsConn, nil
+}
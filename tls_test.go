@@ -0,0 +1,141 @@
+package solidnet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 生成127.0.0.1的自签名证书，写入certFile和keyFile
+func writeTestCert(t *testing.T, certFile string, keyFile string, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != err {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); nil != err {
+		t.Fatal(err)
+	}
+}
+
+// 建立tls连接，返回服务端证书的名称
+func dialTestTls(t *testing.T, addr string, config *TlsConfig) (net.Conn, string) {
+	conn, err := DialTls(addr, config)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return conn, conn.(*tls.Conn).ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+// 发送一个包，等待服务端的逻辑层收到
+func echoTestTls(t *testing.T, conn net.Conn, p *ChannelProcessor, i int) {
+	if _, err := conn.Write(testPacket(i, 8)); nil != err {
+		t.Fatal(err)
+	}
+	for {
+		switch m := waitMessage(p, 5*time.Second).(type) {
+		case *NetMessage:
+			if int32(i) != testCmd(&BasePacket{Data: m.packet}) {
+				t.Fatalf("got packet %d, want %d", testCmd(&BasePacket{Data: m.packet}), i)
+			}
+			return
+		case nil:
+			t.Fatalf("packet %d is not received", i)
+		}
+	}
+}
+
+func TestTlsReloadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first")
+	caFile := filepath.Join(dir, "ca.pem")
+	data, err := ioutil.ReadFile(certFile)
+	if nil != err {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(caFile, data, 0644)
+
+	p := NewChannelProcessorWithLen(100)
+	s := NewTlsServer("127.0.0.1:0", NewTlsConfig(certFile, keyFile), p, testFactory{})
+	if !s.Start() {
+		t.Fatal("tls server is not started")
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	}()
+	addr := s.lsn.Addr().String()
+
+	// 校验服务端证书完成握手，之后和普通tcp客户端一样收发数据
+	first, name := dialTestTls(t, addr, &TlsConfig{CAFile: caFile})
+	defer first.Close()
+	if "first" != name {
+		t.Fatalf("server certificate is %s, want first", name)
+	}
+	if STATE_CONNECTED != waitState(t, p) {
+		t.Fatal("tls client is not connected")
+	}
+	echoTestTls(t, first, p, 1)
+
+	// 重新加载后新连接使用新证书，已经建立的连接不受影响
+	writeTestCert(t, certFile, keyFile, "second")
+	if err := s.ReloadCertificate(); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := DialTls(addr, &TlsConfig{CAFile: caFile}); nil == err {
+		t.Fatal("new certificate is verified by the old CA")
+	}
+	second, name := dialTestTls(t, addr, &TlsConfig{InsecureSkipVerify: true})
+	defer second.Close()
+	if "second" != name {
+		t.Fatalf("server certificate is %s after reload, want second", name)
+	}
+	echoTestTls(t, second, p, 2)
+	echoTestTls(t, first, p, 3)
+
+	// 证书文件有问题时保留原来的证书
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	if nil == s.ReloadCertificate() {
+		t.Fatal("broken key is loaded")
+	}
+	third, name := dialTestTls(t, addr, &TlsConfig{InsecureSkipVerify: true})
+	defer third.Close()
+	if "second" != name {
+		t.Fatalf("server certificate is %s after a failed reload, want second", name)
+	}
+}
@@ -0,0 +1,156 @@
+package solidnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 按比例随机丢弃收发的udp包，用于模拟丢包的网络
+type lossyPacketConn struct {
+	net.PacketConn
+	mutex sync.Mutex
+	rand  *rand.Rand
+	loss  float64 // 丢包率，0到1
+}
+
+func newLossyPacketConn(conn net.PacketConn, loss float64) *lossyPacketConn {
+	return &lossyPacketConn{PacketConn: conn, rand: rand.New(rand.NewSource(1)), loss: loss}
+}
+
+func (c *lossyPacketConn) drop() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.rand.Float64() < c.loss
+}
+
+func (c *lossyPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if nil != err || !c.drop() {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *lossyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.drop() {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func TestUdpServerLossyLoopback(t *testing.T) {
+	lsn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	p := NewChannelProcessorWithLen(10000)
+	s := NewUdpServer(lsn.LocalAddr().String(), p, testFactory{})
+	// 服务端收发都经过丢包，两个方向都需要重传
+	s.serve(newLossyPacketConn(lsn, 0.2))
+	defer s.stop()
+
+	conn, err := DialUdp(lsn.LocalAddr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	c := NewBaseClient(conn, testFactory{})
+	defer c.stop()
+
+	const N = 300
+	size := func(i int) int {
+		// 包含跨多个分片的数据包
+		return (i * 37) % 5000
+	}
+	for i := 0; i < N; i++ {
+		if !c.Send(testPacket(i, size(i))) {
+			t.Fatalf("send %d failed", i)
+		}
+	}
+
+	for i := 0; i < N; {
+		m := waitMessage(p, 30*time.Second)
+		if nil == m {
+			t.Fatalf("timeout, received %d of %d", i, N)
+		}
+		nm, ok := m.(*NetMessage)
+		if !ok {
+			continue
+		}
+		seq := int(binary.LittleEndian.Uint16(nm.packet[2:]))
+		if seq != i {
+			t.Fatalf("out of order: got %d, want %d", seq, i)
+		}
+		if !bytes.Equal(testPacket(i, size(i)), nm.packet) {
+			t.Fatalf("packet %d corrupted", i)
+		}
+		i++
+	}
+}
+
+func TestUdpSessionPinnedToFirstAddr(t *testing.T) {
+	first := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10001}
+	spoofed := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10002}
+	s := newUdpSession(1, nil, first, RealClock, func([]byte, net.Addr) error {
+		return nil
+	})
+
+	peer := newUdpSession(1, nil, nil, RealClock, nil)
+	s.input(peer.encode(UDP_CMD_PUSH, 0, []byte("spoofed")), spoofed)
+	if 0 != s.rcvNxt || s.RemoteAddr() != first {
+		t.Fatalf("packet from another address accepted, rcvNxt[%d] remote[%s]", s.rcvNxt, s.RemoteAddr())
+	}
+	s.input(peer.encode(UDP_CMD_PUSH, 0, []byte("hello")), first)
+	if 1 != s.rcvNxt {
+		t.Fatalf("packet from the first address dropped, rcvNxt[%d]", s.rcvNxt)
+	}
+}
+
+func TestUdpServerIgnoresClosedConv(t *testing.T) {
+	lsn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	p := NewChannelProcessorWithLen(100)
+	s := NewUdpServer(lsn.LocalAddr().String(), p, testFactory{})
+	s.serve(lsn)
+	defer s.stop()
+
+	conn, err := net.Dial("udp", lsn.LocalAddr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const conv = 42
+	push := newUdpSession(conv, nil, nil, RealClock, nil).encode(UDP_CMD_PUSH, 0, nil)
+	session := func() *udpSession {
+		s.clientsMutex.Lock()
+		defer s.clientsMutex.Unlock()
+		return s.sessions[conv]
+	}
+
+	conn.Write(push)
+	deadline := time.Now().Add(5 * time.Second)
+	for nil == session() {
+		if time.Now().After(deadline) {
+			t.Fatal("session is not created")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 会话结束后，迟到的重传包不能再创建会话
+	old := session()
+	old.closeWith(errUdpTimeout)
+	old.finish()
+	conn.Write(push)
+	time.Sleep(100 * time.Millisecond)
+	if nil != session() {
+		t.Fatal("late retransmission created a phantom session")
+	}
+}
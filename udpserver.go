@@ -0,0 +1,284 @@
+package solidnet
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	logger "github.com/idakun/tinylog"
+)
+
+// 可靠udp服务端，每个会话id对应一个客户端，产生的客户端和tcp客户端一样，
+// 同样通过IProcessor投递NetMessage和StateMessage
+type UdpServer struct {
+	Addr         string
+	Clients      map[net.Conn]*TcpClient
+	*clientIndex // 按连接id和用户id查找客户端
+	sessions     map[uint32]*udpSession
+	closed       map[uint32]time.Time // 最近结束的会话和结束时间，迟到的重传包不再创建会话
+	clientsWait  sync.WaitGroup
+	clientsMutex sync.Mutex
+	lsn          net.PacketConn
+	quit         chan struct{} // 关闭通知
+	quitOnce     sync.Once
+	closing      bool      // Shutdown已经开始关闭客户端，受clientsMutex保护
+	deadline     time.Time // 关闭客户端时发送剩余数据的截止时间
+
+	Processor     IProcessor
+	Factory       IPacketFactory
+	InputOverload *InputOverload // 客户端input队列的过载策略，为空时使用默认策略
+	Clock         IClock         // 客户端使用的时钟
+	Heartbeat     *Heartbeat     // 客户端的应用层心跳，为空时不发送ping
+	Auth          *Auth          // 客户端的登录认证，为空时由业务层自己认证
+	options       *Options
+}
+
+func NewUdpServer(addr string, processor IProcessor, f IPacketFactory, opts ...Option) *UdpServer {
+	s := new(UdpServer)
+	s.options = NewOptions(opts...)
+	s.Addr = addr
+	s.Processor = processor
+	s.Factory = f
+	s.Clock = RealClock
+	s.clientIndex = newClientIndex()
+	s.Clients = make(map[net.Conn]*TcpClient)
+	s.sessions = make(map[uint32]*udpSession)
+	s.closed = make(map[uint32]time.Time)
+	s.quit = make(chan struct{})
+	return s
+}
+
+func (s *UdpServer) Start() bool {
+	if err := s.options.Validate(); nil != err {
+		logger.Error("invalid options: %s", err.Error())
+		return false
+	}
+	if err := validateFactory(s.Factory); nil != err {
+		logger.Error("invalid packet factory: %s", err.Error())
+		return false
+	}
+	lsn, err := net.ListenPacket("udp", s.Addr)
+	if nil != err {
+		logger.Error("net.ListenPacket() error: %s", err.Error())
+		return false
+	}
+	s.serve(lsn)
+	return true
+}
+
+func (s *UdpServer) serve(lsn net.PacketConn) {
+	s.lsn = lsn
+	go s.listen()
+	go s.update()
+}
+
+func (s *UdpServer) AddClient(conn net.Conn, client *TcpClient) {
+	s.clientsMutex.Lock()
+	s.Clients[conn] = client
+	if s.closing {
+		// Shutdown已经遍历过客户端，关闭期间才加入的客户端在这里关闭
+		client.Shutdown(s.deadline)
+	}
+	logger.Debug("num of clients is : %d", len(s.Clients))
+	s.clientsMutex.Unlock()
+	s.addIndex(client)
+}
+
+func (s *UdpServer) DelClient(conn net.Conn) {
+	s.clientsMutex.Lock()
+	client, ok := s.Clients[conn]
+	delete(s.Clients, conn)
+	logger.Debug("num of clients is : %d", len(s.Clients))
+	s.clientsMutex.Unlock()
+	if ok {
+		s.delIndex(client)
+	}
+}
+
+func (s *UdpServer) isShutdown() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// 优雅关闭，语义和TcpServer.Shutdown一致
+func (s *UdpServer) Shutdown(ctx context.Context) error {
+	s.clientsMutex.Lock()
+	s.quitOnce.Do(func() {
+		close(s.quit)
+	})
+	deadline, _ := ctx.Deadline()
+	s.closing = true
+	s.deadline = deadline
+	for _, client := range s.Clients {
+		client.Shutdown(deadline)
+	}
+	s.clientsMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.clientsWait.Wait()
+		// 等待会话把剩余数据发送完毕
+		for {
+			s.clientsMutex.Lock()
+			n := len(s.sessions)
+			s.clientsMutex.Unlock()
+			if 0 == n {
+				break
+			}
+			timer := s.Clock.NewTimer(time.Millisecond * UDP_UPDATE_INTERVAL)
+			<-timer.C()
+		}
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// 超时，强制关闭剩余的客户端
+		s.clientsMutex.Lock()
+		for _, client := range s.Clients {
+			client.stop()
+		}
+		s.clientsMutex.Unlock()
+		err = ctx.Err()
+	}
+	s.lsn.Close()
+	return err
+}
+
+func (s *UdpServer) listen() {
+	buf := make([]byte, UDP_MTU*2)
+	for {
+		n, addr, err := s.lsn.ReadFrom(buf)
+		if nil != err {
+			if !s.isShutdown() {
+				logger.Error("PacketConn.ReadFrom() error: %s", err.Error())
+			}
+			s.stop()
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+
+		conv, cmd, sn, ok := parseUdpHead(data)
+		if !ok {
+			continue
+		}
+		s.clientsMutex.Lock()
+		session, ok := s.sessions[conv]
+		_, closed := s.closed[conv]
+		if !ok && !closed && UDP_CMD_PUSH == cmd && 0 == sn && !s.isShutdown() {
+			// 新会话的第一个可靠分片，其他未知会话的包直接丢弃
+			if len(s.sessions) >= s.options.MaxClientNum {
+				logger.Fatal("len[%d] of clients More than maxClientNum!!!", len(s.sessions))
+			} else {
+				session = s.newSession(conv, addr)
+				ok = true
+			}
+		}
+		s.clientsMutex.Unlock()
+		if ok {
+			session.input(data, addr)
+		}
+	}
+}
+
+// 创建会话，调用前必须加锁
+func (s *UdpServer) newSession(conv uint32, addr net.Addr) *udpSession {
+	session := newUdpSession(conv, s.lsn.LocalAddr(), addr, s.Clock, func(data []byte, remote net.Addr) error {
+		_, err := s.lsn.WriteTo(data, remote)
+		return err
+	})
+	session.onClose = func(session *udpSession) {
+		s.clientsMutex.Lock()
+		delete(s.sessions, session.conv)
+		s.closed[session.conv] = s.Clock.Now()
+		s.clientsMutex.Unlock()
+	}
+	s.sessions[conv] = session
+	s.clientsWait.Add(1)
+	go s.runClient(session)
+	return session
+}
+
+// 所有会话共用一个刷新协程
+func (s *UdpServer) update() {
+	sessions := make([]*udpSession, 0)
+	purged := s.Clock.Now()
+	for {
+		timer := s.Clock.NewTimer(time.Millisecond * UDP_UPDATE_INTERVAL)
+		now := <-timer.C()
+		s.clientsMutex.Lock()
+		if nil == s.sessions {
+			s.clientsMutex.Unlock()
+			return
+		}
+		sessions = sessions[:0]
+		for _, session := range s.sessions {
+			sessions = append(sessions, session)
+		}
+		if now.Sub(purged) >= time.Second {
+			// 超过会话超时时间，对端也已经放弃重传
+			purged = now
+			for conv, t := range s.closed {
+				if now.Sub(t) > time.Second*UDP_SESSION_TIMEOUT {
+					delete(s.closed, conv)
+				}
+			}
+		}
+		s.clientsMutex.Unlock()
+
+		for _, session := range sessions {
+			session.update(now)
+		}
+	}
+}
+
+func (s *UdpServer) stop() {
+	// 关闭所有客户端
+	s.clientsMutex.Lock()
+	for _, client := range s.Clients {
+		client.stop()
+	}
+	s.clientsMutex.Unlock()
+	s.clientsWait.Wait()
+
+	// 结束剩余的会话，并通知刷新协程退出
+	s.clientsMutex.Lock()
+	sessions := s.sessions
+	s.sessions = nil
+	s.clientsMutex.Unlock()
+	for _, session := range sessions {
+		session.closeWith(errUdpTimeout)
+		session.finish()
+	}
+}
+
+func (s *UdpServer) runClient(conn *udpSession) {
+	defer s.clientsWait.Done()
+
+	client := newTcpClient(conn, s.Processor, s.Factory, s.Clock, s.options)
+	if nil != s.InputOverload {
+		client.SetInputOverload(s.InputOverload)
+	}
+	if nil != s.Heartbeat {
+		client.SetHeartbeat(s.Heartbeat)
+	}
+	if nil != s.Auth {
+		client.SetAuth(s.Auth)
+	}
+	s.AddClient(conn, client)
+	logger.Debug("client[%s] connected", conn.RemoteAddr().String())
+
+	// 这里会阻塞，直到客户端结束才返回
+	client.Run()
+
+	s.DelClient(conn)
+	logger.Debug("client[%s] closed", conn.RemoteAddr().String())
+}
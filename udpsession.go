@@ -0,0 +1,552 @@
+package solidnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 基于udp的可靠传输(KCP风格的ARQ)：
+// 1、每个会话由conv（会话id）标识，可靠数据按分片编号，接收端逐个确认（选择确认），
+//    发送端只重传超时未确认的分片（选择重传）
+// 2、按序到达的可靠数据组成字节流，会话实现net.Conn，BaseClient的收发协程和
+//    IPacketFactory的包头包体解析可以直接复用
+// 3、不可靠数据每个udp包就是一个完整的业务包，不确认不重传
+
+const (
+	UDP_MTU             = 1400
+	UDP_HEAD_LEN        = 15 // conv(4) + cmd(1) + sn(4) + una(4) + len(2)
+	UDP_MSS             = UDP_MTU - UDP_HEAD_LEN
+	UDP_WINDOW          = 256  // 发送和接收窗口（分片数）
+	UDP_MAX_QUEUE       = 8192 // 发送队列最大分片数
+	UDP_UPDATE_INTERVAL = 10   // 刷新间隔，毫秒
+	UDP_DEFAULT_RTO     = 200  // 初始重传超时，毫秒
+	UDP_MIN_RTO         = 30   // 毫秒
+	UDP_MAX_RTO         = 5000 // 毫秒
+	UDP_MAX_XMIT        = 20   // 单个分片发送次数超过该值视为断线
+	UDP_PING_INTERVAL   = 10   // 超过该时间没有发送数据则发送保活包，秒
+	UDP_SESSION_TIMEOUT = 60   // 超过该时间没有收到数据视为断线，秒
+)
+
+// 会话命令
+const (
+	UDP_CMD_PUSH       = 1 // 可靠数据
+	UDP_CMD_ACK        = 2 // 确认，数据部分是被确认的分片编号列表
+	UDP_CMD_UNRELIABLE = 3 // 不可靠数据
+	UDP_CMD_PING       = 4 // 保活
+	UDP_CMD_CLOSE      = 5 // 关闭
+)
+
+var (
+	errUdpQueueFull = errors.New("udp send queue is full")
+	errUdpTimeout   = errors.New("udp session timeout")
+	errUdpTooLarge  = errors.New("unreliable packet more than UDP_MSS")
+)
+
+type udpSegment struct {
+	sn       uint32
+	data     []byte
+	ts       time.Time // 最近一次发送的时间
+	resendTs time.Time // 下次重传的时间
+	rto      time.Duration
+	xmit     int // 发送次数
+}
+
+type udpSession struct {
+	conv      uint32
+	mutex     sync.Mutex
+	localAddr net.Addr
+	remote    net.Addr // 第一个包的地址，创建后不再改变
+	clock     IClock
+	output    func([]byte, net.Addr) error // 发送udp包
+	onClose   func(*udpSession)            // 会话彻底结束时回调
+
+	sndNxt   uint32
+	sndQueue [][]byte      // 等待进入发送窗口的分片
+	sndBuf   []*udpSegment // 已发送未确认的分片，按编号排列
+	rcvNxt   uint32
+	rcvBuf   map[uint32][]byte // 乱序到达的分片
+	ackList  []uint32
+
+	srtt   time.Duration
+	rttvar time.Duration
+	rto    time.Duration
+
+	stream       bytes.Buffer // 按序到达的可靠数据
+	readEvent    chan struct{}
+	readDeadline time.Time
+	die          chan struct{} // 会话对使用者已经关闭
+	closeErr     error
+	closing      bool          // 本端已经关闭，等待剩余数据发送完毕
+	finished     chan struct{} // 会话彻底结束
+	finishOnce   sync.Once
+
+	lastRecv time.Time
+	lastSend time.Time
+
+	unreliableHandler func([]byte)
+}
+
+func newUdpSession(conv uint32, localAddr net.Addr, remote net.Addr, clock IClock, output func([]byte, net.Addr) error) *udpSession {
+	s := new(udpSession)
+	s.conv = conv
+	s.localAddr = localAddr
+	s.remote = remote
+	s.clock = clock
+	s.output = output
+	s.rcvBuf = make(map[uint32][]byte)
+	s.rto = time.Millisecond * UDP_DEFAULT_RTO
+	s.readEvent = make(chan struct{}, 1)
+	s.die = make(chan struct{})
+	s.finished = make(chan struct{})
+	s.lastRecv = clock.Now()
+	s.lastSend = s.lastRecv
+	return s
+}
+
+// 主动连接udp服务端，返回的会话可以直接交给NewTcpClient/NewBaseClient使用
+func DialUdp(addr string) (net.Conn, error) {
+	return DialUdpWithClock(addr, RealClock)
+}
+
+// 使用指定时钟计算重传、保活和会话超时的连接
+func DialUdpWithClock(addr string, clock IClock) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if nil != err {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if nil != err {
+		return nil, err
+	}
+
+	s := newUdpSession(rand.Uint32(), conn.LocalAddr(), raddr, clock, func(data []byte, _ net.Addr) error {
+		_, err := conn.Write(data)
+		return err
+	})
+	s.onClose = func(*udpSession) {
+		conn.Close()
+	}
+
+	// 接收协程
+	go func() {
+		buf := make([]byte, UDP_MTU*2)
+		for {
+			n, err := conn.Read(buf)
+			if nil != err {
+				s.closeWith(err)
+				s.finish()
+				return
+			}
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			s.input(data, raddr)
+		}
+	}()
+
+	// 刷新协程
+	go func() {
+		for {
+			timer := clock.NewTimer(time.Millisecond * UDP_UPDATE_INTERVAL)
+			select {
+			case now := <-timer.C():
+				s.update(now)
+			case <-s.finished:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+
+	// 发送一个空的可靠分片，服务端收到后建立会话
+	s.mutex.Lock()
+	s.sndQueue = append(s.sndQueue, []byte{})
+	s.flush(s.clock.Now())
+	s.mutex.Unlock()
+	return s, nil
+}
+
+func (s *udpSession) encode(cmd byte, sn uint32, body []byte) []byte {
+	buf := make([]byte, UDP_HEAD_LEN+len(body))
+	binary.LittleEndian.PutUint32(buf, s.conv)
+	buf[4] = cmd
+	binary.LittleEndian.PutUint32(buf[5:], sn)
+	binary.LittleEndian.PutUint32(buf[9:], s.rcvNxt)
+	binary.LittleEndian.PutUint16(buf[13:], uint16(len(body)))
+	copy(buf[UDP_HEAD_LEN:], body)
+	return buf
+}
+
+func (s *udpSession) send(now time.Time, cmd byte, sn uint32, body []byte) {
+	s.lastSend = now
+	s.output(s.encode(cmd, sn, body), s.remote)
+}
+
+// 解析udp包的会话id和命令
+func parseUdpHead(data []byte) (conv uint32, cmd byte, sn uint32, ok bool) {
+	if len(data) < UDP_HEAD_LEN {
+		return 0, 0, 0, false
+	}
+	length := int(binary.LittleEndian.Uint16(data[13:]))
+	if length != len(data)-UDP_HEAD_LEN {
+		return 0, 0, 0, false
+	}
+	return binary.LittleEndian.Uint32(data), data[4], binary.LittleEndian.Uint32(data[5:]), true
+}
+
+// 处理收到的udp包
+func (s *udpSession) input(data []byte, addr net.Addr) {
+	conv, cmd, sn, ok := parseUdpHead(data)
+	if !ok || conv != s.conv {
+		return
+	}
+	if addr.String() != s.remote.String() {
+		// 会话固定在第一个包的地址上，伪造会话id的包不能接管会话
+		return
+	}
+	una := binary.LittleEndian.Uint32(data[9:])
+	body := data[UDP_HEAD_LEN:]
+	now := s.clock.Now()
+
+	var unreliable func([]byte)
+	peerClosed := false
+
+	s.mutex.Lock()
+	s.lastRecv = now
+	s.ackUna(una)
+
+	switch cmd {
+	case UDP_CMD_PUSH:
+		s.ackList = append(s.ackList, sn)
+		diff := int32(sn - s.rcvNxt)
+		if diff >= 0 && diff < UDP_WINDOW {
+			if _, ok := s.rcvBuf[sn]; !ok {
+				s.rcvBuf[sn] = body
+			}
+		}
+		// 按序到达的分片放入字节流
+		moved := false
+		for {
+			seg, ok := s.rcvBuf[s.rcvNxt]
+			if !ok {
+				break
+			}
+			delete(s.rcvBuf, s.rcvNxt)
+			s.stream.Write(seg)
+			s.rcvNxt++
+			moved = true
+		}
+		if moved {
+			s.notifyRead()
+		}
+	case UDP_CMD_ACK:
+		for i := 0; i+4 <= len(body); i += 4 {
+			s.ack(binary.LittleEndian.Uint32(body[i:]), now)
+		}
+	case UDP_CMD_UNRELIABLE:
+		unreliable = s.unreliableHandler
+	case UDP_CMD_PING:
+		// 只用于刷新lastRecv
+	case UDP_CMD_CLOSE:
+		peerClosed = true
+	}
+
+	s.flush(now)
+	s.mutex.Unlock()
+
+	if nil != unreliable && len(body) > 0 {
+		unreliable(body)
+	}
+	if peerClosed {
+		s.closeWith(io.EOF)
+		s.finish()
+	}
+}
+
+// 对端已经按序收到una之前的所有分片
+func (s *udpSession) ackUna(una uint32) {
+	i := 0
+	for ; i < len(s.sndBuf); i++ {
+		if int32(s.sndBuf[i].sn-una) >= 0 {
+			break
+		}
+	}
+	if i > 0 {
+		s.sndBuf = s.sndBuf[i:]
+	}
+}
+
+// 确认单个分片，并更新rtt
+func (s *udpSession) ack(sn uint32, now time.Time) {
+	for i, seg := range s.sndBuf {
+		if seg.sn != sn {
+			continue
+		}
+		if 1 == seg.xmit {
+			s.updateRtt(now.Sub(seg.ts))
+		}
+		s.sndBuf = append(s.sndBuf[:i], s.sndBuf[i+1:]...)
+		return
+	}
+}
+
+func (s *udpSession) updateRtt(rtt time.Duration) {
+	if 0 == s.srtt {
+		s.srtt = rtt
+		s.rttvar = rtt / 2
+	} else {
+		delta := rtt - s.srtt
+		if delta < 0 {
+			delta = -delta
+		}
+		s.rttvar = (3*s.rttvar + delta) / 4
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
+	interval := time.Millisecond * UDP_UPDATE_INTERVAL
+	if 4*s.rttvar > interval {
+		interval = 4 * s.rttvar
+	}
+	s.rto = s.srtt + interval
+	if s.rto < time.Millisecond*UDP_MIN_RTO {
+		s.rto = time.Millisecond * UDP_MIN_RTO
+	}
+	if s.rto > time.Millisecond*UDP_MAX_RTO {
+		s.rto = time.Millisecond * UDP_MAX_RTO
+	}
+}
+
+// 发送确认、新分片和需要重传的分片，调用前必须加锁，返回false表示会话已经失效
+func (s *udpSession) flush(now time.Time) bool {
+	// 确认
+	for len(s.ackList) > 0 {
+		n := len(s.ackList)
+		if n > UDP_MSS/4 {
+			n = UDP_MSS / 4
+		}
+		body := make([]byte, n*4)
+		for i := 0; i < n; i++ {
+			binary.LittleEndian.PutUint32(body[i*4:], s.ackList[i])
+		}
+		s.send(now, UDP_CMD_ACK, 0, body)
+		s.ackList = s.ackList[n:]
+	}
+	s.ackList = nil
+
+	// 发送队列进入发送窗口，窗口从最早未确认的分片算起
+	for len(s.sndQueue) > 0 {
+		if len(s.sndBuf) > 0 && int32(s.sndNxt-s.sndBuf[0].sn) >= UDP_WINDOW {
+			break
+		}
+		s.sndBuf = append(s.sndBuf, &udpSegment{sn: s.sndNxt, data: s.sndQueue[0]})
+		s.sndNxt++
+		s.sndQueue = s.sndQueue[1:]
+	}
+
+	// 首次发送和超时重传
+	for _, seg := range s.sndBuf {
+		if 0 == seg.xmit {
+			seg.rto = s.rto
+		} else if now.Before(seg.resendTs) {
+			continue
+		} else {
+			seg.rto += seg.rto / 2
+			if seg.rto > time.Millisecond*UDP_MAX_RTO {
+				seg.rto = time.Millisecond * UDP_MAX_RTO
+			}
+		}
+		seg.xmit++
+		if seg.xmit > UDP_MAX_XMIT {
+			return false
+		}
+		seg.ts = now
+		seg.resendTs = now.Add(seg.rto)
+		s.send(now, UDP_CMD_PUSH, seg.sn, seg.data)
+	}
+
+	// 保活
+	if now.Sub(s.lastSend) > time.Second*UDP_PING_INTERVAL {
+		s.send(now, UDP_CMD_PING, 0, nil)
+	}
+	return now.Sub(s.lastRecv) <= time.Second*UDP_SESSION_TIMEOUT
+}
+
+// 定时刷新，由服务端或者DialUdp的刷新协程调用
+func (s *udpSession) update(now time.Time) {
+	s.mutex.Lock()
+	alive := s.flush(now)
+	done := s.closing && 0 == len(s.sndBuf) && 0 == len(s.sndQueue)
+	if alive && done {
+		// 本端关闭后剩余数据已经发送完毕，通知对端关闭
+		s.send(now, UDP_CMD_CLOSE, 0, nil)
+	}
+	s.mutex.Unlock()
+
+	if !alive {
+		s.closeWith(errUdpTimeout)
+		s.finish()
+	} else if done {
+		s.finish()
+	}
+}
+
+func (s *udpSession) notifyRead() {
+	select {
+	case s.readEvent <- struct{}{}:
+	default:
+	}
+}
+
+// 对使用者关闭会话，Read和Write不再可用
+func (s *udpSession) closeWith(err error) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.die:
+		return false
+	default:
+	}
+	s.closeErr = err
+	close(s.die)
+	return true
+}
+
+// 会话彻底结束
+func (s *udpSession) finish() {
+	s.finishOnce.Do(func() {
+		close(s.finished)
+		if nil != s.onClose {
+			s.onClose(s)
+		}
+	})
+}
+
+func (s *udpSession) Read(b []byte) (int, error) {
+	for {
+		s.mutex.Lock()
+		if s.stream.Len() > 0 {
+			n, _ := s.stream.Read(b)
+			s.mutex.Unlock()
+			return n, nil
+		}
+		select {
+		case <-s.die:
+			err := s.closeErr
+			s.mutex.Unlock()
+			return 0, err
+		default:
+		}
+		deadline := s.readDeadline
+		s.mutex.Unlock()
+
+		var timeout <-chan time.Time
+		var timer IChanTimer
+		if !deadline.IsZero() {
+			d := deadline.Sub(s.clock.Now())
+			if d <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = s.clock.NewTimer(d)
+			timeout = timer.C()
+		}
+		select {
+		case <-s.readEvent:
+		case <-s.die:
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+		if nil != timer {
+			timer.Stop()
+		}
+	}
+}
+
+func (s *udpSession) Write(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.die:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	n := (len(b) + UDP_MSS - 1) / UDP_MSS
+	if len(s.sndQueue)+n > UDP_MAX_QUEUE {
+		return 0, errUdpQueueFull
+	}
+	for i := 0; i < len(b); i += UDP_MSS {
+		end := i + UDP_MSS
+		if end > len(b) {
+			end = len(b)
+		}
+		seg := make([]byte, end-i)
+		copy(seg, b[i:end])
+		s.sndQueue = append(s.sndQueue, seg)
+	}
+	s.flush(s.clock.Now())
+	return len(b), nil
+}
+
+// 不可靠发送，data必须是一个完整的业务包
+func (s *udpSession) WriteUnreliable(data []byte) error {
+	if len(data) > UDP_MSS {
+		return errUdpTooLarge
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.die:
+		return io.ErrClosedPipe
+	default:
+	}
+	s.send(s.clock.Now(), UDP_CMD_UNRELIABLE, 0, data)
+	return nil
+}
+
+// 设置收到不可靠数据时的回调
+func (s *udpSession) SetUnreliableHandler(h func([]byte)) {
+	s.mutex.Lock()
+	s.unreliableHandler = h
+	s.mutex.Unlock()
+}
+
+// 本端关闭，剩余的可靠数据发送完毕后才通知对端并结束会话
+func (s *udpSession) Close() error {
+	if !s.closeWith(io.ErrClosedPipe) {
+		return nil
+	}
+	s.mutex.Lock()
+	s.closing = true
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *udpSession) LocalAddr() net.Addr {
+	return s.localAddr
+}
+
+func (s *udpSession) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+func (s *udpSession) SetDeadline(t time.Time) error {
+	return s.SetReadDeadline(t)
+}
+
+func (s *udpSession) SetReadDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.readDeadline = t
+	s.mutex.Unlock()
+	s.notifyRead()
+	return nil
+}
+
+// 写入只进入发送队列，不会阻塞
+func (s *udpSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
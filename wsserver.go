@@ -0,0 +1,297 @@
+package solidnet
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	logger "github.com/idakun/tinylog"
+)
+
+// websocket连接适配为net.Conn，多个二进制帧的数据按字节流读取，
+// 每次Write发送一个二进制帧，这样BaseClient的收发协程和IPacketFactory的
+// 包头包体解析都可以直接复用
+type wsConn struct {
+	ws     *websocket.Conn
+	reader io.Reader
+	mutex  sync.Mutex // websocket只允许一个协程同时写
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		if nil == c.reader {
+			messageType, r, err := c.ws.NextReader()
+			if nil != err {
+				return 0, err
+			}
+			if websocket.BinaryMessage != messageType {
+				// 只处理二进制帧，其他帧丢弃
+				continue
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(b)
+		if io.EOF == err {
+			// 当前帧读完，继续读下一帧
+			c.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	err := c.ws.WriteMessage(websocket.BinaryMessage, b)
+	if nil != err {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) Close() error {
+	return c.ws.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); nil != err {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
+
+// websocket服务端，在指定的http路径上接受连接，产生的客户端和tcp客户端一样，
+// 同样通过IProcessor投递NetMessage和StateMessage
+type WsServer struct {
+	Addr         string
+	Path         string
+	Clients      map[net.Conn]*TcpClient
+	*clientIndex                            // 按连接id和用户id查找客户端
+	CheckOrigin  func(r *http.Request) bool // 校验请求来源，为空时只允许同源请求
+	clientsWait  sync.WaitGroup
+	clientsMutex sync.Mutex
+	httpServer   *http.Server
+	upgrader     websocket.Upgrader
+	quit         chan struct{} // 关闭通知
+	quitOnce     sync.Once
+	closing      bool       // Shutdown已经开始关闭客户端，受clientsMutex保护
+	deadline     time.Time  // 关闭客户端时发送剩余数据的截止时间
+	tlsConfig    *TlsConfig // 非空时启用wss
+
+	Processor     IProcessor
+	Factory       IPacketFactory
+	InputOverload *InputOverload // 客户端input队列的过载策略，为空时使用默认策略
+	Clock         IClock         // 客户端使用的时钟
+	Heartbeat     *Heartbeat     // 客户端的应用层心跳，为空时不发送ping
+	Auth          *Auth          // 客户端的登录认证，为空时由业务层自己认证
+	options       *Options
+}
+
+func NewWsServer(addr string, path string, processor IProcessor, f IPacketFactory, opts ...Option) *WsServer {
+	s := new(WsServer)
+	s.options = NewOptions(opts...)
+	s.Addr = addr
+	s.Path = path
+	s.Processor = processor
+	s.Factory = f
+	s.Clock = RealClock
+	s.clientIndex = newClientIndex()
+	s.Clients = make(map[net.Conn]*TcpClient)
+	s.quit = make(chan struct{})
+	return s
+}
+
+// 创建启用tls的websocket服务端(wss)
+func NewWssServer(addr string, path string, config *TlsConfig, processor IProcessor, f IPacketFactory, opts ...Option) *WsServer {
+	s := NewWsServer(addr, path, processor, f, opts...)
+	s.tlsConfig = config
+	return s
+}
+
+func (s *WsServer) Start() bool {
+	if err := s.options.Validate(); nil != err {
+		logger.Error("invalid options: %s", err.Error())
+		return false
+	}
+	if err := validateFactory(s.Factory); nil != err {
+		logger.Error("invalid packet factory: %s", err.Error())
+		return false
+	}
+	lsn, err := net.Listen("tcp", s.Addr)
+	if nil != err {
+		logger.Error("net.Listen() error: %s", err.Error())
+		return false
+	}
+	if nil != s.tlsConfig {
+		config, err := s.tlsConfig.ServerConfig()
+		if nil != err {
+			lsn.Close()
+			logger.Error("load tls config error: %s", err.Error())
+			return false
+		}
+		lsn = tls.NewListener(lsn, config)
+	}
+
+	s.upgrader = websocket.Upgrader{
+		HandshakeTimeout: time.Second * MAX_HANDSHAKE_TIME,
+		CheckOrigin:      s.CheckOrigin,
+	}
+	mux := http.NewServeMux()
+	mux.Handle(s.Path, s)
+	s.httpServer = &http.Server{Handler: mux}
+
+	go func() {
+		err := s.httpServer.Serve(lsn)
+		if nil != err && http.ErrServerClosed != err {
+			logger.Error("httpServer.Serve() error: %s", err.Error())
+		}
+	}()
+	return true
+}
+
+// 实现 http.Handler
+func (s *WsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.clientsMutex.Lock()
+	if s.isShutdown() {
+		s.clientsMutex.Unlock()
+		http.Error(w, "server closed", http.StatusServiceUnavailable)
+		return
+	}
+	if len(s.Clients) >= s.options.MaxClientNum {
+		s.clientsMutex.Unlock()
+		logger.Fatal("len[%d] of clients More than maxClientNum!!!", len(s.Clients))
+		http.Error(w, "too many clients", http.StatusServiceUnavailable)
+		return
+	}
+	s.clientsWait.Add(1)
+	s.clientsMutex.Unlock()
+	defer s.clientsWait.Done()
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if nil != err {
+		logger.Error("upgrader.Upgrade() error: %s", err.Error())
+		return
+	}
+	ws.SetReadLimit(int64(s.options.MaxUserPacketLen) + int64(s.Factory.NewPacket().GetHeadLen()))
+
+	setKeepAlive(ws.UnderlyingConn(), s.options.KeepAlive)
+	conn := &wsConn{ws: ws}
+	client := newTcpClient(conn, s.Processor, s.Factory, s.Clock, s.options)
+	if nil != s.InputOverload {
+		client.SetInputOverload(s.InputOverload)
+	}
+	if nil != s.Heartbeat {
+		client.SetHeartbeat(s.Heartbeat)
+	}
+	if nil != s.Auth {
+		client.SetAuth(s.Auth)
+	}
+	s.AddClient(conn, client)
+	logger.Debug("client[%s] connected", conn.RemoteAddr().String())
+
+	// 这里会阻塞，直到客户端结束才返回
+	client.Run()
+
+	s.DelClient(conn)
+	logger.Debug("client[%s] closed", conn.RemoteAddr().String())
+}
+
+func (s *WsServer) AddClient(conn net.Conn, client *TcpClient) {
+	s.clientsMutex.Lock()
+	s.Clients[conn] = client
+	if s.closing {
+		// Shutdown已经遍历过客户端，关闭期间才加入的客户端在这里关闭
+		client.Shutdown(s.deadline)
+	}
+	logger.Debug("num of clients is : %d", len(s.Clients))
+	s.clientsMutex.Unlock()
+	s.addIndex(client)
+}
+
+func (s *WsServer) DelClient(conn net.Conn) {
+	s.clientsMutex.Lock()
+	client, ok := s.Clients[conn]
+	delete(s.Clients, conn)
+	logger.Debug("num of clients is : %d", len(s.Clients))
+	s.clientsMutex.Unlock()
+	if ok {
+		s.delIndex(client)
+	}
+}
+
+func (s *WsServer) isShutdown() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// 优雅关闭，语义和TcpServer.Shutdown一致
+func (s *WsServer) Shutdown(ctx context.Context) error {
+	s.clientsMutex.Lock()
+	s.quitOnce.Do(func() {
+		close(s.quit)
+	})
+	s.clientsMutex.Unlock()
+
+	// 停止接受新连接，已经升级的websocket连接不受影响
+	if nil != s.httpServer {
+		if err := s.httpServer.Shutdown(ctx); nil != err {
+			return err
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+	s.clientsMutex.Lock()
+	s.closing = true
+	s.deadline = deadline
+	for _, client := range s.Clients {
+		client.Shutdown(deadline)
+	}
+	s.clientsMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.clientsWait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// 超时，强制关闭剩余的客户端
+		s.clientsMutex.Lock()
+		for _, client := range s.Clients {
+			client.stop()
+		}
+		s.clientsMutex.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,128 @@
+package solidnet
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 启动监听本机空闲端口的websocket服务端
+func startTestWsServer(t *testing.T, p IProcessor) (*WsServer, string) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	addr := lsn.Addr().String()
+	lsn.Close()
+	s := NewWsServer(addr, "/ws", p, testFactory{})
+	if !s.Start() {
+		t.Fatal("websocket server is not started")
+	}
+	return s, "ws://" + addr + "/ws"
+}
+
+// 等待连接建立，返回服务端的客户端
+func waitWsClient(t *testing.T, p *ChannelProcessor) *TcpClient {
+	m, ok := waitMessage(p, 5*time.Second).(*StateMessage)
+	if !ok || STATE_CONNECTED != m.state {
+		t.Fatalf("got %v, want connected", m)
+	}
+	return m.client.(*TcpClient)
+}
+
+func TestWsServerFraming(t *testing.T) {
+	p := NewChannelProcessorWithLen(100)
+	s, url := startTestWsServer(t, p)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	}()
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	c := waitWsClient(t, p)
+
+	// 二进制帧按字节流拼接：一个包拆成两帧，两个包合成一帧，文本帧丢弃
+	first := testPacket(1, 16)
+	ws.WriteMessage(websocket.BinaryMessage, first[:6])
+	ws.WriteMessage(websocket.TextMessage, []byte("ignored"))
+	ws.WriteMessage(websocket.BinaryMessage, first[6:])
+	ws.WriteMessage(websocket.BinaryMessage, append(testPacket(2, 3), testPacket(3, 0)...))
+	for i := 1; i <= 3; i++ {
+		m, ok := waitMessage(p, 5*time.Second).(*NetMessage)
+		if !ok {
+			t.Fatalf("packet %d is not received", i)
+		}
+		if int32(i) != testCmd(&BasePacket{Data: m.packet}) || m.client != IClient(c) {
+			t.Fatalf("got packet %d, want %d", testCmd(&BasePacket{Data: m.packet}), i)
+		}
+	}
+
+	// 服务端每次发送一个二进制帧
+	for i := 4; i <= 5; i++ {
+		c.Send(testPacket(i, i))
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 4; i <= 5; i++ {
+		messageType, data, err := ws.ReadMessage()
+		if nil != err {
+			t.Fatal(err)
+		}
+		if websocket.BinaryMessage != messageType || string(testPacket(i, i)) != string(data) {
+			t.Fatalf("got frame type %d %v, want packet %d", messageType, data, i)
+		}
+	}
+}
+
+func TestWsServerClose(t *testing.T) {
+	p := NewChannelProcessorWithLen(100)
+	s, url := startTestWsServer(t, p)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	}()
+
+	// 对端发送关闭帧后断开，服务端删除该客户端
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	c := waitWsClient(t, p)
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	ws.Close()
+	if STATE_CLOSED != waitState(t, p) {
+		t.Fatal("client is not closed")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for nil != s.GetClient(c.GetId()) {
+		if time.Now().After(deadline) {
+			t.Fatal("closed client is not removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 服务端关闭连接，对端读到错误
+	ws, _, err = websocket.DefaultDialer.Dial(url, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	c = waitWsClient(t, p)
+	c.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := ws.ReadMessage(); nil == err {
+		t.Fatal("connection is not closed by the server")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("timeout waiting for the server to close")
+	}
+	if STATE_CLOSED != waitState(t, p) {
+		t.Fatal("client is not closed")
+	}
+}